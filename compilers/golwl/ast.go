@@ -0,0 +1,422 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The abstract syntax tree produced by parse and consumed by every later pass.
+// Every node knows where it came from so errors can point back to the source.
+
+type position struct {
+	file string
+	line int
+	col  int
+}
+
+func (p position) pos() position {
+	return p
+}
+
+type node interface {
+	pos() position
+}
+
+type expr interface {
+	node
+	exprNode()
+	exprType() *lwlType
+	setType(t *lwlType)
+	String() string
+}
+
+// typed holds the type of an expression, known once check went through it
+type typed struct {
+	typ *lwlType
+}
+
+// exprType is the type of the expression, until checked every expression is an i64
+func (t *typed) exprType() *lwlType {
+	if t.typ == nil {
+		return typeI64
+	}
+	return t.typ
+}
+
+func (t *typed) setType(typ *lwlType) {
+	t.typ = typ
+}
+
+// funcDecl is a function declaration: name(params):result = body
+// the main function, main = body, has no name nor params, only a body
+type funcDecl struct {
+	position
+	name   string
+	params []*ident // the type of each one is its annotation, if any
+	result *lwlType // nil without annotation
+	body   expr
+	main   bool
+
+	exported bool // other modules can call it
+}
+
+// module is the module the function belongs to, empty for the main module
+func (f *funcDecl) module() string {
+	module, _, qualified := strings.Cut(f.name, ".")
+	if !qualified {
+		return ""
+	}
+	return module
+}
+
+// resultType is the type the function returns, i64 unless annotated
+func (f *funcDecl) resultType() *lwlType {
+	if f.result == nil {
+		return typeI64
+	}
+	return f.result
+}
+
+// literal is an integer constant, every integer is a signed 64 bit one
+type literal struct {
+	position
+	typed
+	value int64
+}
+
+// ident is a reference to a function parameter
+type ident struct {
+	position
+	typed
+	name string
+}
+
+// unaryExpr is op operand, only negation for now
+type unaryExpr struct {
+	position
+	typed
+	op      tokenType
+	operand expr
+}
+
+// binaryExpr is lhs op rhs, where op is one of the operator tokens, a comparison results in 0 or 1
+// of whatever integer type its context expects, just like a constant
+type binaryExpr struct {
+	position
+	typed
+	op  tokenType
+	lhs expr
+	rhs expr
+}
+
+// condExpr is cond ? then : els, only evaluating then when cond is not 0 and els otherwise
+type condExpr struct {
+	position
+	typed
+	cond expr
+	then expr
+	els  expr
+}
+
+// callExpr is name(args...)
+type callExpr struct {
+	position
+	typed
+	name string
+	args []expr
+}
+
+// castExpr is type(operand), converting the operand to the type: truncating it to a narrower one
+// and sign or zero extending it to a wider one
+type castExpr struct {
+	position
+	typed
+	operand expr
+}
+
+// fieldExpr is operand.name, reading a field of a struct
+type fieldExpr struct {
+	position
+	typed
+	operand expr
+	name    string
+	field   *field // resolved by check
+}
+
+// structLit is type{name: value, ...}, a struct built from the values of its fields,
+// its type is the struct named, known as soon as it is parsed
+type structLit struct {
+	position
+	typed
+	fields []*fieldInit // in the order written
+}
+
+// fieldInit is name: value inside a struct literal
+type fieldInit struct {
+	position
+	name  string
+	value expr
+	field *field // resolved by check
+}
+
+// arrayLit is [length]type{values...}, an array built from the values of its elements,
+// its type is the array written, known as soon as it is parsed
+type arrayLit struct {
+	position
+	typed
+	elems []expr
+}
+
+// indexExpr is operand[index], reading an element of an array
+type indexExpr struct {
+	position
+	typed
+	operand expr
+	index   expr
+}
+
+// letExpr is let name = value in body, the name holds the value within the body alone and hides any
+// variable named alike there, its type is the annotation of the name or else the one of the value
+type letExpr struct {
+	position
+	typed
+	name  *ident // annotated just like a parameter, if at all
+	value expr
+	body  expr
+}
+
+// lenExpr is len(operand), the length of an array, known when type checking so it is a constant
+// and the operand is never evaluated
+type lenExpr struct {
+	position
+	typed
+	operand expr
+	length  int // resolved by check
+}
+
+func (*literal) exprNode()    {}
+func (*ident) exprNode()      {}
+func (*unaryExpr) exprNode()  {}
+func (*binaryExpr) exprNode() {}
+func (*condExpr) exprNode()   {}
+func (*callExpr) exprNode()   {}
+func (*castExpr) exprNode()   {}
+func (*fieldExpr) exprNode()  {}
+func (*structLit) exprNode()  {}
+func (*arrayLit) exprNode()   {}
+func (*indexExpr) exprNode()  {}
+func (*lenExpr) exprNode()    {}
+func (*letExpr) exprNode()    {}
+
+// constantIndex is the value of an index known without running the program: a constant, maybe negated,
+// or the length of an array once check resolved it
+func constantIndex(e expr) (int64, bool) {
+	switch e := e.(type) {
+	case *literal:
+		return e.value, true
+	case *unaryExpr:
+		if v, ok := constantIndex(e.operand); ok && e.op == tsub {
+			return -v, true
+		}
+	case *lenExpr:
+		return int64(e.length), true
+	}
+	return 0, false
+}
+
+// walk calls visit for e and then for every expression inside it
+func walk(e expr, visit func(expr)) {
+	visit(e)
+	switch e := e.(type) {
+	case *unaryExpr:
+		walk(e.operand, visit)
+	case *binaryExpr:
+		walk(e.lhs, visit)
+		walk(e.rhs, visit)
+	case *condExpr:
+		walk(e.cond, visit)
+		walk(e.then, visit)
+		walk(e.els, visit)
+	case *callExpr:
+		for _, arg := range e.args {
+			walk(arg, visit)
+		}
+	case *castExpr:
+		walk(e.operand, visit)
+	case *fieldExpr:
+		walk(e.operand, visit)
+	case *structLit:
+		for _, f := range e.fields {
+			walk(f.value, visit)
+		}
+	case *arrayLit:
+		for _, elem := range e.elems {
+			walk(elem, visit)
+		}
+	case *indexExpr:
+		walk(e.operand, visit)
+		walk(e.index, visit)
+	case *lenExpr:
+		walk(e.operand, visit)
+	case *letExpr:
+		walk(e.value, visit)
+		walk(e.body, visit)
+	}
+}
+
+// usedTypes are the types of the given kind the declarations use, in the order they are first used
+func usedTypes(decls []*funcDecl, kind typeKind) []*lwlType {
+	types := make([]*lwlType, 0)
+	add := func(t *lwlType) {
+		if t != nil && t.kind == kind && !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	for _, d := range decls {
+		for _, p := range d.params {
+			add(p.typ)
+		}
+		add(d.result)
+		if d.body != nil {
+			walk(d.body, func(e expr) { add(e.exprType()) })
+		}
+	}
+	return types
+}
+
+// structTypes are the struct types the declarations use, in the order they were declared
+func structTypes(decls []*funcDecl) []*lwlType {
+	types := usedTypes(decls, kindStruct)
+	slices.SortFunc(types, func(a, b *lwlType) int {
+		return cmp.Or(strings.Compare(a.file, b.file), cmp.Compare(a.line, b.line))
+	})
+	return types
+}
+
+var operatorSymbols = map[tokenType]string{
+	tadd:  "+",
+	tsub:  "-",
+	tmul:  "*",
+	tdiv:  "/",
+	tmod:  "%",
+	teqeq: "==",
+	tneq:  "!=",
+	tlt:   "<",
+	tle:   "<=",
+	tgt:   ">",
+	tge:   ">=",
+}
+
+// comparesSigned reports if the comparison b compares its operands as signed integers, which it does
+// unless both are unsigned since otherwise the type both are widened to is a signed one
+func (b *binaryExpr) comparesSigned() bool {
+	return b.lhs.exprType().signed || b.rhs.exprType().signed
+}
+
+// The String methods render the tree as s-expressions, handy for tests and debugging
+
+func (f *funcDecl) String() string {
+	if f.main {
+		return "main = " + f.body.String()
+	}
+	params := make([]string, 0, len(f.params))
+	for _, p := range f.params {
+		params = append(params, p.name+annotation(p.typ))
+	}
+	export := ""
+	if f.exported {
+		export = "export "
+	}
+	return export + f.name + "(" + strings.Join(params, ", ") + ")" + annotation(f.result) + " = " + f.body.String()
+}
+
+// annotation renders the type annotation of a declaration, if any
+func annotation(t *lwlType) string {
+	if t == nil {
+		return ""
+	}
+	return ":" + t.String()
+}
+
+func (l *literal) String() string {
+	return strconv.FormatInt(l.value, 10)
+}
+
+func (i *ident) String() string {
+	return i.name
+}
+
+func (u *unaryExpr) String() string {
+	return "(" + operatorSymbols[u.op] + " " + u.operand.String() + ")"
+}
+
+func (b *binaryExpr) String() string {
+	return "(" + operatorSymbols[b.op] + " " + b.lhs.String() + " " + b.rhs.String() + ")"
+}
+
+func (c *condExpr) String() string {
+	return "(? " + c.cond.String() + " " + c.then.String() + " " + c.els.String() + ")"
+}
+
+func (c *callExpr) String() string {
+	s := "(" + c.name
+	for _, arg := range c.args {
+		s += " " + arg.String()
+	}
+	return s + ")"
+}
+
+func (c *castExpr) String() string {
+	return "(" + c.exprType().String() + " " + c.operand.String() + ")"
+}
+
+func (f *fieldExpr) String() string {
+	return "(. " + f.operand.String() + " " + f.name + ")"
+}
+
+func (s *structLit) String() string {
+	fields := make([]string, 0, len(s.fields))
+	for _, f := range s.fields {
+		fields = append(fields, f.name+": "+f.value.String())
+	}
+	return s.exprType().String() + "{" + strings.Join(fields, ", ") + "}"
+}
+
+func (a *arrayLit) String() string {
+	elems := make([]string, 0, len(a.elems))
+	for _, e := range a.elems {
+		elems = append(elems, e.String())
+	}
+	return a.exprType().String() + "{" + strings.Join(elems, ", ") + "}"
+}
+
+func (i *indexExpr) String() string {
+	return "([] " + i.operand.String() + " " + i.index.String() + ")"
+}
+
+func (l *lenExpr) String() string {
+	return "(len " + l.operand.String() + ")"
+}
+
+func (l *letExpr) String() string {
+	return "(let " + l.name.name + annotation(l.name.typ) + " " + l.value.String() + " " + l.body.String() + ")"
+}
+
+// dumpAST writes every struct and function declaration in its own line
+func dumpAST(w io.Writer, decls []*funcDecl) error {
+	for _, t := range structTypes(decls) {
+		if _, err := fmt.Fprintln(w, t.declaration()); err != nil {
+			return err
+		}
+	}
+	for _, d := range decls {
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
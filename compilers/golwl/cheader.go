@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// cKeywords can not be used as function names from C
+var cKeywords = []string{
+	"auto", "break", "case", "char", "const", "continue", "default", "do", "double", "else", "enum",
+	"extern", "float", "for", "goto", "if", "inline", "int", "long", "register", "restrict", "return",
+	"short", "signed", "sizeof", "static", "struct", "switch", "typedef", "union", "unsigned", "void",
+	"volatile", "while", "_Bool", "_Complex", "_Imaginary", "bool", "true", "false",
+}
+
+// cTypes are the C types matching ours on the System V ABI, where long is 64 bits
+var cTypes = map[*lwlType]string{
+	typeI8: "signed char", typeI16: "short", typeI32: "int", typeI64: "long",
+	typeU8: "unsigned char", typeU16: "unsigned short", typeU32: "unsigned int", typeU64: "unsigned long",
+}
+
+// cType is the C type matching t, structs are laid out just like C does and arrays,
+// which C can not pass around by value, are wrapped in a struct with the same layout
+func cType(t *lwlType) string {
+	switch t.kind {
+	case kindInt:
+		return cTypes[t]
+	case kindArray:
+		return fmt.Sprintf("struct lwl_array_%v_%v", t.elem, t.length)
+	}
+	return "struct " + t.name
+}
+
+// writeCHeader writes the C definitions of the structs and arrays and the prototypes of every function of the
+// main module but main, to call them from C once linked against a library built with -lib
+func writeCHeader(w io.Writer, decls []*funcDecl, name string) error {
+	guard := strings.Builder{}
+	guard.WriteString("LWL_")
+	for _, r := range strings.ToUpper(name) {
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			guard.WriteRune(r)
+		} else {
+			guard.WriteRune('_')
+		}
+	}
+	guard.WriteString("_H")
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "/* generated by golwl %v, do not edit */\n", version)
+	fmt.Fprintf(&b, "#ifndef %v\n#define %v\n\n", guard.String(), guard.String())
+	types := structTypes(decls)
+	for _, t := range types {
+		if slices.Contains(cKeywords, t.name) {
+			return fmt.Errorf("%v:%v: struct %v can not be used from C, its name is a C keyword", t.file, t.line, t.name)
+		}
+		fields := make([]string, 0, len(t.fields))
+		for _, f := range t.fields {
+			if slices.Contains(cKeywords, f.name) {
+				return fmt.Errorf("%v:%v: field %v of %v can not be used from C, its name is a C keyword", t.file, t.line, f.name, t.name)
+			}
+			fields = append(fields, cTypes[f.typ]+" "+f.name+";")
+		}
+		fmt.Fprintf(&b, "struct %v { %v };\n", t.name, strings.Join(fields, " "))
+	}
+	arrays := usedTypes(decls, kindArray)
+	for _, t := range arrays {
+		fmt.Fprintf(&b, "%v { %v items[%v]; };\n", cType(t), cTypes[t.elem], t.length)
+	}
+	if len(types) > 0 || len(arrays) > 0 {
+		b.WriteString("\n")
+	}
+	for _, d := range decls {
+		if d.main || d.module() != "" {
+			continue
+		}
+		if slices.Contains(cKeywords, d.name) {
+			return fmt.Errorf("%v:%v: function %v can not be called from C, its name is a C keyword", d.file, d.line, d.name)
+		}
+		params := make([]string, 0, len(d.params))
+		for _, p := range d.params {
+			params = append(params, cType(p.exprType()))
+		}
+		if len(params) == 0 {
+			params = append(params, "void")
+		}
+		fmt.Fprintf(&b, "%v %v(%v);\n", cType(d.resultType()), d.name, strings.Join(params, ", "))
+	}
+	fmt.Fprintf(&b, "\n#endif /* %v */\n", guard.String())
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_writeCHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		libName string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "prototypes of every function but main",
+			source:  "f(x,y)=x+y\ng()=1\nmain = f(g(),2)\n",
+			libName: "my-lib.v2",
+			want: "#ifndef LWL_MY_LIB_V2_H\n#define LWL_MY_LIB_V2_H\n\n" +
+				"long f(long, long);\n" +
+				"long g(void);\n" +
+				"\n#endif /* LWL_MY_LIB_V2_H */\n",
+		},
+		{
+			name:    "sized types",
+			source:  "f(a:i8,b:i16,c:i32,d:i64,e:u8,g:u16,h:u32,i:u64):u8=e\n",
+			libName: "lib",
+			want: "unsigned char f(signed char, short, int, long, unsigned char, unsigned short, unsigned int, unsigned long);\n" +
+				"\n#endif /* LWL_LIB_H */\n",
+		},
+		{
+			name:    "struct definitions",
+			source:  "struct point { x:i32, y }\nf(p:point, k:u8):point = p\n",
+			libName: "lib",
+			want: "struct point { int x; long y; };\n\n" +
+				"struct point f(struct point, unsigned char);\n" +
+				"\n#endif /* LWL_LIB_H */\n",
+		},
+		{
+			name:    "arrays wrapped in structs",
+			source:  "f(a:[4]u8):[2]i32 = [2]i32{a[0], len(a)}\n",
+			libName: "lib",
+			want: "struct lwl_array_u8_4 { unsigned char items[4]; };\nstruct lwl_array_i32_2 { int items[2]; };\n\n" +
+				"struct lwl_array_i32_2 f(struct lwl_array_u8_4);\n" +
+				"\n#endif /* LWL_LIB_H */\n",
+		},
+		{
+			name:    "fields that are C keywords",
+			source:  "struct s { int }\nf(p:s)=p.int\n",
+			libName: "lib",
+			wantErr: true,
+		},
+		{
+			name:    "names that are C keywords",
+			source:  "int(x)=x\n",
+			libName: "lib",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			decls, err := parse(tokenizeSource(t, tc.source))
+			if err != nil && !errors.Is(err, errNoMain) {
+				t.Fatalf("parse() error = %v", err)
+			}
+			checkTypes(t, decls)
+			b := bytes.Buffer{}
+			err = writeCHeader(&b, decls, tc.libName)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("writeCHeader() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got := b.String(); !tc.wantErr && !strings.HasSuffix(got, tc.want) {
+				t.Errorf("writeCHeader() =\n%v\nwant\n%v", got, tc.want)
+			}
+		})
+	}
+}
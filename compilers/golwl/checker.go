@@ -0,0 +1,441 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// This is the type checker, it runs after parse and gives every expression its type.
+// Constants take the type their context expects, a value can be used wherever a type holding
+// all of its values is expected, and anything narrowing it needs an explicit cast.
+// Structs and arrays are only ever used as a whole where the very same type is expected, operators
+// and casts work on integers alone, so the way to compute with them is through their fields and elements.
+// Indexing an array with a constant is checked here, any other index is checked when the program runs.
+// Comparisons result in 0 or 1, which fit in any integer, so just like constants they take the type of
+// their context, while their operands are widened to a common type as any other operator does.
+
+var (
+	errTypes = errors.New("type error")
+)
+
+type checker struct {
+	functions map[string]*funcDecl
+	decl      *funcDecl
+	variables map[string]*lwlType
+	invalid   map[expr]bool // expressions already reported, so their errors do not pile up
+	diags     []diagnostic
+}
+
+// check infers the type of every expression and checks it against the annotations of the functions
+func check(decls []*funcDecl) ([]diagnostic, error) {
+	c := &checker{functions: make(map[string]*funcDecl), invalid: make(map[expr]bool), diags: make([]diagnostic, 0)}
+	for _, d := range decls {
+		if !d.main {
+			c.functions[d.name] = d
+		}
+	}
+	for _, d := range decls {
+		c.decl = d
+		c.variables = make(map[string]*lwlType, len(d.params))
+		for _, p := range d.params {
+			c.variables[p.name] = p.exprType()
+		}
+		if d.main {
+			// whatever main results in becomes the exit code
+			c.convert(d.body, c.infer(d.body), typeI64, "the result of main")
+			continue
+		}
+		c.convert(d.body, c.infer(d.body), d.resultType(), "the result of "+d.name)
+	}
+
+	if foundErrors := countErrors(c.diags); foundErrors > 0 {
+		return c.diags, fmt.Errorf("%w %v found errors", errTypes, foundErrors)
+	}
+	return c.diags, nil
+}
+
+func (c *checker) errorAt(e expr, code diagnosticCode, msg string, notes ...string) {
+	c.diags = append(c.diags, newDiagnostic(severityError, code, e.pos(), width(e), msg, notes...))
+}
+
+// width is the number of columns the start of the expression spans, to underline it
+func width(e expr) int {
+	switch e := e.(type) {
+	case *literal:
+		return len(e.String())
+	case *ident:
+		return len(e.name)
+	case *callExpr:
+		return len(e.name)
+	case *castExpr:
+		return len(e.exprType().String())
+	case *fieldExpr:
+		return len(e.name)
+	case *structLit, *arrayLit:
+		return len(e.exprType().String())
+	case *lenExpr:
+		return len(builtinLen)
+	case *letExpr:
+		return len("let")
+	case *binaryExpr:
+		return len(operatorSymbols[e.op])
+	}
+	return 1
+}
+
+// infer returns the type of e, or nil for constant expressions which take the type of their context
+func (c *checker) infer(e expr) *lwlType {
+	var t *lwlType
+	switch e := e.(type) {
+	case *literal:
+		return nil
+	case *ident:
+		t = c.variables[e.name]
+	case *unaryExpr:
+		if t = c.infer(e.operand); t == nil {
+			return nil
+		}
+		c.invalid[e] = c.invalid[e.operand]
+		if !t.isInt() {
+			c.invalidOperand(e, e.operand, t)
+			t = typeI64
+		}
+	case *binaryExpr:
+		lhs, rhs := c.infer(e.lhs), c.infer(e.rhs)
+		switch {
+		case lhs != nil && !lhs.isInt() || rhs != nil && !rhs.isInt():
+			if lhs == nil || lhs.isInt() {
+				c.invalidOperand(e, e.rhs, rhs)
+			} else {
+				c.invalidOperand(e, e.lhs, lhs)
+			}
+			t = typeI64
+		case lhs == nil && rhs == nil:
+			t = nil
+		case lhs == nil:
+			c.settle(e.lhs, rhs)
+			t = rhs
+		case rhs == nil:
+			c.settle(e.rhs, lhs)
+			t = lhs
+		default:
+			var ok bool
+			if t, ok = commonType(lhs, rhs); !ok {
+				if !c.invalid[e.lhs] && !c.invalid[e.rhs] {
+					c.errorAt(e, codeMismatchedTypes, fmt.Sprintf("mismatched types %v and %v", lhs, rhs),
+						fmt.Sprintf("neither holds every value of the other, cast one of them: %v(...) or %v(...)", lhs, rhs))
+				}
+				t = lhs
+				c.invalid[e] = true
+			}
+		}
+		c.invalid[e] = c.invalid[e] || c.invalid[e.lhs] || c.invalid[e.rhs]
+		switch {
+		case isComparison(e.op) && t == nil:
+			c.settle(e.lhs, typeI64)
+			c.settle(e.rhs, typeI64)
+			return nil
+		case isComparison(e.op), t == nil:
+			return nil
+		}
+	case *condExpr:
+		if t = c.conditional(e); t == nil {
+			return nil
+		}
+	case *castExpr:
+		switch from := c.infer(e.operand); {
+		case from == nil:
+			c.settle(e.operand, typeI64)
+		case !from.isInt() && !c.invalid[e.operand]:
+			c.errorAt(e, codeInvalidOperand, fmt.Sprintf("cannot cast %v to %v", from.describe(), e.exprType()), "only integers can be cast")
+			c.invalid[e] = true
+		}
+		t = e.exprType()
+	case *fieldExpr:
+		t = c.field(e)
+	case *structLit:
+		c.structLit(e)
+		t = e.exprType()
+	case *arrayLit:
+		c.arrayLit(e)
+		t = e.exprType()
+	case *indexExpr:
+		t = c.index(e)
+	case *lenExpr:
+		c.length(e)
+		return nil
+	case *letExpr:
+		if t = c.let(e); t == nil {
+			return nil
+		}
+	case *callExpr:
+		f, ok := c.functions[e.name]
+		if !ok || len(f.params) != len(e.args) {
+			return typeI64 // already reported by parse
+		}
+		for i, arg := range e.args {
+			c.convert(arg, c.infer(arg), f.params[i].exprType(), fmt.Sprintf("argument %v of %v", f.params[i].name, e.name))
+		}
+		t = f.resultType()
+	}
+	if t == nil {
+		t = typeI64
+	}
+	e.setType(t)
+	return t
+}
+
+// invalidOperand reports the operator of e can not be applied to the operand of type t, a struct or an array
+func (c *checker) invalidOperand(e, operand expr, t *lwlType) {
+	if !c.invalid[operand] {
+		var op tokenType
+		switch e := e.(type) {
+		case *unaryExpr:
+			op = e.op
+		case *binaryExpr:
+			op = e.op
+		}
+		note := "operators only work on integers, use the fields of the struct"
+		if t.kind == kindArray {
+			note = "operators only work on integers, use the elements of the array"
+		}
+		c.errorAt(e, codeInvalidOperand, fmt.Sprintf("operator %v is not defined on %v", operatorSymbols[op], t.describe()), note)
+	}
+	c.invalid[e] = true
+}
+
+// conditional checks the condition of e is an integer and returns the type both of its branches
+// are widened to, nil when both are constant, a struct or array branch needs the same type on the other one
+func (c *checker) conditional(e *condExpr) *lwlType {
+	switch t := c.infer(e.cond); {
+	case t == nil:
+		c.settle(e.cond, typeI64)
+	case !t.isInt():
+		if !c.invalid[e.cond] {
+			c.errorAt(e.cond, codeInvalidOperand, fmt.Sprintf("cannot use %v as a condition", t.describe()), "conditions are integers, anything but 0 is true")
+		}
+		c.invalid[e] = true
+	}
+	then, els := c.infer(e.then), c.infer(e.els)
+	c.invalid[e] = c.invalid[e] || c.invalid[e.then] || c.invalid[e.els]
+	switch {
+	case then == nil && els == nil:
+		return nil
+	case then == nil:
+		c.convert(e.then, nil, els, "the other branch of the condition")
+		return els
+	case els == nil:
+		c.convert(e.els, nil, then, "the other branch of the condition")
+		return then
+	}
+	t, ok := commonType(then, els)
+	if !ok {
+		if !c.invalid[e] {
+			note := fmt.Sprintf("neither holds every value of the other, cast one of them: %v(...) or %v(...)", then, els)
+			if !then.isInt() || !els.isInt() {
+				note = "both branches must be of the same type"
+			}
+			c.errorAt(e, codeMismatchedTypes, fmt.Sprintf("mismatched types %v and %v of the branches", then.describe(), els.describe()), note)
+		}
+		c.invalid[e] = true
+		return then
+	}
+	return t
+}
+
+// let checks the value of e against the annotation of its name and returns the type of the body, checked
+// with the name holding the type of the value, i64 for a constant one just like a parameter without annotation
+func (c *checker) let(e *letExpr) *lwlType {
+	bound, value := e.name.typ, c.infer(e.value)
+	switch {
+	case bound != nil:
+		c.convert(e.value, value, bound, "let "+e.name.name)
+	case value == nil:
+		c.settle(e.value, typeI64)
+		bound = typeI64
+	default:
+		bound = value
+	}
+	outer, shadows := c.variables[e.name.name]
+	c.variables[e.name.name] = bound
+	t := c.infer(e.body)
+	if shadows {
+		c.variables[e.name.name] = outer
+	} else {
+		delete(c.variables, e.name.name)
+	}
+	c.invalid[e] = c.invalid[e.body]
+	return t
+}
+
+// field resolves the field read by e and returns its type
+func (c *checker) field(e *fieldExpr) *lwlType {
+	t := c.infer(e.operand)
+	if t == nil {
+		c.settle(e.operand, typeI64)
+		t = typeI64
+	}
+	if c.invalid[e.operand] {
+		c.invalid[e] = true
+		return typeI64
+	}
+	if t.kind != kindStruct {
+		c.errorAt(e, codeInvalidOperand, fmt.Sprintf("%v has no field %v", t, e.name), "only structs have fields")
+		c.invalid[e] = true
+		return typeI64
+	}
+	f, ok := t.field(e.name)
+	if !ok {
+		c.errorAt(e, codeUnknownField, fmt.Sprintf("struct %v has no field %v", t, e.name), fieldNames(t))
+		c.invalid[e] = true
+		return typeI64
+	}
+	e.field = f
+	return f.typ
+}
+
+// structLit resolves the fields of a struct literal, every one of them must be given exactly once
+func (c *checker) structLit(e *structLit) {
+	t := e.exprType()
+	given := make(map[string]bool, len(e.fields))
+	for _, init := range e.fields {
+		f, ok := t.field(init.name)
+		switch {
+		case !ok:
+			c.diags = append(c.diags, newDiagnostic(severityError, codeUnknownField, init.position, len(init.name),
+				fmt.Sprintf("struct %v has no field %v", t, init.name), fieldNames(t)))
+			c.infer(init.value)
+			continue
+		case given[init.name]:
+			c.diags = append(c.diags, newDiagnostic(severityError, codeDuplicateField, init.position, len(init.name),
+				fmt.Sprintf("field %v of %v already given", init.name, t)))
+		}
+		given[init.name] = true
+		init.field = f
+		c.convert(init.value, c.infer(init.value), f.typ, fmt.Sprintf("field %v of %v", f.name, t))
+	}
+	for _, f := range t.fields {
+		if !given[f.name] {
+			c.errorAt(e, codeMissingField, fmt.Sprintf("missing field %v in %v literal", f.name, t), "every field of a struct must be given")
+		}
+	}
+}
+
+// arrayLit checks an array literal gives exactly as many elements as its type holds
+func (c *checker) arrayLit(e *arrayLit) {
+	t := e.exprType()
+	for i, elem := range e.elems {
+		c.convert(elem, c.infer(elem), t.elem, fmt.Sprintf("element %v of %v", i, t))
+	}
+	if len(e.elems) != t.length {
+		c.errorAt(e, codeArrayLength, fmt.Sprintf("%v literal with %v elements", t, len(e.elems)), "every element of an array must be given")
+	}
+}
+
+// index checks e reads an element of an array with an integer index and returns the type of its elements,
+// a constant index must be within the array
+func (c *checker) index(e *indexExpr) *lwlType {
+	t := c.infer(e.operand)
+	if t == nil {
+		c.settle(e.operand, typeI64)
+		t = typeI64
+	}
+	switch i := c.infer(e.index); {
+	case i == nil:
+		c.settle(e.index, typeI64)
+	case !i.isInt():
+		if !c.invalid[e.index] {
+			c.errorAt(e.index, codeInvalidOperand, fmt.Sprintf("cannot index with %v", i.describe()), "indices are integers")
+		}
+		c.invalid[e] = true
+	}
+	if c.invalid[e.operand] || c.invalid[e.index] {
+		c.invalid[e] = true
+	}
+	switch {
+	case c.invalid[e.operand]:
+		return typeI64
+	case t.kind != kindArray:
+		c.errorAt(e, codeInvalidOperand, fmt.Sprintf("%v can not be indexed", t.describe()), "only arrays have elements")
+		c.invalid[e] = true
+		return typeI64
+	}
+	if i, ok := constantIndex(e.index); ok && (i < 0 || i >= int64(t.length)) {
+		c.errorAt(e.index, codeIndexOutOfRange, fmt.Sprintf("index %v out of range of %v", i, t),
+			fmt.Sprintf("the indices of %v go from 0 to %v", t, t.length-1))
+		c.invalid[e] = true
+	}
+	return t.elem
+}
+
+// length resolves the length of the array e measures
+func (c *checker) length(e *lenExpr) {
+	t := c.infer(e.operand)
+	if t == nil {
+		c.settle(e.operand, typeI64)
+		t = typeI64
+	}
+	if t.kind != kindArray {
+		if !c.invalid[e.operand] {
+			c.errorAt(e, codeInvalidOperand, fmt.Sprintf("len is not defined on %v", t.describe()), "only arrays have a length")
+		}
+		c.invalid[e] = true
+		return
+	}
+	e.length = t.length
+}
+
+// fieldNames lists the fields of a struct for the diagnostics
+func fieldNames(t *lwlType) string {
+	names := make([]string, 0, len(t.fields))
+	for _, f := range t.fields {
+		names = append(names, f.name)
+	}
+	return fmt.Sprintf("the fields of %v are %v", t, strings.Join(names, ", "))
+}
+
+// convert checks e, of type t (nil for constants), can be used as a value of type to
+func (c *checker) convert(e expr, t, to *lwlType, what string) {
+	switch {
+	case t == nil && !to.isInt():
+		c.errorAt(e, codeMismatchedTypes, fmt.Sprintf("cannot use constant %v as %v in %v", e, to, what))
+		c.settle(e, typeI64)
+	case t == nil:
+		c.settle(e, to)
+	case !t.isInt() || !to.isInt():
+		if t != to && !c.invalid[e] {
+			c.errorAt(e, codeMismatchedTypes, fmt.Sprintf("cannot use %v as %v in %v", t, to, what))
+		}
+	case !t.widensTo(to) && !c.invalid[e]:
+		c.errorAt(e, codeMismatchedTypes, fmt.Sprintf("cannot use %v as %v in %v", t, to, what),
+			fmt.Sprintf("%v does not hold every value of %v, narrowing it needs a cast: %v(...)", to, t, to))
+	}
+}
+
+// settle gives the constant expression e the type t, every constant in it must fit
+func (c *checker) settle(e expr, t *lwlType) {
+	switch e := e.(type) {
+	case *literal:
+		if !t.fits(e.value) {
+			c.errorAt(e, codeInvalidConstant, fmt.Sprintf("constant %v overflows %v", e.value, t), t.bounds())
+		}
+	case *lenExpr:
+		if !t.fits(int64(e.length)) {
+			c.errorAt(e, codeInvalidConstant, fmt.Sprintf("length %v of %v overflows %v", e.length, e.operand, t), t.bounds())
+		}
+	case *unaryExpr:
+		c.settle(e.operand, t)
+	case *binaryExpr:
+		if !isComparison(e.op) {
+			c.settle(e.lhs, t)
+			c.settle(e.rhs, t)
+		}
+	case *condExpr:
+		c.settle(e.then, t)
+		c.settle(e.els, t)
+	case *letExpr:
+		c.settle(e.body, t)
+	}
+	e.setType(t)
+}
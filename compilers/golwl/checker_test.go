@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// checkTypes type checks the declarations, failing the test on any error
+func checkTypes(t *testing.T, decls []*funcDecl) {
+	t.Helper()
+	if diags, err := check(decls); err != nil {
+		t.Fatalf("check() error = %v: %v", err, diags)
+	}
+}
+
+func Test_check(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		want     []string // the type of the body of every declaration
+		wantErr  error
+		wantDiag string
+	}{
+		{
+			name:   "unannotated programs are all i64",
+			source: "f(x,y)=x+y*2\nmain = f(1,2)\n",
+			want:   []string{"i64", "i64"},
+		},
+		{
+			name:   "annotated parameters and result",
+			source: "f(x:u8, y:i32):i32 = x + y\nmain = f(1,2)\n",
+			want:   []string{"i32", "i32"},
+		},
+		{
+			name:   "constants take the type of their context",
+			source: "f(x:u8):u8=x+200\nmain = f(255)\n",
+			want:   []string{"u8", "u8"},
+		},
+		{
+			name:   "unsigned widens to a wider signed",
+			source: "f(x:u8,y:i16)=x*y\nmain = f(1,2)\n",
+			want:   []string{"i16", "i64"},
+		},
+		{
+			name:   "narrow results widen into main",
+			source: "f(x:i8):i8=-x\nmain = f(-128)\n",
+			want:   []string{"i8", "i8"},
+		},
+		{
+			name:   "casts narrow explicitly",
+			source: "f(x)=u8(x)\nmain = f(300)\n",
+			want:   []string{"u8", "i64"},
+		},
+		{
+			name:     "mixed signedness",
+			source:   "f(x:u8,y:i8)=x+y\nmain = f(1,2)\n",
+			wantErr:  errTypes,
+			wantDiag: "mismatched types u8 and i8",
+		},
+		{
+			name:     "u64 and i64 do not mix",
+			source:   "f(x:u64,y)=x+y\nmain = f(1,2)\n",
+			wantErr:  errTypes,
+			wantDiag: "mismatched types u64 and i64",
+		},
+		{
+			name:     "narrowing an argument needs a cast",
+			source:   "f(x:u8)=x\ng(y:i32)=f(y)\nmain = g(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot use i32 as u8 in argument x of f",
+		},
+		{
+			name:     "narrowing the result needs a cast",
+			source:   "f(x):u8=x\nmain = f(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot use i64 as u8 in the result of f",
+		},
+		{
+			name:     "constant overflowing its type",
+			source:   "f(x:u8)=x\nmain = f(256)\n",
+			wantErr:  errTypes,
+			wantDiag: "constant 256 overflows u8\n",
+		},
+		{
+			name:     "negative constant in an unsigned type",
+			source:   "f(x:u32)=x-1\nmain = f(-1)\n",
+			wantErr:  errTypes,
+			wantDiag: "u32 holds values from 0 to 4294967295",
+		},
+		{
+			name:     "constant overflowing a signed type",
+			source:   "f(x:i8)=x*-129\nmain = f(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "i8 holds values from -128 to 127",
+		},
+		{
+			name:   "fields have the type they are declared with",
+			source: "struct p { x:u8, y }\nf(a:p):u8 = a.x\nmain = f(p{x: 255, y: -1}) + p{x: 1, y: 2}.y\n",
+			want:   []string{"u8", "i64"},
+		},
+		{
+			name:   "structs are passed as a whole",
+			source: "struct p { x:u8 }\nf(a:p):p = a\nmain = f(f(p{x: 1})).x\n",
+			want:   []string{"p", "u8"},
+		},
+		{
+			name:     "constant overflowing a field",
+			source:   "struct p { x:u8 }\nmain = p{x: 256}.x\n",
+			wantErr:  errTypes,
+			wantDiag: "constant 256 overflows u8",
+		},
+		{
+			name:     "narrowing into a field needs a cast",
+			source:   "struct p { x:u8 }\nf(y)=p{x: y}.x\nmain = f(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot use i64 as u8 in field x of p",
+		},
+		{
+			name:     "unknown field",
+			source:   "struct p { x, y }\nmain = p{x: 1, y: 2}.z\n",
+			wantErr:  errTypes,
+			wantDiag: "struct p has no field z",
+		},
+		{
+			name:     "unknown field in a literal",
+			source:   "struct p { x }\nmain = p{x: 1, y: 2}.x\n",
+			wantErr:  errTypes,
+			wantDiag: "the fields of p are x",
+		},
+		{
+			name:     "missing field in a literal",
+			source:   "struct p { x, y }\nmain = p{y: 2}.x\n",
+			wantErr:  errTypes,
+			wantDiag: "missing field x in p literal",
+		},
+		{
+			name:     "field given twice",
+			source:   "struct p { x }\nmain = p{x: 1, x: 2}.x\n",
+			wantErr:  errTypes,
+			wantDiag: "field x of p already given",
+		},
+		{
+			name:     "integers have no fields",
+			source:   "f(x)=x.y\nmain = f(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "i64 has no field y",
+		},
+		{
+			name:     "operators do not work on structs",
+			source:   "struct p { x }\nf(a:p)=a+1\nmain = f(p{x: 1})\n",
+			wantErr:  errTypes,
+			wantDiag: "operator + is not defined on struct p",
+		},
+		{
+			name:     "structs can not be cast",
+			source:   "struct p { x }\nf(a:p)=i64(a)\nmain = f(p{x: 1})\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot cast struct p to i64",
+		},
+		{
+			name:     "constants are not structs",
+			source:   "struct p { x }\nf(a:p)=a.x\nmain = f(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot use constant 1 as p in argument a of f",
+		},
+		{
+			name:     "different structs do not mix",
+			source:   "struct p { x }\nstruct q { x }\nf(a:p)=a.x\nmain = f(q{x: 1})\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot use q as p in argument a of f",
+		},
+		{
+			name:   "elements and lengths",
+			source: "f(a:[3]u8, i:u8):[2]u16 = [2]u16{a[i], a[2] * len(a)}\nmain = f([3]u8{1, 2, 3}, 0)[1]\n",
+			want:   []string{"[2]u16", "u16"},
+		},
+		{
+			name:     "constant index out of range",
+			source:   "f(a:[3]u8)=a[3]\nmain = f([3]u8{1, 2, 3})\n",
+			wantErr:  errTypes,
+			wantDiag: "index 3 out of range of [3]u8",
+		},
+		{
+			name:     "negative constant index",
+			source:   "f(a:[3]u8)=a[-1]\nmain = f([3]u8{1, 2, 3})\n",
+			wantErr:  errTypes,
+			wantDiag: "the indices of [3]u8 go from 0 to 2",
+		},
+		{
+			name:     "length as an index",
+			source:   "f(a:[3]u8)=a[len(a)]\nmain = f([3]u8{1, 2, 3})\n",
+			wantErr:  errTypes,
+			wantDiag: "index 3 out of range of [3]u8",
+		},
+		{
+			name:     "literal with too few elements",
+			source:   "main = [3]u8{1, 2}[0]\n",
+			wantErr:  errTypes,
+			wantDiag: "[3]u8 literal with 2 elements",
+		},
+		{
+			name:     "elements must fit",
+			source:   "main = [2]u8{1, 256}[0]\n",
+			wantErr:  errTypes,
+			wantDiag: "constant 256 overflows u8",
+		},
+		{
+			name:     "integers can not be indexed",
+			source:   "f(x)=x[0]\nmain = f(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "i64 can not be indexed",
+		},
+		{
+			name:     "structs are not indices",
+			source:   "struct p { x }\nf(a:[2]u8, b:p)=a[b]\nmain = f([2]u8{1, 2}, p{x: 1})\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot index with struct p",
+		},
+		{
+			name:     "length of an integer",
+			source:   "f(x)=len(x)\nmain = f(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "len is not defined on i64",
+		},
+		{
+			name:     "length overflows",
+			source:   "f(a:[300]u8):u8=len(a)\nmain = 1\n",
+			wantErr:  errTypes,
+			wantDiag: "length 300 of a overflows u8",
+		},
+		{
+			name:     "operators do not work on arrays",
+			source:   "f(a:[2]u8)=-a\nmain = f([2]u8{1, 2})\n",
+			wantErr:  errTypes,
+			wantDiag: "operator - is not defined on [2]u8",
+		},
+		{
+			name:     "arrays of different lengths do not mix",
+			source:   "f(a:[2]u8)=a[0]\nmain = f([3]u8{1, 2, 3})\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot use [3]u8 as [2]u8 in argument a of f",
+		},
+		{
+			name:   "comparisons take the type of their context",
+			source: "f(x:u8, y:i16):u8 = x < y\ng(x:u64):i8 = x >= 1\nmain = f(1, 2) + u8(g(3))\n",
+			want:   []string{"u8", "i8", "u8"},
+		},
+		{
+			name:     "compared constants must fit the other side",
+			source:   "f(x:u8) = x == 256\nmain = f(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "constant 256 overflows u8",
+		},
+		{
+			name:     "compared types must mix",
+			source:   "f(x:u64, y) = x != y\nmain = f(1, 2)\n",
+			wantErr:  errTypes,
+			wantDiag: "mismatched types u64 and i64",
+		},
+		{
+			name:     "structs can not be compared",
+			source:   "struct p { x }\nf(a:p, b:p)=a == b\nmain = f(p{x: 1}, p{x: 1})\n",
+			wantErr:  errTypes,
+			wantDiag: "operator == is not defined on struct p",
+		},
+		{
+			name:   "branches widen to a common type",
+			source: "f(c, x:u8, y:i16) = c ? x : y\ng(c:u8):u16 = c ? c : 255\nmain = f(1, 2, 3) + g(1)\n",
+			want:   []string{"i16", "u8", "i64"},
+		},
+		{
+			name:   "structs and arrays as branches",
+			source: "struct p { x:u8 }\nf(c, a:p, b:[2]i8):p = c ? a : p{x: u8(b[0])}\nmain = f(0, p{x: 1}, [2]i8{2, 3}).x\n",
+			want:   []string{"p", "u8"},
+		},
+		{
+			name:     "constant branches must fit",
+			source:   "f(c):u8 = c ? 1 : 256\nmain = f(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "constant 256 overflows u8",
+		},
+		{
+			name:     "branches must mix",
+			source:   "f(c, x:u8, y:i8) = c ? x : y\nmain = f(1, 2, 3)\n",
+			wantErr:  errTypes,
+			wantDiag: "mismatched types u8 and i8 of the branches",
+		},
+		{
+			name:     "struct and integer branches",
+			source:   "struct p { x }\nf(c, a:p) = c ? a : 1\nmain = f(1, p{x: 1})\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot use constant 1 as p in the other branch of the condition",
+		},
+		{
+			name:     "different structs as branches",
+			source:   "struct p { x }\nstruct q { x }\nf(c, a:p, b:q) = (c ? a : b).x\nmain = f(1, p{x: 1}, q{x: 2})\n",
+			wantErr:  errTypes,
+			wantDiag: "mismatched types struct p and struct q of the branches",
+		},
+		{
+			name:     "conditions are integers",
+			source:   "struct p { x }\nf(a:p) = a ? 1 : 2\nmain = f(p{x: 1})\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot use struct p as a condition",
+		},
+		{
+			name:   "let takes the type of its value",
+			source: "f(x:u8) = let y = x * 2 in y\ng(x:u8):u16 = let y:u16 = x in let z:u8 = 1 in y + z\nmain = f(1) + g(2)\n",
+			want:   []string{"u8", "u16", "i64"},
+		},
+		{
+			name:   "let takes the type of its body",
+			source: "struct p { x:u8 }\nf(a:p):p = let b = a in b\nmain = f(p{x: 1}).x + let y:i8 = 1 in 2\n",
+			want:   []string{"p", "u8"},
+		},
+		{
+			name:     "let of a constant is an i64 without annotation",
+			source:   "f(x:u8):u8 = let k = 2 in x * k\nmain = f(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot use i64 as u8 in the result of f",
+		},
+		{
+			name:     "let values must fit their annotation",
+			source:   "f(x:u16) = let y:u8 = x in y\nmain = f(1)\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot use u16 as u8 in let y",
+		},
+		{
+			name:     "shadowing a let changes its type",
+			source:   "struct p { x }\nf(a:p) = let a = a.x in a.x\nmain = f(p{x: 1})\n",
+			wantErr:  errTypes,
+			wantDiag: "i64 has no field x",
+		},
+		{
+			name:     "main results in an integer",
+			source:   "struct p { x }\nmain = p{x: 1}\n",
+			wantErr:  errTypes,
+			wantDiag: "cannot use p as i64 in the result of main",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			decls, err := parse(tokenizeSource(t, tc.source))
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			diags, err := check(decls)
+			b := bytes.Buffer{}
+			printDiagnostics(&b, diags)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("check() error = %v, wantErr %v\n%v", err, tc.wantErr, b.String())
+			}
+			if !strings.Contains(b.String(), tc.wantDiag) {
+				t.Errorf("diagnostics = %v, want to contain %v", b.String(), tc.wantDiag)
+			}
+			if err != nil {
+				return
+			}
+			got := make([]string, 0, len(decls))
+			for _, d := range decls {
+				got = append(got, d.body.exprType().String())
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("check() types = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+long sq(long x)
+{
+    return x * x;
+}
+
+long f(long a, long b, long c, long d, long e, long g, long h, long i)
+{
+    return (a - b) * c / d % e + g * h - sq(i);
+}
+
+int main()
+{
+    return f(100, 2, 3, 4, 5, 6, 7, 8) % 200 + sq(3);
+}
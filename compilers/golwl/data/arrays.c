@@ -0,0 +1,29 @@
+struct lwl_array_i32_6
+{
+    int items[6];
+};
+
+struct lwl_array_i32_3
+{
+    int items[3];
+};
+
+struct lwl_array_i32_3 window(struct lwl_array_i32_6 a, unsigned char at)
+{
+    return (struct lwl_array_i32_3){{a.items[at], a.items[at + 1], a.items[at + 2]}};
+}
+
+int sum(struct lwl_array_i32_3 w)
+{
+    return w.items[0] + w.items[1] + w.items[2];
+}
+
+int last(struct lwl_array_i32_3 w)
+{
+    return w.items[3 - 1];
+}
+
+int main()
+{
+    return sum(window((struct lwl_array_i32_6){{4, 8, 15, 16, 23, 42}}, 2)) + last(window((struct lwl_array_i32_6){{1, 2, 3, 4, 5, 6}}, 3));
+}
@@ -0,0 +1,24 @@
+int max(int a, int b)
+{
+    return a > b ? a : b;
+}
+
+unsigned int fact(unsigned char n)
+{
+    return n <= 1 ? 1 : (unsigned int)n * fact(n - 1);
+}
+
+signed char sign(short x)
+{
+    return x < 0 ? -1 : x == 0 ? 0 : 1;
+}
+
+unsigned char between(unsigned short x, unsigned short lo, unsigned short hi)
+{
+    return (lo <= x) * (x < hi);
+}
+
+int main()
+{
+    return max(3, (int)fact(4)) + sign(-7) + sign(0) + between(5, 1, 10) * 100 + (2 != 2) + ((unsigned char)200 >= 100);
+}
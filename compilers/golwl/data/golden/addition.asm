@@ -0,0 +1,12 @@
+.section .text
+.global _start
+_start:
+    MOV $1, %RAX
+    MOV $3, %RBX
+    ADD %RBX, %RAX
+    MOV $1, %RBX
+    ADD %RBX, %RAX
+    MOV %RAX, %RDI
+    MOV $60, %RAX
+    SYSCALL
+.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,118 @@
+.section .text
+lwl_sq:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    MOV %RDI, %RAX
+    MOV %RDI, %RBX
+    IMUL %RBX, %RAX
+    POP %RBX
+    POP %RBP
+    RET
+lwl_f:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    PUSH %RDI
+    PUSH %RSI
+    PUSH %RDX
+    PUSH %RCX
+    PUSH %R8
+    PUSH %R9
+    MOV 24(%RBP), %RAX
+    PUSH %RAX
+    POP %RDI
+    CALL lwl_sq
+    POP %R9
+    POP %R8
+    POP %RCX
+    POP %RDX
+    POP %RSI
+    POP %RDI
+    PUSH %RAX
+    MOV %R9, %RAX
+    MOV 16(%RBP), %RBX
+    IMUL %RBX, %RAX
+    PUSH %RAX
+    MOV %RDI, %RAX
+    MOV %RSI, %RBX
+    SUB %RBX, %RAX
+    MOV %RDX, %RBX
+    IMUL %RBX, %RAX
+    MOV %RCX, %RBX
+    PUSH %RDX
+    CMP $-1, %RBX
+    JNE 1f
+    NEG %RAX
+    JMP 2f
+1:
+    CQO
+    IDIV %RBX
+2:
+    POP %RDX
+    MOV %R8, %RBX
+    PUSH %RDX
+    CMP $-1, %RBX
+    JNE 1f
+    MOV $0, %RAX
+    JMP 2f
+1:
+    CQO
+    IDIV %RBX
+    MOV %RDX, %RAX
+2:
+    POP %RDX
+    POP %RBX
+    ADD %RBX, %RAX
+    POP %RBX
+    SUB %RBX, %RAX
+    POP %RBX
+    POP %RBP
+    RET
+.global _start
+_start:
+    MOV $3, %RAX
+    PUSH %RAX
+    POP %RDI
+    CALL lwl_sq
+    PUSH %RAX
+    MOV $8, %RAX
+    PUSH %RAX
+    MOV $7, %RAX
+    PUSH %RAX
+    MOV $6, %RAX
+    PUSH %RAX
+    MOV $5, %RAX
+    PUSH %RAX
+    MOV $4, %RAX
+    PUSH %RAX
+    MOV $3, %RAX
+    PUSH %RAX
+    MOV $2, %RAX
+    PUSH %RAX
+    MOV $100, %RAX
+    PUSH %RAX
+    POP %RDI
+    POP %RSI
+    POP %RDX
+    POP %RCX
+    POP %R8
+    POP %R9
+    CALL lwl_f
+    ADD $16, %RSP
+    MOV $200, %RBX
+    CMP $-1, %RBX
+    JNE 1f
+    MOV $0, %RAX
+    JMP 2f
+1:
+    CQO
+    IDIV %RBX
+    MOV %RDX, %RAX
+2:
+    POP %RBX
+    ADD %RBX, %RAX
+    MOV %RAX, %RDI
+    MOV $60, %RAX
+    SYSCALL
+.section .note.GNU-stack,"",@progbits
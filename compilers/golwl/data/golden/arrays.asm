@@ -0,0 +1,192 @@
+.section .text
+lwl_window:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    SUB $16, %RSP
+    LEA 16(%RBP), %RAX
+    MOV %RDI, %RBX
+    CMP $6, %RBX
+    JB 1f
+    MOV $134, %RDI
+    MOV $60, %RAX
+    SYSCALL
+1:
+    IMUL $4, %RBX
+    ADD %RBX, %RAX
+    MOVSLQ 0(%RAX), %RAX
+    MOV %EAX, -24(%RBP)
+    MOV %RDI, %RAX
+    MOV $1, %RBX
+    ADD %RBX, %RAX
+    MOVZBQ %AL, %RAX
+    PUSH %RAX
+    LEA 16(%RBP), %RAX
+    POP %RBX
+    CMP $6, %RBX
+    JB 1f
+    MOV $134, %RDI
+    MOV $60, %RAX
+    SYSCALL
+1:
+    IMUL $4, %RBX
+    ADD %RBX, %RAX
+    MOVSLQ 0(%RAX), %RAX
+    MOV %EAX, -20(%RBP)
+    MOV %RDI, %RAX
+    MOV $2, %RBX
+    ADD %RBX, %RAX
+    MOVZBQ %AL, %RAX
+    PUSH %RAX
+    LEA 16(%RBP), %RAX
+    POP %RBX
+    CMP $6, %RBX
+    JB 1f
+    MOV $134, %RDI
+    MOV $60, %RAX
+    SYSCALL
+1:
+    IMUL $4, %RBX
+    ADD %RBX, %RAX
+    MOVSLQ 0(%RAX), %RAX
+    MOV %EAX, -16(%RBP)
+    LEA -24(%RBP), %RAX
+    MOV 8(%RAX), %RDX
+    MOV 0(%RAX), %RAX
+    ADD $16, %RSP
+    POP %RBX
+    POP %RBP
+    RET
+lwl_sum:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    SUB $16, %RSP
+    MOV %RDI, -24(%RBP)
+    MOV %RSI, -16(%RBP)
+    LEA -24(%RBP), %RAX
+    MOVSLQ 8(%RAX), %RAX
+    PUSH %RAX
+    LEA -24(%RBP), %RAX
+    MOVSLQ 4(%RAX), %RAX
+    PUSH %RAX
+    LEA -24(%RBP), %RAX
+    MOVSLQ 0(%RAX), %RAX
+    POP %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    POP %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    ADD $16, %RSP
+    POP %RBX
+    POP %RBP
+    RET
+lwl_last:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    SUB $16, %RSP
+    MOV %RDI, -24(%RBP)
+    MOV %RSI, -16(%RBP)
+    MOV $3, %RAX
+    MOV $1, %RBX
+    SUB %RBX, %RAX
+    PUSH %RAX
+    LEA -24(%RBP), %RAX
+    POP %RBX
+    CMP $3, %RBX
+    JB 1f
+    MOV $134, %RDI
+    MOV $60, %RAX
+    SYSCALL
+1:
+    IMUL $4, %RBX
+    ADD %RBX, %RAX
+    MOVSLQ 0(%RAX), %RAX
+    ADD $16, %RSP
+    POP %RBX
+    POP %RBP
+    RET
+.global _start
+_start:
+    MOV %RSP, %RBP
+    SUB $88, %RSP
+    MOV $1, %RAX
+    MOV %EAX, -88(%RBP)
+    MOV $2, %RAX
+    MOV %EAX, -84(%RBP)
+    MOV $3, %RAX
+    MOV %EAX, -80(%RBP)
+    MOV $4, %RAX
+    MOV %EAX, -76(%RBP)
+    MOV $5, %RAX
+    MOV %EAX, -72(%RBP)
+    MOV $6, %RAX
+    MOV %EAX, -68(%RBP)
+    LEA -88(%RBP), %RAX
+    SUB $24, %RSP
+    MOV 0(%RAX), %RBX
+    MOV %RBX, 0(%RSP)
+    MOV 8(%RAX), %RBX
+    MOV %RBX, 8(%RSP)
+    MOV 16(%RAX), %RBX
+    MOV %RBX, 16(%RSP)
+    MOV $3, %RAX
+    PUSH %RAX
+    POP %RDI
+    CALL lwl_window
+    ADD $24, %RSP
+    MOV %RAX, -64(%RBP)
+    MOV %RDX, -56(%RBP)
+    LEA -64(%RBP), %RAX
+    MOV 8(%RAX), %RBX
+    PUSH %RBX
+    MOV 0(%RAX), %RBX
+    PUSH %RBX
+    POP %RDI
+    POP %RSI
+    CALL lwl_last
+    PUSH %RAX
+    MOV $4, %RAX
+    MOV %EAX, -48(%RBP)
+    MOV $8, %RAX
+    MOV %EAX, -44(%RBP)
+    MOV $15, %RAX
+    MOV %EAX, -40(%RBP)
+    MOV $16, %RAX
+    MOV %EAX, -36(%RBP)
+    MOV $23, %RAX
+    MOV %EAX, -32(%RBP)
+    MOV $42, %RAX
+    MOV %EAX, -28(%RBP)
+    LEA -48(%RBP), %RAX
+    SUB $24, %RSP
+    MOV 0(%RAX), %RBX
+    MOV %RBX, 0(%RSP)
+    MOV 8(%RAX), %RBX
+    MOV %RBX, 8(%RSP)
+    MOV 16(%RAX), %RBX
+    MOV %RBX, 16(%RSP)
+    MOV $2, %RAX
+    PUSH %RAX
+    POP %RDI
+    CALL lwl_window
+    ADD $24, %RSP
+    MOV %RAX, -24(%RBP)
+    MOV %RDX, -16(%RBP)
+    LEA -24(%RBP), %RAX
+    MOV 8(%RAX), %RBX
+    PUSH %RBX
+    MOV 0(%RAX), %RBX
+    PUSH %RBX
+    POP %RDI
+    POP %RSI
+    CALL lwl_sum
+    POP %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    MOV %RAX, %RDI
+    MOV $60, %RAX
+    SYSCALL
+.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,173 @@
+.section .text
+lwl_max:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    MOV %RDI, %RAX
+    MOV %RSI, %RBX
+    CMP %RBX, %RAX
+    SETG %AL
+    MOVZBQ %AL, %RAX
+    CMP $0, %RAX
+    {disp32} JE .L1
+    MOV %RDI, %RAX
+    {disp32} JMP .L2
+.L1:
+    MOV %RSI, %RAX
+.L2:
+    POP %RBX
+    POP %RBP
+    RET
+lwl_fact:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    MOV %RDI, %RAX
+    MOV $1, %RBX
+    CMP %RBX, %RAX
+    SETBE %AL
+    MOVZBQ %AL, %RAX
+    CMP $0, %RAX
+    {disp32} JE .L3
+    MOV $1, %RAX
+    {disp32} JMP .L4
+.L3:
+    PUSH %RDI
+    MOV %RDI, %RAX
+    MOV $1, %RBX
+    SUB %RBX, %RAX
+    MOVZBQ %AL, %RAX
+    PUSH %RAX
+    POP %RDI
+    CALL lwl_fact
+    POP %RDI
+    PUSH %RAX
+    MOV %RDI, %RAX
+    MOV %EAX, %EAX
+    POP %RBX
+    IMUL %RBX, %RAX
+    MOV %EAX, %EAX
+.L4:
+    POP %RBX
+    POP %RBP
+    RET
+lwl_sign:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    MOV %RDI, %RAX
+    MOV $0, %RBX
+    CMP %RBX, %RAX
+    SETL %AL
+    MOVZBQ %AL, %RAX
+    CMP $0, %RAX
+    {disp32} JE .L5
+    MOV $-1, %RAX
+    {disp32} JMP .L6
+.L5:
+    MOV %RDI, %RAX
+    MOV $0, %RBX
+    CMP %RBX, %RAX
+    SETE %AL
+    MOVZBQ %AL, %RAX
+    CMP $0, %RAX
+    {disp32} JE .L7
+    MOV $0, %RAX
+    {disp32} JMP .L8
+.L7:
+    MOV $1, %RAX
+.L8:
+.L6:
+    POP %RBX
+    POP %RBP
+    RET
+lwl_between:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    MOV %RDI, %RAX
+    MOV %RDX, %RBX
+    CMP %RBX, %RAX
+    SETB %AL
+    MOVZBQ %AL, %RAX
+    PUSH %RAX
+    MOV %RSI, %RAX
+    MOV %RDI, %RBX
+    CMP %RBX, %RAX
+    SETBE %AL
+    MOVZBQ %AL, %RAX
+    POP %RBX
+    IMUL %RBX, %RAX
+    MOVZBQ %AL, %RAX
+    POP %RBX
+    POP %RBP
+    RET
+.global _start
+_start:
+    MOV $200, %RAX
+    MOVZBQ %AL, %RAX
+    MOV $100, %RBX
+    CMP %RBX, %RAX
+    SETAE %AL
+    MOVZBQ %AL, %RAX
+    PUSH %RAX
+    MOV $2, %RAX
+    MOV $2, %RBX
+    CMP %RBX, %RAX
+    SETNE %AL
+    MOVZBQ %AL, %RAX
+    PUSH %RAX
+    MOV $10, %RAX
+    PUSH %RAX
+    MOV $1, %RAX
+    PUSH %RAX
+    MOV $5, %RAX
+    PUSH %RAX
+    POP %RDI
+    POP %RSI
+    POP %RDX
+    CALL lwl_between
+    MOV $100, %RBX
+    IMUL %RBX, %RAX
+    MOVZBQ %AL, %RAX
+    PUSH %RAX
+    MOV $0, %RAX
+    PUSH %RAX
+    POP %RDI
+    CALL lwl_sign
+    PUSH %RAX
+    MOV $-7, %RAX
+    PUSH %RAX
+    POP %RDI
+    CALL lwl_sign
+    PUSH %RAX
+    MOV $4, %RAX
+    PUSH %RAX
+    POP %RDI
+    CALL lwl_fact
+    MOVSLQ %EAX, %RAX
+    PUSH %RAX
+    MOV $3, %RAX
+    PUSH %RAX
+    POP %RDI
+    POP %RSI
+    CALL lwl_max
+    POP %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    POP %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    POP %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    POP %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    POP %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    MOV %RAX, %RDI
+    MOV $60, %RAX
+    SYSCALL
+.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,24 @@
+.section .text
+lwl_f:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    MOV %RDI, %RAX
+    MOV %RSI, %RBX
+    ADD %RBX, %RAX
+    POP %RBX
+    POP %RBP
+    RET
+.global _start
+_start:
+    MOV $2, %RAX
+    PUSH %RAX
+    MOV $1, %RAX
+    PUSH %RAX
+    POP %RDI
+    POP %RSI
+    CALL lwl_f
+    MOV %RAX, %RDI
+    MOV $60, %RAX
+    SYSCALL
+.section .note.GNU-stack,"",@progbits
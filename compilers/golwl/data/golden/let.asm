@@ -0,0 +1,117 @@
+.section .text
+lwl_norm2:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    SUB $24, %RSP
+    MOV %RDI, -16(%RBP)
+    LEA -16(%RBP), %RAX
+    MOVSLQ 0(%RAX), %RAX
+    MOV %RAX, -24(%RBP)
+    LEA -16(%RBP), %RAX
+    MOVSLQ 4(%RAX), %RAX
+    MOV %RAX, -32(%RBP)
+    MOV -32(%RBP), %RAX
+    MOV -32(%RBP), %RBX
+    IMUL %RBX, %RAX
+    PUSH %RAX
+    MOV -24(%RBP), %RAX
+    MOV -24(%RBP), %RBX
+    IMUL %RBX, %RAX
+    POP %RBX
+    ADD %RBX, %RAX
+    ADD $24, %RSP
+    POP %RBX
+    POP %RBP
+    RET
+lwl_poly:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    SUB $16, %RSP
+    MOV %RDI, %RAX
+    MOV %RDI, %RBX
+    IMUL %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    MOV %RAX, -16(%RBP)
+    MOV -16(%RBP), %RAX
+    MOV %RDI, %RBX
+    IMUL %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    MOV %RAX, -24(%RBP)
+    MOV $2, %RAX
+    MOV -16(%RBP), %RBX
+    IMUL %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    PUSH %RAX
+    MOV -24(%RBP), %RAX
+    POP %RBX
+    SUB %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    MOV %RDI, %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    ADD $16, %RSP
+    POP %RBX
+    POP %RBP
+    RET
+lwl_swap:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    SUB $32, %RSP
+    MOV %RDI, -16(%RBP)
+    LEA -16(%RBP), %RAX
+    MOVSLQ 4(%RAX), %RAX
+    MOV %RAX, -24(%RBP)
+    LEA -16(%RBP), %RAX
+    MOVSLQ 0(%RAX), %RAX
+    MOV %RAX, -32(%RBP)
+    MOV -24(%RBP), %RAX
+    MOV %EAX, -40(%RBP)
+    MOV -32(%RBP), %RAX
+    MOV %EAX, -36(%RBP)
+    LEA -40(%RBP), %RAX
+    MOV 0(%RAX), %RAX
+    ADD $32, %RSP
+    POP %RBX
+    POP %RBP
+    RET
+.global _start
+_start:
+    MOV %RSP, %RBP
+    SUB $32, %RSP
+    MOV $3, %RAX
+    MOV %EAX, -32(%RBP)
+    MOV $4, %RAX
+    MOV %EAX, -28(%RBP)
+    LEA -32(%RBP), %RAX
+    MOV 0(%RAX), %RBX
+    PUSH %RBX
+    POP %RDI
+    CALL lwl_swap
+    MOV %RAX, -24(%RBP)
+    LEA -24(%RBP), %RAX
+    MOV %RAX, -16(%RBP)
+    MOV -16(%RBP), %RAX
+    MOVSLQ 4(%RAX), %RAX
+    PUSH %RAX
+    MOV -16(%RBP), %RAX
+    MOVSLQ 0(%RAX), %RAX
+    PUSH %RAX
+    POP %RDI
+    CALL lwl_poly
+    PUSH %RAX
+    MOV -16(%RBP), %RAX
+    MOV 0(%RAX), %RBX
+    PUSH %RBX
+    POP %RDI
+    CALL lwl_norm2
+    POP %RBX
+    ADD %RBX, %RAX
+    POP %RBX
+    ADD %RBX, %RAX
+    MOV %RAX, %RDI
+    MOV $60, %RAX
+    SYSCALL
+.section .note.GNU-stack,"",@progbits
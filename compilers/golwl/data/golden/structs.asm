@@ -0,0 +1,159 @@
+.section .text
+lwl_corner:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    SUB $8, %RSP
+    LEA 16(%RBP), %RAX
+    MOVSLQ 8(%RAX), %RAX
+    MOV %EAX, -16(%RBP)
+    LEA 16(%RBP), %RAX
+    MOVSLQ 12(%RAX), %RAX
+    MOV %EAX, -12(%RBP)
+    LEA -16(%RBP), %RAX
+    MOV 0(%RAX), %RAX
+    ADD $8, %RSP
+    POP %RBX
+    POP %RBP
+    RET
+lwl_grow:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    SUB $32, %RSP
+    MOV %RDI, -16(%RBP)
+    LEA 16(%RBP), %RAX
+    MOVSLQ 0(%RAX), %RAX
+    MOV %RSI, %RBX
+    SUB %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    MOV %EAX, -40(%RBP)
+    LEA 16(%RBP), %RAX
+    MOVSLQ 4(%RAX), %RAX
+    MOV %RSI, %RBX
+    SUB %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    MOV %EAX, -36(%RBP)
+    LEA 16(%RBP), %RAX
+    MOVSLQ 8(%RAX), %RAX
+    MOV %RSI, %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    MOV %EAX, -32(%RBP)
+    LEA 16(%RBP), %RAX
+    MOVSLQ 12(%RAX), %RAX
+    MOV %RSI, %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    MOV %EAX, -28(%RBP)
+    LEA 16(%RBP), %RAX
+    MOVZBQ 16(%RAX), %RAX
+    MOV %AL, -24(%RBP)
+    LEA -40(%RBP), %RAX
+    MOV -16(%RBP), %RDI
+    MOV 0(%RAX), %RBX
+    MOV %RBX, 0(%RDI)
+    MOV 8(%RAX), %RBX
+    MOV %RBX, 8(%RDI)
+    MOV 16(%RAX), %EBX
+    MOV %EBX, 16(%RDI)
+    MOV %RDI, %RAX
+    ADD $32, %RSP
+    POP %RBX
+    POP %RBP
+    RET
+lwl_area:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    LEA 16(%RBP), %RAX
+    MOVSLQ 4(%RAX), %RAX
+    PUSH %RAX
+    LEA 16(%RBP), %RAX
+    MOVSLQ 12(%RAX), %RAX
+    POP %RBX
+    SUB %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    PUSH %RAX
+    LEA 16(%RBP), %RAX
+    MOVSLQ 0(%RAX), %RAX
+    PUSH %RAX
+    LEA 16(%RBP), %RAX
+    MOVSLQ 8(%RAX), %RAX
+    POP %RBX
+    SUB %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    POP %RBX
+    IMUL %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    POP %RBX
+    POP %RBP
+    RET
+.global _start
+_start:
+    MOV %RSP, %RBP
+    SUB $88, %RSP
+    MOV $0, %RAX
+    MOV %EAX, -88(%RBP)
+    MOV $0, %RAX
+    MOV %EAX, -84(%RBP)
+    MOV $3, %RAX
+    MOV %EAX, -80(%RBP)
+    MOV $5, %RAX
+    MOV %EAX, -76(%RBP)
+    MOV $1, %RAX
+    MOV %AL, -72(%RBP)
+    LEA -88(%RBP), %RAX
+    SUB $24, %RSP
+    MOV 0(%RAX), %RBX
+    MOV %RBX, 0(%RSP)
+    MOV 8(%RAX), %RBX
+    MOV %RBX, 8(%RSP)
+    MOV 16(%RAX), %RBX
+    MOV %RBX, 16(%RSP)
+    CALL lwl_corner
+    ADD $24, %RSP
+    MOV %RAX, -64(%RBP)
+    LEA -64(%RBP), %RAX
+    MOVSLQ 4(%RAX), %RAX
+    PUSH %RAX
+    MOV $1, %RAX
+    MOV %EAX, -56(%RBP)
+    MOV $2, %RAX
+    MOV %EAX, -52(%RBP)
+    MOV $4, %RAX
+    MOV %EAX, -48(%RBP)
+    MOV $7, %RAX
+    MOV %EAX, -44(%RBP)
+    MOV $9, %RAX
+    MOV %AL, -40(%RBP)
+    LEA -56(%RBP), %RAX
+    SUB $24, %RSP
+    MOV 0(%RAX), %RBX
+    MOV %RBX, 0(%RSP)
+    MOV 8(%RAX), %RBX
+    MOV %RBX, 8(%RSP)
+    MOV 16(%RAX), %RBX
+    MOV %RBX, 16(%RSP)
+    MOV $2, %RAX
+    PUSH %RAX
+    POP %RSI
+    LEA -32(%RBP), %RDI
+    CALL lwl_grow
+    ADD $24, %RSP
+    SUB $24, %RSP
+    MOV 0(%RAX), %RBX
+    MOV %RBX, 0(%RSP)
+    MOV 8(%RAX), %RBX
+    MOV %RBX, 8(%RSP)
+    MOV 16(%RAX), %RBX
+    MOV %RBX, 16(%RSP)
+    CALL lwl_area
+    ADD $24, %RSP
+    POP %RBX
+    ADD %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    MOV %RAX, %RDI
+    MOV $60, %RAX
+    SYSCALL
+.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,63 @@
+.section .text
+lwl_scale:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    MOV %RDI, %RAX
+    MOV %RSI, %RBX
+    IMUL %RBX, %RAX
+    MOVSLQ %EAX, %RAX
+    MOV $3, %RBX
+    CMP $-1, %RBX
+    JNE 1f
+    NEG %RAX
+    JMP 2f
+1:
+    CQO
+    IDIV %RBX
+2:
+    MOVSLQ %EAX, %RAX
+    POP %RBX
+    POP %RBP
+    RET
+lwl_wrap:
+    PUSH %RBP
+    MOV %RSP, %RBP
+    PUSH %RBX
+    MOV %RDI, %RAX
+    MOV $2, %RBX
+    IMUL %RBX, %RAX
+    MOVZBQ %AL, %RAX
+    MOV $1, %RBX
+    ADD %RBX, %RAX
+    MOVZBQ %AL, %RAX
+    POP %RBX
+    POP %RBP
+    RET
+.global _start
+_start:
+    MOV $-7, %RAX
+    PUSH %RAX
+    MOV $200, %RAX
+    PUSH %RAX
+    POP %RDI
+    CALL lwl_wrap
+    PUSH %RAX
+    POP %RDI
+    POP %RSI
+    CALL lwl_scale
+    MOV $256, %RBX
+    CMP $-1, %RBX
+    JNE 1f
+    MOV $0, %RAX
+    JMP 2f
+1:
+    CQO
+    IDIV %RBX
+    MOV %RDX, %RAX
+2:
+    MOVSLQ %EAX, %RAX
+    MOV %RAX, %RDI
+    MOV $60, %RAX
+    SYSCALL
+.section .note.GNU-stack,"",@progbits
@@ -0,0 +1,32 @@
+struct point
+{
+    int x;
+    int y;
+};
+
+long norm2(struct point p)
+{
+    long x = p.x;
+    long y = p.y;
+    return x * x + y * y;
+}
+
+int poly(int x)
+{
+    int x2 = x * x;
+    int x3 = x2 * x;
+    return x3 - 2 * x2 + x;
+}
+
+struct point swap(struct point p)
+{
+    int x = p.y;
+    int y = p.x;
+    return (struct point){.x = x, .y = y};
+}
+
+int main()
+{
+    struct point p = swap((struct point){.x = 3, .y = 4});
+    return norm2(p) + poly(p.x) + p.y;
+}
@@ -0,0 +1,34 @@
+struct point
+{
+    int x;
+    int y;
+};
+
+struct rect
+{
+    int left;
+    int top;
+    int right;
+    int bottom;
+    unsigned char color;
+};
+
+struct point corner(struct rect r)
+{
+    return (struct point){.x = r.right, .y = r.bottom};
+}
+
+struct rect grow(struct rect r, int by)
+{
+    return (struct rect){.left = r.left - by, .top = r.top - by, .right = r.right + by, .bottom = r.bottom + by, .color = r.color};
+}
+
+int area(struct rect r)
+{
+    return (r.right - r.left) * (r.bottom - r.top);
+}
+
+int main()
+{
+    return area(grow((struct rect){.left = 1, .top = 2, .right = 4, .bottom = 7, .color = 9}, 2)) + corner((struct rect){.left = 0, .top = 0, .right = 3, .bottom = 5, .color = 1}).y;
+}
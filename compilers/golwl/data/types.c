@@ -0,0 +1,14 @@
+int scale(unsigned char x, int y)
+{
+    return x * y / 3;
+}
+
+unsigned char wrap(unsigned char x)
+{
+    return x * 2 + 1;
+}
+
+int main()
+{
+    return scale(wrap(200), -7) % 256;
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Diagnostics are the errors and warnings found in the source code, with enough
+// information to point at the exact columns that caused them.
+
+type severity int
+
+const (
+	severityError severity = iota
+	severityWarning
+)
+
+func (s severity) String() string {
+	if s == severityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// diagnosticCode identifies the kind of a diagnostic, it never changes meaning once released
+type diagnosticCode string
+
+const (
+	codeInvalidToken      diagnosticCode = "L0001"
+	codeDuplicateFunction diagnosticCode = "L0002"
+	codeMultipleEq        diagnosticCode = "L0003"
+	codeMissingEq         diagnosticCode = "L0004"
+	// L0005 is retired, never reuse it
+	codeUnexpectedToken    diagnosticCode = "L0006"
+	codeUnexpectedEnd      diagnosticCode = "L0007"
+	codeUndefinedVariable  diagnosticCode = "L0008"
+	codeUndefinedFunction  diagnosticCode = "L0009"
+	codeFunctionAsVariable diagnosticCode = "L0010"
+	codeDuplicateParameter diagnosticCode = "L0011"
+	codeArgumentCount      diagnosticCode = "L0012"
+	codeDivisionByZero     diagnosticCode = "L0013"
+	codeInvalidConstant    diagnosticCode = "L0014"
+	codeUnknownType        diagnosticCode = "L0015"
+	codeMismatchedTypes    diagnosticCode = "L0016"
+	codeReservedName       diagnosticCode = "L0017"
+	codeDuplicateType      diagnosticCode = "L0018"
+	codeDuplicateField     diagnosticCode = "L0019"
+	codeUnknownField       diagnosticCode = "L0020"
+	codeMissingField       diagnosticCode = "L0021"
+	codeInvalidOperand     diagnosticCode = "L0022"
+	codeIndexOutOfRange    diagnosticCode = "L0023"
+	codeArrayLength        diagnosticCode = "L0024"
+	codeStringLiteral      diagnosticCode = "L0025"
+	codeInvalidImport      diagnosticCode = "L0026"
+	codeImportCycle        diagnosticCode = "L0027"
+	codePrivateFunction    diagnosticCode = "L0028"
+	codeMainInModule       diagnosticCode = "L0029"
+	codeShadowedVariable   diagnosticCode = "L0030"
+	codeMultipleMains      diagnosticCode = "L0031"
+	codeNoMain             diagnosticCode = "L0032"
+	codeInvalidEntry       diagnosticCode = "L0033"
+)
+
+// diagnosticRule describes a kind of diagnostic for the tools that consume them
+type diagnosticRule struct {
+	name        string
+	description string
+}
+
+var diagnosticRules = map[diagnosticCode]diagnosticRule{
+	codeInvalidToken:       {"invalid-token", "The source contains a character that is not part of the language."},
+	codeDuplicateFunction:  {"duplicate-function", "A function with the same name was already defined."},
+	codeMultipleEq:         {"multiple-eq", "A function declaration has more than one '='."},
+	codeMissingEq:          {"missing-eq", "A function or main declaration has no '=' separating its header from its body."},
+	codeUnexpectedToken:    {"unexpected-token", "A token appears where the grammar does not allow it."},
+	codeUnexpectedEnd:      {"unexpected-end", "The line ended before the expression was complete."},
+	codeUndefinedVariable:  {"undefined-variable", "A variable is used without being declared as a parameter."},
+	codeUndefinedFunction:  {"undefined-function", "A function is called without being defined."},
+	codeFunctionAsVariable: {"function-as-variable", "A function is used as a value without being called."},
+	codeDuplicateParameter: {"duplicate-parameter", "A function declares the same parameter twice."},
+	codeArgumentCount:      {"argument-count", "A function is called with the wrong number of arguments."},
+	codeDivisionByZero:     {"division-by-zero", "An expression divides by the constant zero."},
+	codeInvalidConstant:    {"invalid-constant", "An integer constant can not be represented."},
+	codeUnknownType:        {"unknown-type", "A type annotation names a type that does not exist."},
+	codeMismatchedTypes:    {"mismatched-types", "A value is used where its type can not be implicitly widened to the expected one."},
+	codeReservedName:       {"reserved-name", "A function is named after a name reserved by the language."},
+	codeDuplicateType:      {"duplicate-type", "A struct with the same name was already declared, or it is named after an integer type."},
+	codeDuplicateField:     {"duplicate-field", "A struct declares or initializes the same field twice."},
+	codeUnknownField:       {"unknown-field", "A field is read or initialized that the struct does not have."},
+	codeMissingField:       {"missing-field", "A struct declares no fields, or a struct literal leaves one of them out."},
+	codeInvalidOperand:     {"invalid-operand", "An operator, cast, field access or index is applied to a value it does not work on."},
+	codeIndexOutOfRange:    {"index-out-of-range", "A constant index is outside of the array it indexes."},
+	codeArrayLength:        {"array-length", "An array type has no elements, or an array literal does not have as many as its type."},
+	codeStringLiteral:      {"string-literal", "A string is used without a plugin lowering it, or its escapes are invalid."},
+	codeInvalidImport:      {"invalid-import", "An import is malformed, its file can not be read, or its module name is taken by another file."},
+	codeImportCycle:        {"import-cycle", "A module imports itself, directly or through other modules."},
+	codePrivateFunction:    {"private-function", "A function of another module is called without being exported."},
+	codeMainInModule:       {"main-in-module", "The entry point is declared in an imported module instead of the files given to the compiler."},
+	codeShadowedVariable:   {"shadowed-variable", "A let binds a name already given to a parameter, another let or an imported module, hiding it within its body."},
+	codeMultipleMains:      {"multiple-mains", "The entry point main is declared more than once."},
+	codeNoMain:             {"no-main", "A program declares no entry point, neither main nor one picked with -entry."},
+	codeInvalidEntry:       {"invalid-entry", "The function picked with -entry is not defined or takes parameters."},
+}
+
+// diagnostic is about the columns [col, endCol) of a line of a file, or about the whole file without a line
+type diagnostic struct {
+	file     string
+	line     int // 0 when it is about the whole file
+	col      int // first column, starting at 1
+	endCol   int // column right after the last one
+	severity severity
+	code     diagnosticCode
+	msg      string
+	notes    []string
+}
+
+func newDiagnostic(sev severity, code diagnosticCode, p position, width int, msg string, notes ...string) diagnostic {
+	return diagnostic{
+		file:     p.file,
+		line:     p.line,
+		col:      p.col,
+		endCol:   p.col + max(width, 1),
+		severity: sev,
+		code:     code,
+		msg:      msg,
+		notes:    notes,
+	}
+}
+
+func (d diagnostic) Error() string {
+	if d.line == 0 {
+		return fmt.Sprintf("%v: %v[%v]: %v", d.file, d.severity, d.code, d.msg)
+	}
+	return fmt.Sprintf("%v:%v:%v: %v[%v]: %v", d.file, d.line, d.col, d.severity, d.code, d.msg)
+}
+
+// errorAt records an error spanning the token t of the function
+func (f *function) errorAt(t token, code diagnosticCode, msg string, notes ...string) {
+	p := position{file: f.file, line: t.line, col: t.col}
+	f.errs = append(f.errs, newDiagnostic(severityError, code, p, len(t.v), msg, notes...))
+}
+
+// warnAt records a warning spanning the token t of the function, it does not stop the compilation
+func (f *function) warnAt(t token, code diagnosticCode, msg string, notes ...string) {
+	p := position{file: f.file, line: t.line, col: t.col}
+	f.errs = append(f.errs, newDiagnostic(severityWarning, code, p, len(t.v), msg, notes...))
+}
+
+// collectDiagnostics gathers the diagnostics of every function in source order
+func collectDiagnostics(functions []function) []diagnostic {
+	diags := make([]diagnostic, 0)
+	for _, f := range functions {
+		diags = append(diags, f.errs...)
+	}
+	return diags
+}
+
+func countErrors(diags []diagnostic) int {
+	n := 0
+	for _, d := range diags {
+		if d.severity == severityError {
+			n++
+		}
+	}
+	return n
+}
+
+// printDiagnostics writes the diagnostics in a human friendly way, quoting the source line
+// and underlining the offending columns:
+//
+//	test.lwl:1:6: error[L0008]: undefined variable y
+//	   1 | f(x)=y
+//	     |      ^
+//	     = note: something helpful
+func printDiagnostics(w io.Writer, diags []diagnostic) {
+	sources := make(map[string][]string)
+	for _, d := range diags {
+		fmt.Fprintln(w, d.Error())
+
+		lines, read := sources[d.file]
+		if !read {
+			// if the file can't be read anymore we just don't quote it
+			contents, _ := os.ReadFile(d.file)
+			lines = strings.Split(string(contents), "\n")
+			sources[d.file] = lines
+		}
+		gutter := fmt.Sprintf("%4d", d.line)
+		blank := strings.Repeat(" ", len(gutter))
+		if d.line >= 1 && d.line <= len(lines) && d.col >= 1 {
+			line := strings.TrimRight(lines[d.line-1], "\r")
+			fmt.Fprintf(w, "%s | %s\n", gutter, line)
+			fmt.Fprintf(w, "%s | %s\n", blank, underline(line, d.col, d.endCol))
+		}
+		for _, note := range d.notes {
+			fmt.Fprintf(w, "%s = note: %s\n", blank, note)
+		}
+	}
+}
+
+// underline places carets below the columns [col, endCol) of the line, keeping tabs so they align
+func underline(line string, col, endCol int) string {
+	b := strings.Builder{}
+	for i := 0; i < col-1; i++ {
+		if i < len(line) && line[i] == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteString(strings.Repeat("^", max(endCol-col, 1)))
+	return b.String()
+}
+
+const (
+	diagnosticsText  = "text"
+	diagnosticsJSON  = "json"
+	diagnosticsSARIF = "sarif"
+)
+
+var diagnosticsFormats = []string{diagnosticsText, diagnosticsJSON, diagnosticsSARIF}
+
+// reportDiagnostics writes the diagnostics in the given format
+func reportDiagnostics(w io.Writer, format string, diags []diagnostic) error {
+	switch format {
+	case diagnosticsText:
+		printDiagnostics(w, diags)
+		return nil
+	case diagnosticsJSON:
+		return writeDiagnosticsJSON(w, diags)
+	case diagnosticsSARIF:
+		return writeDiagnosticsSARIF(w, diags)
+	}
+	return fmt.Errorf("unknown diagnostics format %q, expected one of: %v", format, diagnosticsFormats)
+}
+
+type jsonDiagnostic struct {
+	File      string   `json:"file"`
+	Line      int      `json:"line,omitempty"` // left out with the columns when it is about the whole file
+	Column    int      `json:"column,omitempty"`
+	EndColumn int      `json:"endColumn,omitempty"`
+	Severity  string   `json:"severity"`
+	Code      string   `json:"code"`
+	Rule      string   `json:"rule"`
+	Message   string   `json:"message"`
+	Notes     []string `json:"notes,omitempty"`
+}
+
+// writeDiagnosticsJSON writes one JSON object per line for each diagnostic
+func writeDiagnosticsJSON(w io.Writer, diags []diagnostic) error {
+	enc := json.NewEncoder(w)
+	for _, d := range diags {
+		err := enc.Encode(jsonDiagnostic{
+			File:      d.file,
+			Line:      d.line,
+			Column:    d.col,
+			EndColumn: d.endCol,
+			Severity:  d.severity.String(),
+			Code:      string(d.code),
+			Rule:      diagnosticRules[d.code].name,
+			Message:   d.msg,
+			Notes:     d.notes,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the subset of SARIF 2.1.0 we fill in, see https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name    string      `json:"name"`
+		Version string      `json:"version,omitempty"`
+		Rules   []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID               string       `json:"id"`
+		Name             string       `json:"name"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		RuleIndex int             `json:"ruleIndex"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           *sarifRegion          `json:"region,omitempty"` // nil when it is about the whole file
+	}
+	sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+		EndColumn   int `json:"endColumn"`
+	}
+)
+
+// writeDiagnosticsSARIF writes the diagnostics as a single SARIF log with one run
+func writeDiagnosticsSARIF(w io.Writer, diags []diagnostic) error {
+	codes := make([]diagnosticCode, 0, len(diagnosticRules))
+	for code := range diagnosticRules {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+
+	rules := make([]sarifRule, 0, len(codes))
+	for _, code := range codes {
+		rules = append(rules, sarifRule{
+			ID:               string(code),
+			Name:             diagnosticRules[code].name,
+			ShortDescription: sarifMessage{Text: diagnosticRules[code].description},
+		})
+	}
+
+	results := make([]sarifResult, 0, len(diags))
+	for _, d := range diags {
+		text := d.msg
+		for _, note := range d.notes {
+			text += "\nnote: " + note
+		}
+		var region *sarifRegion
+		if d.line > 0 {
+			region = &sarifRegion{StartLine: d.line, StartColumn: d.col, EndColumn: d.endCol}
+		}
+		results = append(results, sarifResult{
+			RuleID:    string(d.code),
+			RuleIndex: slices.Index(codes, d.code),
+			Level:     d.severity.String(),
+			Message:   sarifMessage{Text: text},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: d.file},
+					Region:           region,
+				},
+			}},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: sarifDriver{Name: "golwl", Version: version, Rules: rules}},
+			Results: results,
+		}},
+	})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_diagnostics(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		wantDiags []diagnostic
+		wantText  string
+	}{
+		{
+			name:   "spans the offending token",
+			source: "f(xx)=xx+yy\nmain = f(1)\n",
+			wantDiags: []diagnostic{
+				{line: 1, col: 10, endCol: 12, code: codeUndefinedVariable, msg: "undefined variable yy"},
+			},
+			wantText: "FILE:1:10: error[L0008]: undefined variable yy\n" +
+				"   1 | f(xx)=xx+yy\n" +
+				"     |          ^^\n",
+		},
+		{
+			name:   "keeps tabs aligned and reports notes",
+			source: "f(x)=x\n\tmain = f(1,2)\n",
+			wantDiags: []diagnostic{
+				{line: 2, col: 9, endCol: 10, code: codeArgumentCount, msg: "function f expects 1 arguments, got 2"},
+			},
+			wantText: "FILE:2:9: error[L0012]: function f expects 1 arguments, got 2\n" +
+				"   2 | \tmain = f(1,2)\n" +
+				"     | \t       ^\n" +
+				"     = note: f is defined at FILE:1\n",
+		},
+		{
+			name:   "points after the last token when the line ends too soon",
+			source: "  main = 1 +\n",
+			wantDiags: []diagnostic{
+				{line: 1, col: 13, endCol: 14, code: codeUnexpectedEnd, msg: "unexpected end of line after +"},
+			},
+			wantText: "FILE:1:13: error[L0007]: unexpected end of line after +\n" +
+				"   1 |   main = 1 +\n" +
+				"     |             ^\n",
+		},
+		{
+			name:   "tokenizer errors",
+			source: "main = 1 + $2\n",
+			wantDiags: []diagnostic{
+				{line: 1, col: 12, endCol: 13, code: codeInvalidToken, msg: "invalid token: $"},
+			},
+		},
+		{
+			name:   "points into the line a function is continued in",
+			source: "f(x) =\n  x + /* y */\n    y\nmain = f(1)\n",
+			wantDiags: []diagnostic{
+				{line: 3, col: 5, endCol: 6, code: codeUndefinedVariable, msg: "undefined variable y"},
+			},
+			wantText: "FILE:3:5: error[L0008]: undefined variable y\n" +
+				"   3 |     y\n" +
+				"     |     ^\n",
+		},
+		{
+			name:   "unterminated block comment",
+			source: "main = 1 /* one\n",
+			wantDiags: []diagnostic{
+				{line: 1, col: 10, endCol: 12, code: codeInvalidToken, msg: "unterminated block comment"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			functions := tokenizeSource(t, tc.source)
+			_, _ = parse(functions)
+			diags := collectDiagnostics(functions)
+
+			if len(diags) != len(tc.wantDiags) {
+				t.Fatalf("got %v diagnostics, want %v: %v", len(diags), len(tc.wantDiags), diags)
+			}
+			for i, want := range tc.wantDiags {
+				got := diags[i]
+				if got.line != want.line || got.col != want.col || got.endCol != want.endCol ||
+					got.code != want.code || got.msg != want.msg || got.severity != want.severity {
+					t.Errorf("diagnostic %v = %+v, want %+v", i, got, want)
+				}
+			}
+
+			if tc.wantText == "" {
+				return
+			}
+			b := bytes.Buffer{}
+			printDiagnostics(&b, diags)
+			if got := strings.ReplaceAll(b.String(), diags[0].file, "FILE"); got != tc.wantText {
+				t.Errorf("printDiagnostics() =\n%v\nwant\n%v", got, tc.wantText)
+			}
+		})
+	}
+}
+
+func Test_reportDiagnostics(t *testing.T) {
+	functions := tokenizeSource(t, "f(x)=x\nf(x)=y\nmain = f(1,2)\n")
+	_, _ = parse(functions)
+	diags := collectDiagnostics(functions)
+
+	t.Run("json lines", func(t *testing.T) {
+		b := bytes.Buffer{}
+		if err := reportDiagnostics(&b, diagnosticsJSON, diags); err != nil {
+			t.Fatalf("reportDiagnostics() error = %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("got %v lines, want 2: %v", len(lines), b.String())
+		}
+		got := jsonDiagnostic{}
+		if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+			t.Fatalf("invalid json line %v: %v", lines[0], err)
+		}
+		want := jsonDiagnostic{
+			File: diags[0].file, Line: 2, Column: 1, EndColumn: 2, Severity: "error",
+			Code: "L0002", Rule: "duplicate-function", Message: "function f already defined",
+			Notes: []string{"previously defined at " + diags[0].file + ":1"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("json diagnostic = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("sarif", func(t *testing.T) {
+		b := bytes.Buffer{}
+		if err := reportDiagnostics(&b, diagnosticsSARIF, diags); err != nil {
+			t.Fatalf("reportDiagnostics() error = %v", err)
+		}
+		got := sarifLog{}
+		if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+			t.Fatalf("invalid sarif log: %v", err)
+		}
+		if got.Version != "2.1.0" || len(got.Runs) != 1 {
+			t.Fatalf("sarif log version %v with %v runs, want 2.1.0 with 1 run", got.Version, len(got.Runs))
+		}
+		run := got.Runs[0]
+		if len(run.Tool.Driver.Rules) != len(diagnosticRules) {
+			t.Errorf("got %v rules, want %v", len(run.Tool.Driver.Rules), len(diagnosticRules))
+		}
+		if len(run.Results) != 2 {
+			t.Fatalf("got %v results, want 2", len(run.Results))
+		}
+		for i, result := range run.Results {
+			if rule := run.Tool.Driver.Rules[result.RuleIndex]; rule.ID != result.RuleID || rule.ID != string(diags[i].code) {
+				t.Errorf("result %v rule %v at index %v, want %v", i, result.RuleID, result.RuleIndex, diags[i].code)
+			}
+		}
+		region := run.Results[1].Locations[0].PhysicalLocation.Region
+		if region == nil || *region != (sarifRegion{StartLine: 3, StartColumn: 8, EndColumn: 9}) {
+			t.Errorf("argument count region = %+v, want 3:8-9", region)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		if err := reportDiagnostics(&bytes.Buffer{}, "xml", diags); err == nil {
+			t.Errorf("reportDiagnostics() error = nil, want unknown format")
+		}
+	})
+}
+
+func Test_reportProgramDiagnostics(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		entry      string
+		lib        bool
+		want       []jsonDiagnostic // FILE stands for the file of the source
+		wantRegion []*sarifRegion
+	}{
+		{
+			name:   "main defined twice",
+			source: "main = 1\nmain = 2\nmain = 3\n",
+			entry:  defaultEntry,
+			want: []jsonDiagnostic{
+				{File: "FILE", Line: 2, Column: 1, EndColumn: 5, Severity: "error", Code: "L0031", Rule: "multiple-mains",
+					Message: "main already defined", Notes: []string{"previously defined at FILE:1"}},
+				{File: "FILE", Line: 3, Column: 1, EndColumn: 5, Severity: "error", Code: "L0031", Rule: "multiple-mains",
+					Message: "main already defined", Notes: []string{"previously defined at FILE:1"}},
+			},
+			wantRegion: []*sarifRegion{{StartLine: 2, StartColumn: 1, EndColumn: 5}, {StartLine: 3, StartColumn: 1, EndColumn: 5}},
+		},
+		{
+			name:   "no main is about the whole file",
+			source: "f(x)=x\n",
+			entry:  defaultEntry,
+			want: []jsonDiagnostic{
+				{File: "FILE", Severity: "error", Code: "L0032", Rule: "no-main", Message: "no main function defined",
+					Notes: []string{"the entry point is defined as main = expr, or picked with -entry name"}},
+			},
+			wantRegion: []*sarifRegion{nil},
+		},
+		{
+			name:   "a library needs no main",
+			source: "f(x)=x\n",
+			entry:  defaultEntry,
+			lib:    true,
+		},
+		{
+			name:   "an entry that is not defined is about the whole file",
+			source: "f(x)=x\n",
+			entry:  "g",
+			want: []jsonDiagnostic{
+				{File: "FILE", Severity: "error", Code: "L0033", Rule: "invalid-entry", Message: "invalid entry point: function g is not defined"},
+			},
+			wantRegion: []*sarifRegion{nil},
+		},
+		{
+			name:   "an entry with parameters",
+			source: "main = 1\nf(x)=x\n",
+			entry:  "f",
+			want: []jsonDiagnostic{
+				{File: "FILE", Line: 2, Column: 1, EndColumn: 2, Severity: "error", Code: "L0033", Rule: "invalid-entry",
+					Message: "invalid entry point: function f takes 1 parameters, it must take none",
+					Notes:   []string{"-entry picks a function called without arguments"}},
+			},
+			wantRegion: []*sarifRegion{{StartLine: 2, StartColumn: 1, EndColumn: 2}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			functions := tokenizeSource(t, tc.source)
+			file := functions[0].file
+			_, diags, _ := parseProgram(functions, []string{file}, tc.entry, tc.lib)
+
+			b := bytes.Buffer{}
+			if err := reportDiagnostics(&b, diagnosticsJSON, diags); err != nil {
+				t.Fatalf("reportDiagnostics() error = %v", err)
+			}
+			got := make([]jsonDiagnostic, 0)
+			dec := json.NewDecoder(strings.NewReader(strings.ReplaceAll(b.String(), file, "FILE")))
+			for dec.More() {
+				d := jsonDiagnostic{}
+				if err := dec.Decode(&d); err != nil {
+					t.Fatalf("invalid json line: %v", err)
+				}
+				got = append(got, d)
+			}
+			if len(got) != len(tc.want) || len(got) > 0 && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("json diagnostics =\n%+v\nwant\n%+v", got, tc.want)
+			}
+
+			b.Reset()
+			if err := reportDiagnostics(&b, diagnosticsSARIF, diags); err != nil {
+				t.Fatalf("reportDiagnostics() error = %v", err)
+			}
+			log := sarifLog{}
+			if err := json.Unmarshal(b.Bytes(), &log); err != nil {
+				t.Fatalf("invalid sarif log: %v", err)
+			}
+			results := log.Runs[0].Results
+			if len(results) != len(tc.wantRegion) {
+				t.Fatalf("got %v sarif results, want %v", len(results), len(tc.wantRegion))
+			}
+			for i, result := range results {
+				location := result.Locations[0].PhysicalLocation
+				if result.RuleID != tc.want[i].Code || location.ArtifactLocation.URI != file ||
+					!reflect.DeepEqual(location.Region, tc.wantRegion[i]) {
+					t.Errorf("sarif result %v = %v at %+v, want %v at %v", i, result.RuleID, location, tc.want[i].Code, tc.wantRegion[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test_differential compiles every data/X.lwl that has a matching data/X.c with both golwl and gcc,
+// runs them and compares their exit codes, along with the interpreter's result
+func Test_differential(t *testing.T) {
+	if !hasTools("gcc") {
+		t.Skip("the differential tests need gcc")
+	}
+	sources, err := filepath.Glob("data/*.lwl")
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	for _, source := range sources {
+		name := strings.TrimSuffix(filepath.Base(source), ".lwl")
+		cSource := filepath.Join("data", name+".c")
+		if _, err := os.Stat(cSource); err != nil {
+			continue // nothing to compare against
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			functions, err := tokenize([]string{source})
+			if err != nil {
+				t.Fatalf("tokenize() error = %v", err)
+			}
+			decls, err := parse(functions)
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			checkTypes(t, decls)
+			instructions, err := passemble(decls)
+			if err != nil {
+				t.Fatalf("passemble() error = %v", err)
+			}
+			lwlAsm, err := toGAS(instructions)
+			if err != nil {
+				t.Fatalf("toGAS() error = %v", err)
+			}
+			lwlBin := filepath.Join(dir, name+".lwl.bin")
+			if err := writeExecutable(instructions, lwlBin); err != nil {
+				t.Fatalf("writeExecutable() error = %v", err)
+			}
+			result, err := interpret(decls)
+			if err != nil {
+				t.Fatalf("interpret() error = %v", err)
+			}
+
+			cBin := filepath.Join(dir, name+".c.bin")
+			cAsm := filepath.Join(dir, name+".c.s")
+			for _, args := range [][]string{{"-O0", "-o", cBin, cSource}, {"-O0", "-S", "-o", cAsm, cSource}} {
+				if o, err := exec.Command("gcc", args...).CombinedOutput(); err != nil {
+					t.Fatalf("gcc %v: %v: %s", args, err, o)
+				}
+			}
+			cAsmCode, err := os.ReadFile(cAsm)
+			if err != nil {
+				t.Fatalf("read %v: %v", cAsm, err)
+			}
+
+			lwlCode, cCode := run(t, lwlBin), run(t, cBin)
+			if lwlCode != cCode || exitCode(result) != cCode {
+				t.Errorf("%v exits with %v (interpreted %v), %v exits with %v\n%v",
+					source, lwlCode, result, cSource, cCode, sideBySide(source, lwlAsm, cSource, string(cAsmCode)))
+			}
+		})
+	}
+}
+
+// sideBySide lays out two texts in columns so the generated assemblies can be compared
+func sideBySide(leftTitle, left, rightTitle, right string) string {
+	leftLines := append([]string{leftTitle, ""}, strings.Split(strings.ReplaceAll(left, "\t", "    "), "\n")...)
+	rightLines := append([]string{rightTitle, ""}, strings.Split(strings.ReplaceAll(right, "\t", "    "), "\n")...)
+	width := 0
+	for _, l := range leftLines {
+		width = max(width, len(l))
+	}
+
+	b := strings.Builder{}
+	for i := 0; i < max(len(leftLines), len(rightLines)); i++ {
+		l, r := "", ""
+		if i < len(leftLines) {
+			l = leftLines[i]
+		}
+		if i < len(rightLines) {
+			r = rightLines[i]
+		}
+		fmt.Fprintf(&b, "%-*s | %s\n", width, l, r)
+	}
+	return b.String()
+}
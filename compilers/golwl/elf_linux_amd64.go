@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+)
+
+// A minimal static ELF64 executable: the ELF header, a single loadable segment and the code right after them.
+// No sections, no symbols, no dynamic linking, just enough for the kernel to run it.
+// And a minimal ELF64 relocatable object: the code in .text and its labels in .symtab, enough for a linker.
+
+const (
+	elfBaseAddress = 0x400000
+	elfPageSize    = 0x1000
+	elfHeaderSize  = 64
+	elfProgSize    = 56
+	elfSectionSize = 64
+	elfSymSize     = 24
+)
+
+// writeExecutable encodes the instructions natively and writes them as a static executable starting at _start
+func writeExecutable(instructions []instruction, outfileName string) error {
+	e, err := encode(instructions)
+	if err != nil {
+		return err
+	}
+	start, ok := e.labels["_start"]
+	if !ok {
+		return errors.New("no _start to use as entry point")
+	}
+
+	codeOffset := uint64(elfHeaderSize + elfProgSize)
+	size := codeOffset + uint64(len(e.code))
+	header := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     elfBaseAddress + codeOffset + uint64(start),
+		Phoff:     elfHeaderSize,
+		Ehsize:    elfHeaderSize,
+		Phentsize: elfProgSize,
+		Phnum:     1,
+	}
+	setIdent(&header)
+
+	// the whole file is mapped, headers included, so the code sits right after them in memory too
+	prog := elf.Prog64{
+		Type:   uint32(elf.PT_LOAD),
+		Flags:  uint32(elf.PF_R | elf.PF_X),
+		Off:    0,
+		Vaddr:  elfBaseAddress,
+		Paddr:  elfBaseAddress,
+		Filesz: size,
+		Memsz:  size,
+		Align:  elfPageSize,
+	}
+
+	b := bytes.Buffer{}
+	if err := binary.Write(&b, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if err := binary.Write(&b, binary.LittleEndian, prog); err != nil {
+		return err
+	}
+	b.Write(e.code)
+	return os.WriteFile(outfileName, b.Bytes(), 0o755) //nolint:gosec // it is an executable
+}
+
+func setIdent(header *elf.Header64) {
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	header.Ident[elf.EI_OSABI] = byte(elf.ELFOSABI_NONE)
+}
+
+// writeObject encodes the instructions natively and writes them as a relocatable object,
+// every label is a symbol and the GLOBAL ones (and _start) are visible to other objects
+func writeObject(instructions []instruction, outfileName string) error {
+	e, err := encode(instructions)
+	if err != nil {
+		return err
+	}
+
+	// string tables start with an empty string so index 0 means no name
+	strtab := []byte{0}
+	addString := func(table *[]byte, s string) uint32 {
+		i := uint32(len(*table))
+		*table = append(append(*table, s...), 0)
+		return i
+	}
+
+	// locals must come before globals in the symbol table
+	symbols := []elf.Sym64{{}}
+	labels := make([]string, 0, len(e.labels))
+	for label := range e.labels {
+		// just like GAS, the local labels of jumps are left out
+		if !slices.Contains(e.globals, label) && !strings.HasPrefix(label, ".L") {
+			labels = append(labels, label)
+		}
+	}
+	slices.SortFunc(labels, func(a, b string) int {
+		if e.labels[a] == e.labels[b] {
+			return strings.Compare(a, b)
+		}
+		return e.labels[a] - e.labels[b]
+	})
+	for _, label := range labels {
+		symbols = append(symbols, elf.Sym64{
+			Name:  addString(&strtab, label),
+			Info:  elf.ST_INFO(elf.STB_LOCAL, elf.STT_NOTYPE),
+			Shndx: 1,
+			Value: uint64(e.labels[label]),
+		})
+	}
+	firstGlobal := len(symbols)
+	for _, label := range e.globals {
+		symbols = append(symbols, elf.Sym64{
+			Name:  addString(&strtab, label),
+			Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+			Shndx: 1,
+			Value: uint64(e.labels[label]),
+		})
+	}
+	symtab := bytes.Buffer{}
+	if err := binary.Write(&symtab, binary.LittleEndian, symbols); err != nil {
+		return err
+	}
+
+	shstrtab := []byte{0}
+	sections := []struct {
+		header elf.Section64
+		data   []byte
+	}{
+		{},
+		{header: elf.Section64{Type: uint32(elf.SHT_PROGBITS), Flags: uint64(elf.SHF_ALLOC | elf.SHF_EXECINSTR), Addralign: 16}, data: e.code},
+		{header: elf.Section64{Type: uint32(elf.SHT_SYMTAB), Link: 3, Info: uint32(firstGlobal), Addralign: 8, Entsize: elfSymSize}, data: symtab.Bytes()},
+		{header: elf.Section64{Type: uint32(elf.SHT_STRTAB), Addralign: 1}, data: strtab},
+		{header: elf.Section64{Type: uint32(elf.SHT_STRTAB), Addralign: 1}},
+		{header: elf.Section64{Type: uint32(elf.SHT_PROGBITS), Addralign: 1}}, // we do not need an executable stack
+	}
+	for i, name := range []string{".text", ".symtab", ".strtab", ".shstrtab", ".note.GNU-stack"} {
+		sections[i+1].header.Name = addString(&shstrtab, name)
+	}
+	sections[4].data = shstrtab
+
+	// the section contents go right after the ELF header, each one aligned, followed by the section headers
+	offset := uint64(elfHeaderSize)
+	for i := range sections[1:] {
+		s := &sections[i+1]
+		offset = (offset + s.header.Addralign - 1) / s.header.Addralign * s.header.Addralign
+		s.header.Off = offset
+		s.header.Size = uint64(len(s.data))
+		offset += s.header.Size
+	}
+	shoff := (offset + 7) / 8 * 8
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_REL),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     shoff,
+		Ehsize:    elfHeaderSize,
+		Shentsize: elfSectionSize,
+		Shnum:     uint16(len(sections)),
+		Shstrndx:  4,
+	}
+	setIdent(&header)
+
+	b := bytes.Buffer{}
+	if err := binary.Write(&b, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	for _, s := range sections[1:] {
+		b.Write(make([]byte, int(s.header.Off)-b.Len()))
+		b.Write(s.data)
+	}
+	b.Write(make([]byte, int(shoff)-b.Len()))
+	for _, s := range sections {
+		if err := binary.Write(&b, binary.LittleEndian, &s.header); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(outfileName, b.Bytes(), 0o600)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// This is a native x86-64 encoder for the pseudo-assembly, so we do not need as and ld around.
+// It picks the same encodings GAS picks for the output of toAs, that way both backends can be
+// cross-checked byte by byte.
+
+var registerNumbers = map[string]byte{
+	rax: 0,
+	rcx: 1,
+	rdx: 2,
+	rbx: 3,
+	rsp: 4,
+	rbp: 5,
+	rsi: 6,
+	rdi: 7,
+	r8:  8,
+	r9:  9,
+}
+
+// fixup is a rel32 that can only be written once we know where its label is
+type fixup struct {
+	at    int // offset of the rel32 in the code
+	label string
+}
+
+type encoder struct {
+	code    []byte
+	labels  map[string]int // label -> offset in the code
+	globals []string       // labels visible to the linker
+	fixups  []fixup
+}
+
+// aluEncodings are the opcodes of the two operand arithmetic instructions:
+// register to register, the /digit for an immediate and the short form for an immediate into RAX
+var aluEncodings = map[opset]struct {
+	rr, ext, raxImm byte
+}{
+	addop: {rr: 0x01, ext: 0, raxImm: 0x05},
+	subop: {rr: 0x29, ext: 5, raxImm: 0x2d},
+	cmpop: {rr: 0x39, ext: 7, raxImm: 0x3d},
+}
+
+// conditionCodes are the condition codes of the SETs, added to 0x90 for SETcc and to 0x80 for Jcc
+var conditionCodes = map[opset]byte{
+	seteop: 0x4, setneop: 0x5, setlop: 0xc, setleop: 0xe, setgop: 0xf, setgeop: 0xd,
+	setbop: 0x2, setbeop: 0x6, setaop: 0x7, setaeop: 0x3,
+}
+
+// encode transforms the instructions into machine code with every call and jump resolved
+func encode(instructions []instruction) (*encoder, error) {
+	e := &encoder{labels: make(map[string]int)}
+	for _, i := range instructions {
+		if err := e.encode(i); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range e.fixups {
+		target, ok := e.labels[f.label]
+		if !ok {
+			return nil, fmt.Errorf("undefined label %v", f.label)
+		}
+		binary.LittleEndian.PutUint32(e.code[f.at:], uint32(int32(target-(f.at+4))))
+	}
+	return e, nil
+}
+
+// encodedArgs is the number of args of every instruction the encoder handles
+var encodedArgs = map[opset]int{
+	funcstart: 1, globalop: 1, syscallop: 0, retop: 0, callop: 1, pushop: 1, popop: 1,
+	movop: 2, addop: 2, subop: 2, mulop: 2, divop: 2, modop: 2, negop: 1,
+	udivop: 2, umodop: 2, movsxop: 2, movzxop: 2, leaop: 2, cmpop: 2, boundsop: 2,
+	labelop: 1, jmpop: 1, jeop: 1, seteop: 1, setneop: 1, setlop: 1, setleop: 1, setgop: 1, setgeop: 1,
+	setbop: 1, setbeop: 1, setaop: 1, setaeop: 1,
+}
+
+// extendOpcodes are the opcodes of MOVSX and MOVZX by the number of bits extended
+var extendOpcodes = map[opset]map[int][]byte{
+	movsxop: {8: {0x0f, 0xbe}, 16: {0x0f, 0xbf}, 32: {0x63}},
+	movzxop: {8: {0x0f, 0xb6}, 16: {0x0f, 0xb7}},
+}
+
+func (e *encoder) encode(i instruction) error {
+	n, ok := encodedArgs[i.opcode]
+	if !ok {
+		return fmt.Errorf("unhandled op %v", i.opcode)
+	}
+	if len(i.args) != n {
+		return fmt.Errorf("invalid number of args for %v, expected %v, got: %v", i.opcode, n, i.args)
+	}
+
+	switch i.opcode {
+	case funcstart, globalop, labelop:
+		if _, exists := e.labels[i.args[0]]; exists {
+			return fmt.Errorf("label %v defined twice", i.args[0])
+		}
+		e.labels[i.args[0]] = len(e.code)
+		if i.opcode == globalop || i.args[0] == "_start" {
+			e.globals = append(e.globals, i.args[0])
+		}
+	case syscallop:
+		e.code = append(e.code, 0x0f, 0x05)
+	case retop:
+		e.code = append(e.code, 0xc3)
+	case callop, jmpop, jeop:
+		switch i.opcode {
+		case callop:
+			e.code = append(e.code, 0xe8)
+		case jmpop:
+			e.code = append(e.code, 0xe9)
+		case jeop:
+			e.code = append(e.code, 0x0f, 0x80+conditionCodes[seteop])
+		}
+		e.fixups = append(e.fixups, fixup{at: len(e.code), label: i.args[0]})
+		e.code = append(e.code, 0, 0, 0, 0)
+	case seteop, setneop, setlop, setleop, setgop, setgeop, setbop, setbeop, setaop, setaeop:
+		r, bits, ok := parseSubRegister(i.args[0])
+		if !ok || bits != 8 {
+			return fmt.Errorf("invalid args for %v, expected the lower 8 bits of a register, got: %v", i.opcode, i.args)
+		}
+		return e.opSized(8, []byte{0x0f, 0x90 + conditionCodes[i.opcode]}, 0, r)
+	case pushop, popop:
+		r, ok := registerNumbers[i.args[0]]
+		if !ok {
+			return fmt.Errorf("invalid args for %v, expected register, got: %v", i.opcode, i.args)
+		}
+		if r >= 8 {
+			e.code = append(e.code, 0x41)
+		}
+		opcode := byte(0x50)
+		if i.opcode == popop {
+			opcode = 0x58
+		}
+		e.code = append(e.code, opcode+r&7)
+	case movop:
+		return e.mov(i.args[0], i.args[1])
+	case leaop:
+		r, ok := registerNumbers[i.args[1]]
+		if !isMemory(i.args[0]) || !ok {
+			return fmt.Errorf("invalid args for %v, expected memory into a register, got: %v", i.opcode, i.args)
+		}
+		return e.op([]byte{0x8d}, r, i.args[0])
+	case addop, subop, mulop, cmpop:
+		return e.alu(i.opcode, i.args[0], i.args[1])
+	case boundsop:
+		return e.bounds(i.args[0], i.args[1])
+	case divop, modop:
+		if _, ok := registerNumbers[i.args[0]]; !ok || i.args[0] == rax || i.args[0] == rdx || i.args[1] != rax {
+			return fmt.Errorf("invalid args for %v, expected a register other than RAX or RDX into RAX, got: %v", i.opcode, i.args)
+		}
+		return e.div(i.opcode, i.args[0])
+	case udivop, umodop:
+		if _, ok := registerNumbers[i.args[0]]; !ok || i.args[0] == rax || i.args[0] == rdx || i.args[1] != rax {
+			return fmt.Errorf("invalid args for %v, expected a register other than RAX or RDX into RAX, got: %v", i.opcode, i.args)
+		}
+		e.code = append(e.code, 0x31, 0xd2) // XOR EDX, EDX
+		if err := e.op([]byte{0xf7}, 6, i.args[0]); err != nil {
+			return err
+		}
+		if i.opcode == umodop {
+			return e.mov(rdx, rax)
+		}
+	case movsxop, movzxop:
+		if bits, mem, ok := parseSizedMemory(i.args[0]); ok {
+			r, ok := registerNumbers[i.args[1]]
+			if !ok {
+				return fmt.Errorf("invalid args for %v, expected memory into a register, got: %v", i.opcode, i.args)
+			}
+			if i.opcode == movzxop && bits == 32 {
+				return e.opSized(32, []byte{0x8b}, r, mem) // MOV r32, m32
+			}
+			return e.op(extendOpcodes[i.opcode][bits], r, mem)
+		}
+		r, bits, ok := parseSubRegister(i.args[0])
+		if !ok || r != i.args[1] {
+			return fmt.Errorf("invalid args for %v, expected the lower bits of a register into itself, got: %v", i.opcode, i.args)
+		}
+		if i.opcode == movzxop && bits == 32 {
+			// MOV r32, r32 without REX.W, clearing the upper 32 bits
+			n := registerNumbers[r]
+			if n >= 8 {
+				e.code = append(e.code, 0x45)
+			}
+			e.code = append(e.code, 0x89, 0xc0|(n&7)<<3|n&7)
+			return nil
+		}
+		return e.op(extendOpcodes[i.opcode][bits], registerNumbers[r], r)
+	case negop:
+		if _, ok := registerNumbers[i.args[0]]; !ok {
+			return fmt.Errorf("invalid args for %v, expected register, got: %v", i.opcode, i.args)
+		}
+		return e.op([]byte{0xf7}, 3, i.args[0])
+	}
+	return nil
+}
+
+// div divides RAX by the divisor register as toAs does, going around IDIV when dividing by -1
+// so the smallest integer divided by -1 does not trap
+func (e *encoder) div(opcode opset, divisor string) error {
+	if err := e.op([]byte{0x83}, 7, divisor); err != nil { // CMP $-1, divisor
+		return err
+	}
+	e.code = append(e.code, 0xff)
+	e.code = append(e.code, 0x75, 0) // JNE rel8
+	jne := len(e.code)
+
+	var err error
+	if opcode == modop {
+		err = e.mov("0", rax)
+	} else {
+		err = e.op([]byte{0xf7}, 3, rax) // NEG
+	}
+	if err != nil {
+		return err
+	}
+	e.code = append(e.code, 0xeb, 0) // JMP rel8
+	jmp := len(e.code)
+	e.code[jne-1] = byte(jmp - jne)
+
+	e.code = append(e.code, 0x48, 0x99) // CQO
+	if err := e.op([]byte{0xf7}, 7, divisor); err != nil {
+		return err
+	}
+	if opcode == modop {
+		if err := e.mov(rdx, rax); err != nil {
+			return err
+		}
+	}
+	e.code[jmp-1] = byte(len(e.code) - jmp)
+	return nil
+}
+
+// bounds checks the index register against the length as toAs does, exiting right away when it is out of range
+func (e *encoder) bounds(length, index string) error {
+	if _, ok := registerNumbers[index]; !ok || !isConstant(length) {
+		return fmt.Errorf("invalid args for %v, expected a constant length and a register, got: %v, %v", boundsop, length, index)
+	}
+	if err := e.alu(cmpop, length, index); err != nil {
+		return err
+	}
+	e.code = append(e.code, 0x72, 0) // JB rel8
+	jb := len(e.code)
+	if err := e.mov(strconv.Itoa(outOfRangeExitCode), rdi); err != nil {
+		return err
+	}
+	if err := e.mov("60", rax); err != nil {
+		return err
+	}
+	e.code = append(e.code, 0x0f, 0x05) // SYSCALL
+	e.code[jb-1] = byte(len(e.code) - jb)
+	return nil
+}
+
+func (e *encoder) mov(src, dst string) error {
+	r, bits, isSub := parseSubRegister(src)
+	switch {
+	case isRegister(src) && (isRegister(dst) || isMemory(dst)):
+		return e.op([]byte{0x89}, registerNumbers[src], dst)
+	case isSub && isMemory(dst):
+		opcode := byte(0x89)
+		if bits == 8 {
+			opcode = 0x88
+		}
+		return e.opSized(bits, []byte{opcode}, registerNumbers[r], dst)
+	case isMemory(src) && isRegister(dst):
+		return e.op([]byte{0x8b}, registerNumbers[dst], src)
+	case isConstant(src) && isRegister(dst):
+		imm, err := strconv.ParseInt(src, 10, 64)
+		if err != nil {
+			return err
+		}
+		if imm != int64(int32(imm)) {
+			// MOVABS, the only way of getting a full 64 bit immediate
+			r := registerNumbers[dst]
+			e.code = append(e.code, 0x48|r>>3, 0xb8+r&7)
+			e.code = binary.LittleEndian.AppendUint64(e.code, uint64(imm))
+			return nil
+		}
+		if err := e.op([]byte{0xc7}, 0, dst); err != nil {
+			return err
+		}
+		e.code = binary.LittleEndian.AppendUint32(e.code, uint32(int32(imm)))
+		return nil
+	}
+	return fmt.Errorf("invalid args for MOV, got: %v, %v", src, dst)
+}
+
+func (e *encoder) alu(opcode opset, src, dst string) error {
+	if !isRegister(dst) {
+		return fmt.Errorf("invalid args for %v, expected registers or constant, got: %v, %v", opcode, src, dst)
+	}
+	if isRegister(src) {
+		if opcode == mulop {
+			return e.op([]byte{0x0f, 0xaf}, registerNumbers[dst], src)
+		}
+		return e.op([]byte{aluEncodings[opcode].rr}, registerNumbers[src], dst)
+	}
+
+	imm, err := strconv.ParseInt(src, 10, 64)
+	if err != nil || imm != int64(int32(imm)) {
+		return fmt.Errorf("invalid args for %v, expected registers or 32 bit constant, got: %v, %v", opcode, src, dst)
+	}
+	short := imm == int64(int8(imm))
+	switch {
+	case opcode == mulop && short:
+		err = e.op([]byte{0x6b}, registerNumbers[dst], dst)
+	case opcode == mulop:
+		err = e.op([]byte{0x69}, registerNumbers[dst], dst)
+	case short:
+		err = e.op([]byte{0x83}, aluEncodings[opcode].ext, dst)
+	case dst == rax:
+		e.code = append(e.code, 0x48, aluEncodings[opcode].raxImm)
+	default:
+		err = e.op([]byte{0x81}, aluEncodings[opcode].ext, dst)
+	}
+	if err != nil {
+		return err
+	}
+	if short {
+		e.code = append(e.code, byte(int8(imm)))
+	} else {
+		e.code = binary.LittleEndian.AppendUint32(e.code, uint32(int32(imm)))
+	}
+	return nil
+}
+
+// op writes a 64 bit instruction: REX.W, the opcode and the ModRM addressing the register (or /digit) reg
+// and the register or memory operand rm
+func (e *encoder) op(opcode []byte, reg byte, rm string) error {
+	return e.opSized(64, opcode, reg, rm)
+}
+
+// opSized writes an instruction with an operand size of bits: the operand size prefix for 16 bits
+// and a REX only when needed, which 8 bit operands need to address SPL, BPL, SIL and DIL instead of AH to BH
+func (e *encoder) opSized(bits int, opcode []byte, reg byte, rm string) error {
+	base, disp, isMem := parseMemory(rm)
+	b, ok := registerNumbers[rm]
+	if isMem {
+		b, ok = registerNumbers[base]
+	}
+	if !ok {
+		return fmt.Errorf("invalid operand %v", rm)
+	}
+
+	if bits == 16 {
+		e.code = append(e.code, 0x66)
+	}
+	rex := 0x40 | (reg>>3)<<2 | b>>3
+	if bits == 64 {
+		rex |= 0x08
+	}
+	if rex != 0x40 || bits == 8 && (reg >= 4 || !isMem && b >= 4) {
+		e.code = append(e.code, rex)
+	}
+	e.code = append(e.code, opcode...)
+	if !isMem {
+		e.code = append(e.code, 0xc0|(reg&7)<<3|b&7)
+		return nil
+	}
+
+	var mod byte
+	switch {
+	case disp == 0 && b&7 != 5: // RBP and R13 always need a displacement
+		mod = 0x00
+	case disp == int(int8(disp)):
+		mod = 0x40
+	default:
+		mod = 0x80
+	}
+	e.code = append(e.code, mod|(reg&7)<<3|b&7)
+	if b&7 == 4 { // RSP and R12 always need a SIB
+		e.code = append(e.code, 0x24)
+	}
+	switch mod {
+	case 0x40:
+		e.code = append(e.code, byte(int8(disp)))
+	case 0x80:
+		e.code = binary.LittleEndian.AppendUint32(e.code, uint32(int32(disp)))
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test_encode cross-checks the native encoder against the machine code GAS generates for the same instructions
+func Test_encode(t *testing.T) {
+	if !hasTools("as") {
+		t.Skip("cross-checking the encoder needs as")
+	}
+
+	tests := []struct {
+		name         string
+		source       string
+		instructions []instruction
+	}{
+		{
+			name:   "function call",
+			source: "f(x,y)=x+y\nmain = f(1,2)\n",
+		},
+		{
+			name:   "arithmetic and stack arguments",
+			source: "f(a,b,c,d,e,g,h)=(a-b)*c/d%e+g*h\nmain = f(100,2,3,4,5,6,7)-f(1,2,3,4,5,6,7)*1000\n",
+		},
+		{
+			name:   "sized types",
+			source: "f(a:i8,b:u16,c:i32,d:u32,e:u8,g:i16,h:u8):u8=u8(a*i8(b))+e*h/e%h+u8(c/i32(d)*g)\nmain = f(1,2,3,4,5,6,7)\n",
+		},
+		{
+			name:   "structs",
+			source: "struct s { a:i8, b:u16, c:i32, d:u32, e }\nf(x:s, y:s):s = s{a: x.a, b: y.b, c: x.c, d: y.d, e: x.e}\nmain = f(s{a: 1, b: 2, c: 3, d: 4, e: 5}, s{a: 1, b: 2, c: 3, d: 4, e: 5}).d\n",
+		},
+		{
+			name:   "arrays",
+			source: "f(a:[3]u16, b:[40]i32, i:i8):[2]u8 = [2]u8{u8(a[i] + a[2]), u8(b[i+1] * len(b))}\nmain = f([3]u16{1, 2, 3}, [40]i32{" + strings.Repeat("7, ", 39) + "7}, 1)[1]\n",
+		},
+		{
+			name:   "conditions",
+			source: "f(a:i8, b:u64, c:u16):u8 = a < -1 ? b >= 7 : c == 3 ? (a != 0) + (b > 1) : (c <= 9) * (a >= 2)\nmain = f(1, 2, 3)\n",
+		},
+		{
+			name: "operand forms",
+			instructions: []instruction{
+				{opcode: funcstart, args: []string{"a"}},
+				{opcode: movop, args: []string{"3000000000", rax}},
+				{opcode: movop, args: []string{"-9223372036854775808", r9}},
+				{opcode: movop, args: []string{"-5", r8}},
+				{opcode: movop, args: []string{r8, r9}},
+				{opcode: movop, args: []string{rax, "[RBP-8]"}},
+				{opcode: movop, args: []string{"[RBP+0]", rbx}},
+				{opcode: movop, args: []string{"[RBP+200]", r8}},
+				{opcode: movop, args: []string{"[RSP+8]", rax}},
+				{opcode: movop, args: []string{r9, "[RSP+0]"}},
+				{opcode: addop, args: []string{"16", rsp}},
+				{opcode: addop, args: []string{"1000", rax}},
+				{opcode: subop, args: []string{"1000", rax}},
+				{opcode: subop, args: []string{"-1000", r9}},
+				{opcode: subop, args: []string{r9, rax}},
+				{opcode: mulop, args: []string{"3", rax}},
+				{opcode: mulop, args: []string{"1000", rcx}},
+				{opcode: mulop, args: []string{r8, rdx}},
+				{opcode: divop, args: []string{r9, rax}},
+				{opcode: modop, args: []string{rcx, rax}},
+				{opcode: negop, args: []string{rax}},
+				{opcode: negop, args: []string{r9}},
+				{opcode: udivop, args: []string{rbx, rax}},
+				{opcode: umodop, args: []string{r9, rax}},
+				{opcode: movsxop, args: []string{"AL", rax}},
+				{opcode: movsxop, args: []string{"DIL", rdi}},
+				{opcode: movsxop, args: []string{"R9W", r9}},
+				{opcode: movsxop, args: []string{"ESI", rsi}},
+				{opcode: movzxop, args: []string{"SIL", rsi}},
+				{opcode: movzxop, args: []string{"R8B", r8}},
+				{opcode: movzxop, args: []string{"CX", rcx}},
+				{opcode: movzxop, args: []string{"EAX", rax}},
+				{opcode: movzxop, args: []string{"R9D", r9}},
+				{opcode: movsxop, args: []string{"BYTE [RAX+3]", rax}},
+				{opcode: movsxop, args: []string{"WORD [RBP-18]", r8}},
+				{opcode: movsxop, args: []string{"DWORD [RSP+4]", rbx}},
+				{opcode: movzxop, args: []string{"BYTE [R9+0]", rdi}},
+				{opcode: movzxop, args: []string{"WORD [RAX+200]", rax}},
+				{opcode: movzxop, args: []string{"DWORD [RDI+8]", rbx}},
+				{opcode: movzxop, args: []string{"DWORD [RBP-8]", r9}},
+				{opcode: movop, args: []string{"BL", "[RDI+1]"}},
+				{opcode: movop, args: []string{"SIL", "[RSP+0]"}},
+				{opcode: movop, args: []string{"R8B", "[RAX+0]"}},
+				{opcode: movop, args: []string{"AX", "[RBP-10]"}},
+				{opcode: movop, args: []string{"R9W", "[RAX+2]"}},
+				{opcode: movop, args: []string{"EBX", "[R8+4]"}},
+				{opcode: movop, args: []string{"EAX", "[RBP-300]"}},
+				{opcode: leaop, args: []string{"[RBP-24]", rax}},
+				{opcode: leaop, args: []string{"[RSP+0]", r9}},
+				{opcode: cmpop, args: []string{rbx, rax}},
+				{opcode: cmpop, args: []string{"1000", rax}},
+				{opcode: cmpop, args: []string{"-1", r9}},
+				{opcode: boundsop, args: []string{"4", rbx}},
+				{opcode: boundsop, args: []string{"65536", rax}},
+				{opcode: pushop, args: []string{r8}},
+				{opcode: popop, args: []string{rdi}},
+				{opcode: callop, args: []string{"b"}},
+				{opcode: callop, args: []string{"a"}},
+				{opcode: seteop, args: []string{"AL"}},
+				{opcode: setbop, args: []string{"DIL"}},
+				{opcode: setaeop, args: []string{"R8B"}},
+				{opcode: jeop, args: []string{".L1"}},
+				{opcode: jmpop, args: []string{".L1"}},
+				{opcode: labelop, args: []string{".L1"}},
+				{opcode: jmpop, args: []string{".L1"}},
+				{opcode: globalop, args: []string{"c"}},
+				{opcode: funcstart, args: []string{"b"}},
+				{opcode: retop},
+				{opcode: syscallop},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			instructions := tc.instructions
+			if tc.source != "" {
+				decls, err := parse(tokenizeSource(t, tc.source))
+				if err != nil {
+					t.Fatalf("parse() error = %v", err)
+				}
+				checkTypes(t, decls)
+				instructions, err = passemble(decls)
+				if err != nil {
+					t.Fatalf("passemble() error = %v", err)
+				}
+			}
+
+			e, err := encode(instructions)
+			if err != nil {
+				t.Fatalf("encode() error = %v", err)
+			}
+
+			asCode, err := toGAS(instructions)
+			if err != nil {
+				t.Fatalf("toGAS() error = %v", err)
+			}
+			objFileName := filepath.Join(t.TempDir(), "output.o")
+			if err := assemble(asCode, objFileName); err != nil {
+				t.Fatalf("assemble() error = %v", err)
+			}
+			obj, err := elf.Open(objFileName)
+			if err != nil {
+				t.Fatalf("open object: %v", err)
+			}
+			defer obj.Close()
+			want, err := obj.Section(".text").Data()
+			if err != nil {
+				t.Fatalf("read .text: %v", err)
+			}
+
+			if !bytes.Equal(e.code, want) {
+				t.Errorf("encode() =\n% x\nwant\n% x\nfor\n%v", e.code, want, asCode)
+			}
+		})
+	}
+}
+
+func Test_writeExecutable(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "output")
+	err := writeExecutable([]instruction{
+		{opcode: funcstart, args: []string{"_start"}},
+		{opcode: movop, args: []string{"42", rdi}},
+		{opcode: movop, args: []string{"60", rax}},
+		{opcode: syscallop},
+	}, output)
+	if err != nil {
+		t.Fatalf("writeExecutable() error = %v", err)
+	}
+
+	exe, err := elf.Open(output)
+	if err != nil {
+		t.Fatalf("open executable: %v", err)
+	}
+	defer exe.Close()
+	if exe.Type != elf.ET_EXEC || exe.Machine != elf.EM_X86_64 || len(exe.Progs) != 1 {
+		t.Errorf("got a %v for %v with %v segments, want a single segment %v for %v", exe.Type, exe.Machine, len(exe.Progs), elf.ET_EXEC, elf.EM_X86_64)
+	}
+	if got := run(t, output); got != 42 {
+		t.Errorf("exit code = %v, want 42", got)
+	}
+
+	err = writeExecutable([]instruction{{opcode: funcstart, args: []string{"f"}}, {opcode: retop}}, output)
+	if err == nil {
+		t.Errorf("writeExecutable() without _start error = nil, want error")
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// programGenerator writes random well-formed LWL programs: struct declarations and function definitions
+// with typed and untyped parameters whose bodies use every operator, parenthesis, casts, fields, indices
+// and calls to the functions defined before them, so there is no recursion and every program terminates
+type programGenerator struct {
+	r         *rand.Rand
+	structs   []*lwlType  // each struct sN
+	arrays    []*lwlType  // the array types the functions take and return
+	functions []*funcDecl // the signature of each function fN
+	lets      int         // lets written so far, numbering the name of the next one vN so it shadows nothing
+	maxDepth  int
+}
+
+var generatedTypes = []*lwlType{typeI8, typeI16, typeI32, typeI64, typeU8, typeU16, typeU32, typeU64}
+
+func generateProgram(r *rand.Rand) string {
+	g := &programGenerator{r: r, maxDepth: 1 + r.IntN(5)}
+	b := strings.Builder{}
+	for i := range r.IntN(3) {
+		fields := make([]*field, 1+r.IntN(5)) // up to 5 so some are too big for registers
+		for j := range fields {
+			fields[j] = &field{name: fmt.Sprintf("m%v", j), typ: g.randomType()}
+		}
+		s := newStructType(position{}, fmt.Sprintf("s%v", i), fields)
+		b.WriteString(s.declaration() + "\n")
+		g.structs = append(g.structs, s)
+	}
+	for range r.IntN(3) {
+		g.arrays = append(g.arrays, newArrayType(g.randomType(), 1+r.IntN(6)))
+	}
+	for i := range r.IntN(6) {
+		f := &funcDecl{name: fmt.Sprintf("f%v", i)}
+		if r.IntN(2) == 0 {
+			f.result = g.randomParamType()
+		}
+		for j := range r.IntN(9) { // up to 8 so some go on the stack
+			p := &ident{name: fmt.Sprintf("p%v", j)}
+			if r.IntN(2) == 0 {
+				p.setType(g.randomParamType())
+			}
+			f.params = append(f.params, p)
+		}
+		params := make([]string, len(f.params))
+		for j, p := range f.params {
+			params[j] = p.name + annotation(p.typ)
+		}
+		fmt.Fprintf(&b, "%v(%v)%v=%v\n", f.name, strings.Join(params, ","), annotation(f.result), g.value(f.params, f.resultType(), 0))
+		g.functions = append(g.functions, f)
+	}
+	b.WriteString("main=" + g.expr(nil, typeI64, 0) + "\n")
+	return b.String()
+}
+
+func (g *programGenerator) randomType() *lwlType {
+	return generatedTypes[g.r.IntN(len(generatedTypes))]
+}
+
+// randomParamType is an integer type or, once in a while, one of the structs or arrays
+func (g *programGenerator) randomParamType() *lwlType {
+	aggregates := slices.Concat(g.structs, g.arrays)
+	if len(aggregates) > 0 && g.r.IntN(4) == 0 {
+		return aggregates[g.r.IntN(len(aggregates))]
+	}
+	return g.randomType()
+}
+
+// value writes an expression of type t, integer, struct or array
+func (g *programGenerator) value(params []*ident, t *lwlType, depth int) string {
+	if t.isInt() {
+		return g.expr(params, t, depth)
+	}
+	return g.aggregateExpr(params, t, depth)
+}
+
+// aggregateExpr writes an expression of the struct or array type t: a parameter, a literal or a call
+func (g *programGenerator) aggregateExpr(params []*ident, t *lwlType, depth int) string {
+	candidates := make([]string, 0)
+	for _, p := range params {
+		if p.exprType() == t {
+			candidates = append(candidates, p.name)
+		}
+	}
+	if len(candidates) > 0 && (depth >= g.maxDepth || g.r.IntN(2) == 0) {
+		return candidates[g.r.IntN(len(candidates))]
+	}
+	if depth < g.maxDepth && g.r.IntN(2) == 0 {
+		for _, f := range g.functions {
+			if f.resultType() == t {
+				return g.call(params, f, depth)
+			}
+		}
+	}
+	if t.kind == kindArray {
+		elems := make([]string, t.length)
+		for i := range elems {
+			elems[i] = g.expr(params, t.elem, depth+1)
+		}
+		return t.String() + "{" + strings.Join(elems, ", ") + "}"
+	}
+	fields := make([]string, len(t.fields))
+	for i, f := range t.fields {
+		fields[i] = f.name + ": " + g.expr(params, f.typ, depth+1)
+	}
+	g.r.Shuffle(len(fields), func(i, j int) { fields[i], fields[j] = fields[j], fields[i] })
+	return t.String() + "{" + strings.Join(fields, ", ") + "}"
+}
+
+// call writes a call to f with random arguments
+func (g *programGenerator) call(params []*ident, f *funcDecl, depth int) string {
+	args := make([]string, len(f.params))
+	for i, p := range f.params {
+		args[i] = g.value(params, p.exprType(), depth+1)
+	}
+	return fmt.Sprintf("%v(%v)", f.name, strings.Join(args, ", "))
+}
+
+// expr writes an expression of type t
+func (g *programGenerator) expr(params []*ident, t *lwlType, depth int) string {
+	if depth >= g.maxDepth {
+		return g.leaf(params, t)
+	}
+	switch g.r.IntN(10) {
+	case 0:
+		return g.leaf(params, t)
+	case 1:
+		return "(" + g.expr(params, t, depth+1) + ")"
+	case 2:
+		if t.signed {
+			return "-" + g.expr(params, t, depth+1)
+		}
+		return "-(" + g.expr(params, t, depth+1) + ")" // a negative constant would not fit
+	case 3:
+		return t.String() + "(" + g.expr(params, g.randomType(), depth+1) + ")"
+	case 4:
+		if len(g.functions) > 0 {
+			f := g.functions[g.r.IntN(len(g.functions))]
+			if !f.resultType().isInt() {
+				break
+			}
+			call := g.call(params, f, depth)
+			if f.resultType() != t {
+				return t.String() + "(" + call + ")"
+			}
+			return call
+		}
+	case 5:
+		if len(g.structs) > 0 {
+			s := g.structs[g.r.IntN(len(g.structs))]
+			f := s.fields[g.r.IntN(len(s.fields))]
+			read := g.aggregateExpr(params, s, depth+1) + "." + f.name
+			if f.typ != t {
+				return t.String() + "(" + read + ")"
+			}
+			return read
+		}
+	case 6:
+		if len(g.arrays) > 0 {
+			a := g.arrays[g.r.IntN(len(g.arrays))]
+			read := g.aggregateExpr(params, a, depth+1) + "[" + g.index(params, a, depth+1) + "]"
+			if a.elem != t {
+				return t.String() + "(" + read + ")"
+			}
+			return read
+		}
+	case 7:
+		// both sides of the comparison are of the same type, whatever the type of its result
+		u := g.randomType()
+		ops := []string{"==", "!=", "<", "<=", ">", ">="}
+		return "(" + g.expr(params, u, depth+1) + " " + ops[g.r.IntN(len(ops))] + " " + g.expr(params, u, depth+1) + ")"
+	case 8:
+		return "(" + g.expr(params, g.randomType(), depth+1) + " ? " + g.expr(params, t, depth+1) + " : " + g.expr(params, t, depth+1) + ")"
+	case 9:
+		// annotated, a constant value would be an i64 otherwise
+		v := &ident{name: fmt.Sprintf("v%v", g.lets)}
+		v.setType(g.randomParamType())
+		g.lets++
+		value := g.value(params, v.typ, depth+1)
+		return "(let " + v.name + annotation(v.typ) + " = " + value + " in " + g.expr(slices.Concat(params, []*ident{v}), t, depth+1) + ")"
+	}
+
+	// the left side might be narrower, it is implicitly widened
+	lhsType := g.randomType()
+	if !lhsType.widensTo(t) {
+		lhsType = t
+	}
+	ops := []string{"+", "-", "*", "/", "%"}
+	op := ops[g.r.IntN(len(ops))]
+	rhs := g.expr(params, t, depth+1)
+	if op == "/" || op == "%" {
+		// division by a constant zero does not compile, and without parenthesis
+		// the divisor could be just the first literal of the right hand side
+		switch {
+		case strings.Trim(rhs, "()-") == "0":
+			rhs = "1"
+		case strings.Contains(rhs, " "):
+			rhs = "(" + rhs + ")"
+		}
+	}
+	lhs := g.expr(params, lhsType, depth+1)
+	if lhsType != t {
+		// constants take the type of the other side, so the right side can not be left untyped, and
+		// the whole operation is kept together so regrouping does not pair constants with the narrow side
+		return "((" + lhs + ") " + op + " " + t.String() + "(" + rhs + "))"
+	}
+	return lhs + " " + op + " " + rhs
+}
+
+// index writes an index into the array type t: a constant within it, one computed to be within it
+// or, once in a while, whatever value, most likely out of range so the program exits right there
+func (g *programGenerator) index(params []*ident, t *lwlType, depth int) string {
+	switch g.r.IntN(8) {
+	case 0:
+		// cast, so it is never a constant checked at compile time
+		return "i64(" + g.expr(params, g.randomType(), depth) + ")"
+	case 1, 2, 3:
+		return fmt.Sprintf("u16(%v) %% %v", g.expr(params, g.randomType(), depth), t.length)
+	}
+	return fmt.Sprint(g.r.IntN(t.length))
+}
+
+// leaf writes an integer parameter or a constant of type t
+func (g *programGenerator) leaf(params []*ident, t *lwlType) string {
+	ints := slices.DeleteFunc(slices.Clone(params), func(p *ident) bool { return !p.exprType().isInt() })
+	if len(ints) > 0 && g.r.IntN(2) == 0 {
+		p := ints[g.r.IntN(len(ints))]
+		if p.exprType() == t { // a narrower one would give its type to the constants next to it
+			return p.name
+		}
+		return t.String() + "(" + p.name + ")"
+	}
+	// the edges of the integers are where the backends are most likely to get it wrong
+	largest := int64(math.MaxInt64) >> (64 - t.bits)
+	if !t.signed && t.bits < 64 {
+		largest = int64(1)<<t.bits - 1
+	}
+	switch g.r.IntN(10) {
+	case 0:
+		if t.signed {
+			return "-1"
+		}
+	case 1:
+		if t.signed {
+			return fmt.Sprint(int64(math.MinInt64) >> (64 - t.bits))
+		}
+	case 2:
+		return fmt.Sprint(largest)
+	}
+	return fmt.Sprint(g.r.Int64N(min(largest, 999) + 1))
+}
+
+// writeSource writes the source into a temporary file for the tokenizer
+func writeSource(t *testing.T, source string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "fuzz.lwl")
+	if err := os.WriteFile(file, []byte(source), 0o600); err != nil {
+		t.Fatalf("failed to write fuzz file: %v", err)
+	}
+	return file
+}
+
+func addSeedSources(f *testing.F) {
+	sources, _ := filepath.Glob("data/*.lwl")
+	for _, source := range sources {
+		contents, err := os.ReadFile(source)
+		if err != nil {
+			f.Fatalf("read %v: %v", source, err)
+		}
+		f.Add(string(contents))
+	}
+	for _, source := range []string{"", "f(", "1+", "()", "f(x)=x\nmain = f(1,2)\n", "1/0", "f(x,x)=x=x\n", "$ 1 + \t2\n\nmain = 3",
+		"f(x, # x\n  y) = x +\n\t/* y\n */ y\nmain = f(1, 2) // 3\n", "main = 1 /* 2"} {
+		f.Add(source)
+	}
+}
+
+func FuzzTokenize(f *testing.F) {
+	addSeedSources(f)
+	f.Fuzz(func(t *testing.T, source string) {
+		functions, err := tokenize([]string{writeSource(t, source)})
+		if err != nil {
+			t.Fatalf("tokenize() error = %v", err)
+		}
+		lines := strings.Split(source, "\n")
+		for _, fn := range functions {
+			for _, tkn := range fn.tkns {
+				if tkn.line < 1 || tkn.line > len(lines) || tkn.col < 1 || tkn.col+len(tkn.v)-1 > len(lines[tkn.line-1]) {
+					t.Fatalf("token %q at %v:%v is out of the source", tkn.v, tkn.line, tkn.col)
+				}
+				if got := lines[tkn.line-1][tkn.col-1 : tkn.col-1+len(tkn.v)]; got != tkn.v {
+					t.Fatalf("token %q at %v:%v points to %q", tkn.v, tkn.line, tkn.col, got)
+				}
+			}
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	addSeedSources(f)
+	f.Add("(x) = 1\n$\nmain = 1")
+	f.Add("=0\n\"0")
+	f.Fuzz(func(t *testing.T, source string) {
+		functions, err := tokenize([]string{writeSource(t, source)})
+		if err != nil {
+			t.Fatalf("tokenize() error = %v", err)
+		}
+		decls, err := parse(functions)
+		if err != nil {
+			if countErrors(collectDiagnostics(functions)) == 0 && errors.Is(err, errParse) {
+				t.Fatalf("parse() error = %v without any diagnostic", err)
+			}
+			return
+		}
+		if diags, err := check(decls); err != nil {
+			if len(diags) == 0 {
+				t.Fatalf("check() error = %v without any diagnostic", err)
+			}
+			return
+		}
+		// whatever type checks must be possible to lower and to interpret
+		if _, err := passemble(decls); err != nil {
+			t.Fatalf("passemble() error = %v", err)
+		}
+		_, _ = interpret(decls)
+	})
+}
+
+// FuzzPassemble compiles random programs and checks they exit just like the interpreter says,
+// a program that fails at runtime in the interpreter must be killed by a signal or exit out of range
+// when compiled, which one depends on the order the failing expressions are evaluated in
+func FuzzPassemble(f *testing.F) {
+	for i := range uint64(8) {
+		f.Add(i, i*7919)
+	}
+	f.Fuzz(func(t *testing.T, seed1, seed2 uint64) {
+		source := generateProgram(rand.New(rand.NewPCG(seed1, seed2)))
+		functions, err := tokenize([]string{writeSource(t, source)})
+		if err != nil {
+			t.Fatalf("tokenize() error = %v", err)
+		}
+		decls, err := parse(functions)
+		if err != nil {
+			t.Fatalf("parse() error = %v\n%v\n%v", err, collectDiagnostics(functions), source)
+		}
+		if diags, err := check(decls); err != nil {
+			t.Fatalf("check() error = %v\n%v\n%v", err, diags, source)
+		}
+		instructions, err := passemble(decls)
+		if err != nil {
+			t.Fatalf("passemble() error = %v\n%v", err, source)
+		}
+		if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+			return
+		}
+		output := filepath.Join(t.TempDir(), "output")
+		if err := writeExecutable(instructions, output); err != nil {
+			t.Fatalf("writeExecutable() error = %v\n%v", err, source)
+		}
+
+		got := run(t, output)
+		result, err := interpret(decls)
+		switch {
+		case err != nil && got != -1 && got != outOfRangeExitCode:
+			t.Errorf("interpret() error = %v, but the binary exited with %v\n%v", err, got, source)
+		case err == nil && got != exitCode(result):
+			t.Errorf("exit code = %v, interpreted %v (exit code %v)\n%v", got, result, exitCode(result), source)
+		}
+	})
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"runtime/debug"
+	"slices"
+)
+
+// This is a tree-walking interpreter for the LWL language.
+// It is the reference of what a program means: whatever the compiled binary exits with must match it.
+// Integers behave just like Go's integers of the same type: they wrap around on overflow and the
+// smallest one divided by -1 is itself, values are kept in an int64 extended from their width.
+// Structs and arrays are kept as the values of their fields or elements in order, and indexing
+// an array out of its range stops the program just like the compiled binary does.
+
+var (
+	errRuntime    = errors.New("runtime error")
+	errOutOfRange = fmt.Errorf("%w: out of range", errRuntime)
+)
+
+const (
+	// maxDepth stops runaway recursion before it eats the interpreter's stack, counting the expressions
+	// evaluated one inside the other since each takes some of it, and not only the calls. It is above what
+	// the 8MB stack of a compiled binary holds: with the smallest frames of 24 bytes it overflows at about
+	// 350 thousand calls, each at least a call and the body around the next one, so 700 thousand expressions
+	maxDepth = 1 << 20
+	// maxStack lets the stack of the interpreter grow to the 1GB maxDepth expressions take at most
+	maxStack = 2 << 30
+)
+
+type interpreter struct {
+	functions map[string]*funcDecl
+	depth     int // expressions being evaluated, one inside the other
+}
+
+// frame holds the values of the parameters of a function call, and of the lets around the expression evaluated
+type frame struct {
+	ints       map[string]int64
+	aggregates map[string]aggregate
+}
+
+// aggregate holds the values of the fields of a struct or the elements of an array, in order
+type aggregate []int64
+
+// interpret evaluates the main function of the program and returns its result
+func interpret(decls []*funcDecl) (int64, error) {
+	in := &interpreter{functions: make(map[string]*funcDecl)}
+	var main *funcDecl
+	for _, d := range decls {
+		if d.main {
+			main = d
+			continue
+		}
+		in.functions[d.name] = d
+	}
+	if main == nil {
+		return 0, errNoMain
+	}
+	defer debug.SetMaxStack(debug.SetMaxStack(maxStack))
+	return in.eval(main.body, frame{})
+}
+
+// exitCode is what the process exits with when the program results in v
+func exitCode(v int64) int {
+	return int(uint8(v))
+}
+
+func (in *interpreter) eval(e expr, vars frame) (int64, error) {
+	in.depth++
+	defer in.leave()
+	switch e := e.(type) {
+	case *literal:
+		return e.value, nil
+	case *ident:
+		v, ok := vars.ints[e.name]
+		if !ok {
+			return 0, in.errorf(e, "undefined variable %v", e.name)
+		}
+		return v, nil
+	case *unaryExpr:
+		v, err := in.eval(e.operand, vars)
+		if err != nil {
+			return 0, err
+		}
+		if e.op == tsub {
+			return e.exprType().wrap(-v), nil
+		}
+	case *castExpr:
+		v, err := in.eval(e.operand, vars)
+		if err != nil {
+			return 0, err
+		}
+		return e.exprType().wrap(v), nil
+	case *binaryExpr:
+		lhs, err := in.eval(e.lhs, vars)
+		if err != nil {
+			return 0, err
+		}
+		rhs, err := in.eval(e.rhs, vars)
+		if err != nil {
+			return 0, err
+		}
+		if isComparison(e.op) {
+			return compare(e, lhs, rhs), nil
+		}
+		t := e.exprType()
+		switch e.op {
+		case tadd:
+			return t.wrap(lhs + rhs), nil
+		case tsub:
+			return t.wrap(lhs - rhs), nil
+		case tmul:
+			return t.wrap(lhs * rhs), nil
+		case tdiv, tmod:
+			if rhs == 0 {
+				return 0, in.errorf(e, "division by zero")
+			}
+			switch {
+			case !t.signed && e.op == tdiv:
+				return t.wrap(int64(uint64(lhs) / uint64(rhs))), nil
+			case !t.signed:
+				return t.wrap(int64(uint64(lhs) % uint64(rhs))), nil
+			case e.op == tdiv:
+				return t.wrap(lhs / rhs), nil
+			}
+			return t.wrap(lhs % rhs), nil
+		}
+	case *condExpr:
+		branch, err := in.branch(e, vars)
+		if err != nil {
+			return 0, err
+		}
+		return in.eval(branch, vars)
+	case *letExpr:
+		inner, err := in.bind(e, vars)
+		if err != nil {
+			return 0, err
+		}
+		return in.eval(e.body, inner)
+	case *fieldExpr:
+		s, err := in.evalAggregate(e.operand, vars)
+		if err != nil {
+			return 0, err
+		}
+		return s[slices.Index(e.operand.exprType().fields, e.field)], nil
+	case *indexExpr:
+		a, err := in.evalAggregate(e.operand, vars)
+		if err != nil {
+			return 0, err
+		}
+		i, err := in.eval(e.index, vars)
+		if err != nil {
+			return 0, err
+		}
+		if uint64(i) >= uint64(len(a)) {
+			p := e.pos()
+			return 0, fmt.Errorf("%w: %v:%v:%v: index %v of %v", errOutOfRange, p.file, p.line, p.col, i, e.operand.exprType())
+		}
+		return a[i], nil
+	case *lenExpr:
+		return int64(e.length), nil
+	case *callExpr:
+		f, callee, err := in.enter(e, vars)
+		if err != nil {
+			return 0, err
+		}
+		return in.eval(f.body, callee)
+	}
+	return 0, in.errorf(e, "unsupported expression %v", e)
+}
+
+// evalAggregate evaluates an expression of a struct or array type
+func (in *interpreter) evalAggregate(e expr, vars frame) (aggregate, error) {
+	in.depth++
+	defer in.leave()
+	switch e := e.(type) {
+	case *ident:
+		v, ok := vars.aggregates[e.name]
+		if !ok {
+			return nil, in.errorf(e, "undefined variable %v", e.name)
+		}
+		return v, nil
+	case *structLit:
+		fields := e.exprType().fields
+		v := make(aggregate, len(fields))
+		for _, f := range e.fields {
+			fv, err := in.eval(f.value, vars)
+			if err != nil {
+				return nil, err
+			}
+			v[slices.Index(fields, f.field)] = fv
+		}
+		return v, nil
+	case *arrayLit:
+		v := make(aggregate, len(e.elems))
+		for i, elem := range e.elems {
+			ev, err := in.eval(elem, vars)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = ev
+		}
+		return v, nil
+	case *condExpr:
+		branch, err := in.branch(e, vars)
+		if err != nil {
+			return nil, err
+		}
+		return in.evalAggregate(branch, vars)
+	case *letExpr:
+		inner, err := in.bind(e, vars)
+		if err != nil {
+			return nil, err
+		}
+		return in.evalAggregate(e.body, inner)
+	case *callExpr:
+		f, callee, err := in.enter(e, vars)
+		if err != nil {
+			return nil, err
+		}
+		return in.evalAggregate(f.body, callee)
+	}
+	return nil, in.errorf(e, "unsupported expression %v", e)
+}
+
+// compare results in 1 if the comparison e holds for the values of its operands and 0 otherwise
+func compare(e *binaryExpr, lhs, rhs int64) int64 {
+	c := cmp.Compare(lhs, rhs)
+	if !e.comparesSigned() {
+		c = cmp.Compare(uint64(lhs), uint64(rhs))
+	}
+	holds := map[tokenType]bool{teqeq: c == 0, tneq: c != 0, tlt: c < 0, tle: c <= 0, tgt: c > 0, tge: c >= 0}[e.op]
+	if holds {
+		return 1
+	}
+	return 0
+}
+
+// branch evaluates the condition of e and returns the branch it picks
+func (in *interpreter) branch(e *condExpr, vars frame) (expr, error) {
+	cond, err := in.eval(e.cond, vars)
+	if err != nil {
+		return nil, err
+	}
+	if cond != 0 {
+		return e.then, nil
+	}
+	return e.els, nil
+}
+
+// bind evaluates the value of e into a copy of vars holding it by the name of e, so only the body sees it
+func (in *interpreter) bind(e *letExpr, vars frame) (frame, error) {
+	inner := frame{ints: make(map[string]int64, len(vars.ints)+1), aggregates: make(map[string]aggregate, len(vars.aggregates)+1)}
+	maps.Copy(inner.ints, vars.ints)
+	maps.Copy(inner.aggregates, vars.aggregates)
+	name := e.name.name
+	delete(inner.ints, name)
+	delete(inner.aggregates, name)
+	if !e.value.exprType().isInt() {
+		v, err := in.evalAggregate(e.value, vars)
+		if err != nil {
+			return frame{}, err
+		}
+		inner.aggregates[name] = v
+		return inner, nil
+	}
+	v, err := in.eval(e.value, vars)
+	if err != nil {
+		return frame{}, err
+	}
+	inner.ints[name] = v
+	return inner, nil
+}
+
+// enter evaluates the arguments of a call into the frame of the function called,
+// every call entered must leave once its body is evaluated
+func (in *interpreter) enter(e *callExpr, vars frame) (*funcDecl, frame, error) {
+	f, ok := in.functions[e.name]
+	if !ok {
+		return nil, frame{}, in.errorf(e, "undefined function %v", e.name)
+	}
+	if len(f.params) != len(e.args) {
+		return nil, frame{}, in.errorf(e, "function %v expects %v arguments, got %v", e.name, len(f.params), len(e.args))
+	}
+	callee := frame{ints: make(map[string]int64, len(f.params)), aggregates: make(map[string]aggregate)}
+	for i, arg := range e.args {
+		name := f.params[i].name
+		if !f.params[i].exprType().isInt() {
+			v, err := in.evalAggregate(arg, vars)
+			if err != nil {
+				return nil, frame{}, err
+			}
+			callee.aggregates[name] = v
+			continue
+		}
+		v, err := in.eval(arg, vars)
+		if err != nil {
+			return nil, frame{}, err
+		}
+		callee.ints[name] = v
+	}
+
+	if in.depth > maxDepth {
+		return nil, frame{}, in.errorf(e, "stack overflow calling %v", e.name)
+	}
+	return f, callee, nil
+}
+
+func (in *interpreter) leave() {
+	in.depth--
+}
+
+func (in *interpreter) errorf(n node, format string, args ...any) error {
+	p := n.pos()
+	return fmt.Errorf("%w: %v:%v:%v: %v", errRuntime, p.file, p.line, p.col, fmt.Sprintf(format, args...))
+}
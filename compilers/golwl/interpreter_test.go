@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func Test_interpret(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    int64
+		wantErr string
+	}{
+		{
+			name:   "data/addition.lwl",
+			source: "main = 1 + 3 + 1\n",
+			want:   5,
+		},
+		{
+			name:   "data/function.lwl",
+			source: "f(x,y)=x+y\nmain = f(1,2)\n",
+			want:   3,
+		},
+		{
+			name:   "precedence and grouping",
+			source: "main = (1+2)*3-1+2*3\n",
+			want:   14,
+		},
+		{
+			name:   "results are not truncated to the exit code",
+			source: "f(x)=x*1000\nmain = f(7)-7007\n",
+			want:   -7,
+		},
+		{
+			name:   "signed division and modulo",
+			source: "f(a,b)=(a/b)*100+a%b\nmain = f(0-7,2)\n",
+			want:   -301,
+		},
+		{
+			name:   "parameters are scoped to each call",
+			source: "g(x)=x*2\nf(x,y)=g(y)+x\nmain = f(g(1),f(3,4))\n",
+			want:   24,
+		},
+		{
+			name:   "overflow wraps around",
+			source: "main = 9223372036854775807+1\n",
+			want:   math.MinInt64,
+		},
+		{
+			name:   "smallest integer divided by -1 is itself",
+			source: "f(x,y)=x/y\nmain = f(-9223372036854775808,-1)\n",
+			want:   math.MinInt64,
+		},
+		{
+			name:   "structs are passed and returned by value",
+			source: "struct p { x:u8, y }\nf(a:p, k):p = p{x: a.x + 1, y: a.y * k}\nmain = f(f(p{x: 255, y: 3}, 2), 5).y + f(p{x: 255, y: 0}, 1).x\n",
+			want:   30,
+		},
+		{
+			name:   "arrays are passed and returned by value",
+			source: "f(a:[3]u8, i):[3]u8 = [3]u8{a[i] + 1, a[1] * 2, len(a)}\nmain = f(f([3]u8{255, 4, 0}, 0), 2)[0] + f([3]u8{1, 2, 3}, 0)[1]\n",
+			want:   4 + 4,
+		},
+		{
+			name:   "comparisons of signed and unsigned integers",
+			source: "lt(x:i8, y:i8) = x < y\nltu(x:u64, y:u64) = x < y\nmain = lt(-1, 1) * 10 + ltu(u64(-1), 1) + (3 >= 3) * 100 + (3 != 3)\n",
+			want:   110,
+		},
+		{
+			name:   "conditionals only evaluate the branch taken",
+			source: "f(x) = x == 0 ? 1 : x * f(x - 1)\ng(a:[2]u8, i) = i < len(a) ? a[i] : 100 / i\nmain = f(5) + g([2]u8{7, 8}, 1) + g([2]u8{7, 8}, 4)\n",
+			want:   120 + 8 + 25,
+		},
+		{
+			name:   "conditionals of structs",
+			source: "struct p { x, y }\nf(c, a:p, b:p):p = c ? a : b\nmain = f(1, p{x: 1, y: 2}, p{x: 3, y: 4}).y * 10 + f(0, p{x: 1, y: 2}, p{x: 3, y: 4}).x\n",
+			want:   23,
+		},
+		{
+			name:   "functions calling each other before they are defined",
+			source: "main = even(7) * 10 + odd(7)\neven(n) = n == 0 ? 1 : odd(n - 1)\nodd(n) = n == 0 ? 0 : even(n - 1)\n",
+			want:   1,
+		},
+		{
+			name:   "lets are evaluated once and seen by their body alone",
+			source: "f(x) = (let y = x * x in y + y) + (let y = x in y)\ng(n) = let n = n + 1 in let m = n * 2 in n + m\nmain = f(3) + g(1) * 100\n",
+			want:   18 + 3 + 600,
+		},
+		{
+			name:   "lets of structs and arrays",
+			source: "struct p { x, y }\nf(a:p) = let b = a in let c = [2]i64{b.y, b.x} in c[0] * 10 + c[1]\nmain = f(p{x: 1, y: 2})\n",
+			want:   21,
+		},
+		{
+			name:    "division by zero",
+			source:  "f(x)=1/x\nmain = f(0)\n",
+			wantErr: "division by zero",
+		},
+		{
+			name:   "deep recursion",
+			source: "f(n) = n == 0 ? 0 : 1 + f(n - 1)\nmain = f(100000)\n",
+			want:   100000,
+		},
+		{
+			name:    "runaway recursion",
+			source:  "f(x)=f(x+1)\nmain = f(0)\n",
+			wantErr: "stack overflow calling f",
+		},
+		{
+			name:    "index out of range",
+			source:  "f(a:[2]u8, i)=a[i]\nmain = f([2]u8{1, 2}, 2)\n",
+			wantErr: "index 2 of [2]u8",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			decls, err := parse(tokenizeSource(t, tc.source))
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			checkTypes(t, decls)
+			got, err := interpret(decls)
+			if tc.wantErr != "" {
+				if !errors.Is(err, errRuntime) || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("interpret() error = %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("interpret() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("interpret() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func Test_exitCode(t *testing.T) {
+	for v, want := range map[int64]int{0: 0, 42: 42, 255: 255, 256: 0, 300: 44, -1: 255, -2: 254} {
+		if got := exitCode(v); got != want {
+			t.Errorf("exitCode(%v) = %v, want %v", v, got, want)
+		}
+	}
+}
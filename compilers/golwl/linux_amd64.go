@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
@@ -13,82 +14,249 @@ func toAs(i instruction) (string, error) {
 	switch i.opcode {
 	case syscallop:
 		return "    SYSCALL", nil
-	case addop:
+	case addop, subop, mulop, cmpop:
 		if len(i.args) != 2 {
-			return "", fmt.Errorf("invalid number of args for ADD, expected 2, got: %v", i.args)
+			return "", fmt.Errorf("invalid number of args for %v, expected 2, got: %v", i.opcode, i.args)
 		}
 		args1 := i.args[0]
 		args2 := i.args[1]
-		// TODO: handle add from const, memory, etc.
-		if !isRegister(args1) || !isRegister(args2) {
-			return "", fmt.Errorf("invalid args for ADD, expected registers, got: %v", i.args)
+		// TODO: handle add from memory, etc.
+		if (!isRegister(args1) && !isConstant(args1)) || !isRegister(args2) {
+			return "", fmt.Errorf("invalid args for %v, expected registers or constant, got: %v", i.opcode, i.args)
 		}
-		args1 = "%" + args1
-		args2 = "%" + args2
+		args1 = asOperand(args1)
+		args2 = asOperand(args2)
 
-		return fmt.Sprintf("    ADD %s, %s", args1, args2), nil
-	case movop:
-		// TODO: handle memory, etc.
+		mnemonic := string(i.opcode)
+		if i.opcode == mulop {
+			mnemonic = "IMUL" // signed, we only have integers
+		}
+		return fmt.Sprintf("    %s %s, %s", mnemonic, args1, args2), nil
+	case divop, modop:
+		// IDIV divides RDX:RAX leaving the quotient in RAX and the remainder in RDX
+		// so the only possible destination is RAX, and RDX is lost on the way
 		if len(i.args) != 2 {
-			return "", fmt.Errorf("invalid number of args for MOV, expected 2, got: %v", i.args)
+			return "", fmt.Errorf("invalid number of args for %v, expected 2, got: %v", i.opcode, i.args)
 		}
-		args1 := i.args[0]
-		switch {
-		case isConstant(args1):
-			args1 = "$" + args1
-		case isRegister(args1):
-			args1 = "%" + args1
+		if !isRegister(i.args[0]) || i.args[0] == rax || i.args[0] == rdx || i.args[1] != rax {
+			return "", fmt.Errorf("invalid args for %v, expected a register other than RAX or RDX into RAX, got: %v", i.opcode, i.args)
 		}
-
-		args2 := i.args[1]
-		switch {
-		case isConstant(args2):
-			args2 = "$" + args2
-		case isRegister(args2):
-			args2 = "%" + args2
+		// IDIV traps when the quotient overflows, which only happens dividing the smallest integer by -1,
+		// so dividing by -1 is done without it: x / -1 = -x (wrapping around) and x % -1 = 0
+		byMinusOne := "    NEG %RAX"
+		if i.opcode == modop {
+			byMinusOne = "    MOV $0, %RAX"
+		}
+		asCode := fmt.Sprintf("    CMP $-1, %[1]s\n    JNE 1f\n%[2]s\n    JMP 2f\n1:\n    CQO\n    IDIV %[1]s", asOperand(i.args[0]), byMinusOne)
+		if i.opcode == modop {
+			asCode += "\n    MOV %RDX, %RAX"
+		}
+		return asCode + "\n2:", nil
+	case udivop, umodop:
+		// DIV divides RDX:RAX as unsigned, so RDX is cleared instead of sign extended from RAX
+		if len(i.args) != 2 {
+			return "", fmt.Errorf("invalid number of args for %v, expected 2, got: %v", i.opcode, i.args)
+		}
+		if !isRegister(i.args[0]) || i.args[0] == rax || i.args[0] == rdx || i.args[1] != rax {
+			return "", fmt.Errorf("invalid args for %v, expected a register other than RAX or RDX into RAX, got: %v", i.opcode, i.args)
 		}
+		asCode := fmt.Sprintf("    XOR %%EDX, %%EDX\n    DIV %s", asOperand(i.args[0]))
+		if i.opcode == umodop {
+			asCode += "\n    MOV %RDX, %RAX"
+		}
+		return asCode, nil
+	case boundsop:
+		if len(i.args) != 2 {
+			return "", fmt.Errorf("invalid number of args for %v, expected 2, got: %v", i.opcode, i.args)
+		}
+		if !isConstant(i.args[0]) || !isRegister(i.args[1]) {
+			return "", fmt.Errorf("invalid args for %v, expected a constant length and a register, got: %v", i.opcode, i.args)
+		}
+		// comparing as unsigned, negative indices are out of range as well
+		return fmt.Sprintf("    CMP %s, %s\n    JB 1f\n    MOV $%d, %%RDI\n    MOV $60, %%RAX\n    SYSCALL\n1:",
+			asOperand(i.args[0]), asOperand(i.args[1]), outOfRangeExitCode), nil
+	case movsxop, movzxop:
+		if len(i.args) != 2 {
+			return "", fmt.Errorf("invalid number of args for %v, expected 2, got: %v", i.opcode, i.args)
+		}
+		suffix := map[int]string{8: "B", 16: "W", 32: "L"}
+		mnemonic := map[opset]string{movsxop: "MOVS", movzxop: "MOVZ"}[i.opcode]
+		if bits, mem, ok := parseSizedMemory(i.args[0]); ok {
+			if !isRegister(i.args[1]) {
+				return "", fmt.Errorf("invalid args for %v, expected memory into a register, got: %v", i.opcode, i.args)
+			}
+			if i.opcode == movzxop && bits == 32 {
+				return fmt.Sprintf("    MOV %s, %%%s", asOperand(mem), register(i.args[1], 32)), nil
+			}
+			return fmt.Sprintf("    %s%sQ %s, %%%s", mnemonic, suffix[bits], asOperand(mem), i.args[1]), nil
+		}
+		r, bits, ok := parseSubRegister(i.args[0])
+		if !ok || r != i.args[1] {
+			return "", fmt.Errorf("invalid args for %v, expected the lower bits of a register into itself, got: %v", i.opcode, i.args)
+		}
+		if i.opcode == movzxop && bits == 32 {
+			// writing the lower 32 bits of a register already clears the upper ones
+			return fmt.Sprintf("    MOV %%%s, %%%s", i.args[0], i.args[0]), nil
+		}
+		return fmt.Sprintf("    %s%sQ %%%s, %%%s", mnemonic, suffix[bits], i.args[0], i.args[1]), nil
+	case negop:
+		if len(i.args) != 1 {
+			return "", fmt.Errorf("invalid number of args for %v, expected 1, got: %v", i.opcode, i.args)
+		}
+		if !isRegister(i.args[0]) {
+			return "", fmt.Errorf("invalid args for %v, expected register, got: %v", i.opcode, i.args)
+		}
+		return fmt.Sprintf("    NEG %s", asOperand(i.args[0])), nil
+	case pushop, popop:
+		if len(i.args) != 1 {
+			return "", fmt.Errorf("invalid number of args for %v, expected 1, got: %v", i.opcode, i.args)
+		}
+		if !isRegister(i.args[0]) {
+			return "", fmt.Errorf("invalid args for %v, expected register, got: %v", i.opcode, i.args)
+		}
+		return fmt.Sprintf("    %s %%%s", i.opcode, i.args[0]), nil
+	case movop:
+		// TODO: handle memory to memory, etc.
+		if len(i.args) != 2 {
+			return "", fmt.Errorf("invalid number of args for MOV, expected 2, got: %v", i.args)
+		}
+		args1 := asOperand(i.args[0])
+		args2 := asOperand(i.args[1])
 
 		// TODO: make this assumption move obvious, but we do AT&T syntax src, dst
 		return fmt.Sprintf("    MOV %s, %s", args1, args2), nil
+	case leaop:
+		if len(i.args) != 2 {
+			return "", fmt.Errorf("invalid number of args for %v, expected 2, got: %v", i.opcode, i.args)
+		}
+		if !isMemory(i.args[0]) || !isRegister(i.args[1]) {
+			return "", fmt.Errorf("invalid args for %v, expected memory into a register, got: %v", i.opcode, i.args)
+		}
+		return fmt.Sprintf("    LEA %s, %s", asOperand(i.args[0]), asOperand(i.args[1])), nil
+	case callop:
+		if len(i.args) != 1 {
+			return "", fmt.Errorf("invalid number of args for CALL, expected 1, got: %v", i.args)
+		}
+		return fmt.Sprintf("    CALL %s", i.args[0]), nil
+	case jmpop, jeop:
+		if len(i.args) != 1 {
+			return "", fmt.Errorf("invalid number of args for %v, expected 1, got: %v", i.opcode, i.args)
+		}
+		// GAS would pick the shortest jump that reaches the label, the encoder always takes a rel32 as CALL does
+		return fmt.Sprintf("    {disp32} %s %s", i.opcode, i.args[0]), nil
+	case labelop:
+		if len(i.args) != 1 {
+			return "", fmt.Errorf("invalid number of args for %v, expected 1, got: %v", i.opcode, i.args)
+		}
+		return fmt.Sprintf("%s:", i.args[0]), nil
+	case seteop, setneop, setlop, setleop, setgop, setgeop, setbop, setbeop, setaop, setaeop:
+		if len(i.args) != 1 {
+			return "", fmt.Errorf("invalid number of args for %v, expected 1, got: %v", i.opcode, i.args)
+		}
+		if _, bits, ok := parseSubRegister(i.args[0]); !ok || bits != 8 {
+			return "", fmt.Errorf("invalid args for %v, expected the lower 8 bits of a register, got: %v", i.opcode, i.args)
+		}
+		return fmt.Sprintf("    %s %s", i.opcode, asOperand(i.args[0])), nil
+	case retop:
+		return "    RET", nil
 	case funcstart:
 		if len(i.args) != 1 {
 			return "", fmt.Errorf("invalid number of args for %v, expected 1, got: %v", funcstart, i.args)
 		}
 		name := i.args[0]
 		return fmt.Sprintf("%s:", name), nil
+	case globalop:
+		if len(i.args) != 1 {
+			return "", fmt.Errorf("invalid number of args for %v, expected 1, got: %v", globalop, i.args)
+		}
+		name := i.args[0]
+		return fmt.Sprintf(".global %s\n.type %s, @function\n%s:", name, name, name), nil
 	default:
 	}
 	return "", errors.New("unhandled op") // TODO: actually handle other stuff and output meaningful errros
 }
 
-func magic(instructions []instruction, outfileName string) error {
-	// transform instructions into GAS assembly
-	// run it through the assembler then linker
-	// write the output to the file
+// asOperand writes a pseudo-assembly operand in AT&T syntax
+func asOperand(s string) string {
+	if base, offset, ok := parseMemory(s); ok {
+		return fmt.Sprintf("%d(%%%s)", offset, base)
+	}
+	if _, _, ok := parseSubRegister(s); ok {
+		return "%" + s
+	}
+	switch {
+	case isConstant(s):
+		return "$" + s
+	case isRegister(s):
+		return "%" + s
+	}
+	return s
+}
+
+// toGAS transforms the instructions into GAS assembly
+func toGAS(instructions []instruction) (string, error) {
 	asCode := strings.Builder{}
 	asCode.WriteString(".section .text\n")
-	asCode.WriteString(".global _start\n")
 	for _, inst := range instructions {
+		if inst.opcode == funcstart && len(inst.args) == 1 && inst.args[0] == "_start" {
+			asCode.WriteString(".global _start\n")
+		}
 		asLine, err := toAs(inst)
 		if err != nil {
-			return err
+			return "", err
 		}
 		asCode.WriteString(asLine + "\n")
 	}
-	err := os.WriteFile(outfileName+".tmp.S", []byte(asCode.String()), 0o600)
+	asCode.WriteString(".section .note.GNU-stack,\"\",@progbits\n") // we do not need an executable stack
+	return asCode.String(), nil
+}
+
+// assemble runs the GAS code through the assembler into the object file
+func assemble(asCode string, objFileName string) error {
+	// TODO: handle different platforms and check pre-conditions (like having as or ld installed)
+	tmpDir, err := os.MkdirTemp("", "golwl")
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(tmpDir)
 
-	// TODO: handle different platforms and check pre-conditions (like having as or ld installed)
-
-	o, err := exec.Command("as", "-o", outfileName+".tmp.o", outfileName+".tmp.S").CombinedOutput()
+	asFileName := filepath.Join(tmpDir, "output.S")
+	if err := os.WriteFile(asFileName, []byte(asCode), 0o600); err != nil {
+		return err
+	}
+	o, err := exec.Command("as", "-o", objFileName, asFileName).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("as failed: %v: %v", err, string(o))
 	}
-	o, err = exec.Command("ld", "-o", outfileName, outfileName+".tmp.o").CombinedOutput()
+	return nil
+}
+
+// link turns the object file into an executable
+func link(objFileName string, outfileName string) error {
+	o, err := exec.Command("ld", "-o", outfileName, objFileName).CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("as failed: %v: %v", err, string(o))
+		return fmt.Errorf("ld failed: %v: %v", err, string(o))
 	}
 	return nil
 }
+
+func magic(instructions []instruction, outfileName string) error {
+	// transform instructions into GAS assembly
+	// run it through the assembler then linker
+	// write the output to the file, no intermediate files are left behind
+	asCode, err := toGAS(instructions)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "golwl")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	objFileName := filepath.Join(tmpDir, "output.o")
+	if err := assemble(asCode, objFileName); err != nil {
+		return err
+	}
+	return link(objFileName, outfileName)
+}
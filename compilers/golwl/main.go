@@ -1,8 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 )
 
 var (
@@ -11,14 +20,71 @@ var (
 	version string
 )
 
+// the stages the compiler can stop at, each one emitting its own output
+const (
+	emitTokens = "tokens" // tokens, one per line
+	emitAST    = "ast"    // abstract syntax tree, one function per line
+	emitIR     = "ir"     // pseudo-assembly instructions
+	emitAsm    = "asm"    // GAS assembly
+	emitHeader = "header" // C header with the prototypes of the functions, for -lib
+	emitObj    = "obj"    // relocatable object file, encoded natively unless -gas is given
+	emitExe    = "exe"    // executable, encoded natively unless -gas is given
+)
+
+var emitStages = []string{emitTokens, emitAST, emitIR, emitAsm, emitHeader, emitObj, emitExe}
+
 func main() {
 	// TODO: logger with levels
 	log.Printf("version: %v\n", version)
 
+	// golwl run [flags] files... interprets the program instead of compiling it
+	args := os.Args[1:]
+	runMode := len(args) > 0 && args[0] == "run"
+	if runMode {
+		args = args[1:]
+	}
+
 	// parse input
-	var output string
-	flag.StringVar(&output, "o", "output", "output file name")
-	flag.Parse()
+	var output, diagnosticsFormat, emit, entry string
+	var useGAS, lib bool
+	var selected plugins
+	flag.StringVar(&output, "o", "output", "output file name, - writes text outputs to stdout")
+	flag.StringVar(&diagnosticsFormat, "diagnostics-format", diagnosticsText,
+		"how to report errors and warnings: text (stderr), json (one object per line on stdout) or sarif (SARIF 2.1.0 log on stdout)")
+	flag.StringVar(&emit, "emit", emitExe, "stage to stop at and emit: tokens, ast, ir, asm, header, obj or exe")
+	flag.BoolVar(&useGAS, "gas", false, "build objects and executables with the GNU assembler and linker instead of the native encoder")
+	flag.StringVar(&entry, "entry", defaultEntry, "function the program starts at and exits with the result of: main or any function without parameters")
+	flag.BoolVar(&lib, "lib", false, "build a library to link against C: no main, every function of the given files exported (use with -emit=obj, asm or header)")
+	flag.Func("plugin", fmt.Sprintf("plugin to run, as name, name:option or the path of an executable, repeated to run several in order: %v", slices.Sorted(maps.Keys(builtinPlugins))),
+		func(spec string) error {
+			p, err := loadPlugin(spec)
+			if err != nil {
+				return err
+			}
+			selected = append(selected, p)
+			return nil
+		})
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %v [run] [flags] files...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	_ = flag.CommandLine.Parse(args) // exits on error
+	if !slices.Contains(diagnosticsFormats, diagnosticsFormat) {
+		log.Fatalf("unknown diagnostics format %q, expected one of: %v", diagnosticsFormat, diagnosticsFormats)
+	}
+	if !slices.Contains(emitStages, emit) {
+		log.Fatalf("unknown emit stage %q, expected one of: %v", emit, emitStages)
+	}
+	if lib && emit == emitExe {
+		log.Fatalf("a library can not be an executable, use -emit=obj")
+	}
+	if lib && entry != defaultEntry {
+		log.Fatalf("a library has no entry point, -entry can not be used with -lib")
+	}
+	diagnosticsOutput := os.Stdout
+	if diagnosticsFormat == diagnosticsText {
+		diagnosticsOutput = os.Stderr
+	}
 
 	files := flag.Args()
 	if len(files) == 0 {
@@ -30,21 +96,180 @@ func main() {
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	if functions, err = selected.afterTokenize(functions); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if emit == emitTokens {
+		if err := reportDiagnostics(diagnosticsOutput, diagnosticsFormat, collectDiagnostics(functions)); err != nil {
+			log.Fatalf("%v", err)
+		}
+		writeOutput(output, emit, selected, func(w io.Writer) error { return dumpTokens(w, functions) })
+		return
+	}
 
-	// handle syntax
-	// TODO: gracefully handle syntax and semantic errors since they accumulate per function / line
-	// TODO: make it more obvious we expect functions to be defined in order and file name will matter for that order
-	if err := parse(functions); err != nil {
+	// handle syntax, errors accumulate per function / line and are all reported together
+	decls, diags, err := parseProgram(functions, files, entry, lib)
+	if err == nil || lib && errors.Is(err, errNoMain) {
+		// plugins only rewrite, and types are only checked on, a syntactically valid program
+		if decls, err = selected.afterParse(decls); err != nil {
+			log.Fatalf("%v", err)
+		}
+		typeDiags, typeErr := check(decls)
+		diags = append(diags, typeDiags...)
+		if typeErr != nil {
+			err = typeErr
+		}
+	}
+	if err := reportDiagnostics(diagnosticsOutput, diagnosticsFormat, diags); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err != nil && !(lib && errors.Is(err, errNoMain)) {
 		log.Fatalf("%v", err)
 	}
+	if runMode {
+		result, err := interpret(decls)
+		if errors.Is(err, errOutOfRange) {
+			// just like the compiled binary would
+			log.Printf("%v", err)
+			os.Exit(outOfRangeExitCode)
+		}
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Println(result)
+		os.Exit(exitCode(result))
+	}
+	switch emit {
+	case emitAST:
+		writeOutput(output, emit, selected, func(w io.Writer) error { return dumpAST(w, decls) })
+		return
+	case emitHeader:
+		writeOutput(output, emit, selected, func(w io.Writer) error {
+			return writeCHeader(w, decls, strings.TrimSuffix(filepath.Base(output), filepath.Ext(output)))
+		})
+		return
+	}
 
 	// generate pseudo-assembly code
-	// TODO: add optimized plugins for different architectures
-	instructions := passemble(functions)
+	lower := passemble
+	if lib {
+		lower = passembleLibrary
+	}
+	instructions, err := lower(decls)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if instructions, err = selected.afterPassemble(instructions); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if emit == emitIR {
+		writeOutput(output, emit, selected, func(w io.Writer) error { return dumpIR(w, instructions) })
+		return
+	}
 
 	// TODO: implement checking the architecture of the host machine and restrict to amd64 linux only for now
-	err = magic(instructions, output)
+	switch emit {
+	case emitAsm:
+		asCode, err := toGAS(instructions)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		writeOutput(output, emit, selected, func(w io.Writer) error {
+			_, err := io.WriteString(w, asCode)
+			return err
+		})
+	case emitObj:
+		build := func(output string) error { return writeObject(instructions, output) }
+		if useGAS {
+			build = func(output string) error {
+				asCode, err := toGAS(instructions)
+				if err != nil {
+					return err
+				}
+				return assemble(asCode, output)
+			}
+		}
+		if err := writeFile(output, emit, selected, build); err != nil {
+			log.Fatalf("%v", err)
+		}
+	default:
+		build := writeExecutable
+		if useGAS {
+			build = magic
+		}
+		err := writeFile(output, emit, selected, func(output string) error { return build(instructions, output) })
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+}
+
+// parseProgram parses the functions of the files into the declarations of a program starting at entry,
+// or of a library without one, along with the diagnostics of the program as a whole
+func parseProgram(functions []function, files []string, entry string, lib bool) ([]*funcDecl, []diagnostic, error) {
+	decls, err := parse(functions)
+	diags := collectDiagnostics(functions)
+	if entry != defaultEntry && (err == nil || errors.Is(err, errNoMain)) {
+		var entryDiags []diagnostic
+		decls, entryDiags, err = withEntry(decls, entry, files[0])
+		diags = append(diags, entryDiags...)
+	}
+	if errors.Is(err, errNoMain) && !lib {
+		diags = append(diags, noMain(files[0]))
+	}
+	return decls, diags, err
+}
+
+// writeOutput writes a text output into the output file, or stdout if it is "-", once the plugins are done with it
+func writeOutput(output, stage string, ps plugins, write func(w io.Writer) error) {
+	b := bytes.Buffer{}
+	if err := write(&b); err != nil {
+		log.Fatalf("%v", err)
+	}
+	contents, err := ps.beforeEmit(stage, b.Bytes())
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	w := os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := w.Write(contents); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// writeFile builds a binary output into the output file, going through a temporary one
+// when there are plugins to pass it through, keeping its permissions
+func writeFile(output, stage string, ps plugins, build func(output string) error) error {
+	if len(ps) == 0 {
+		return build(output)
+	}
+	tmpDir, err := os.MkdirTemp("", "golwl")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	built := filepath.Join(tmpDir, "output")
+	if err := build(built); err != nil {
+		return err
+	}
+	info, err := os.Stat(built)
+	if err != nil {
+		return err
+	}
+	contents, err := os.ReadFile(built)
+	if err != nil {
+		return err
+	}
+	if contents, err = ps.beforeEmit(stage, contents); err != nil {
+		return err
+	}
+	return os.WriteFile(output, contents, info.Mode().Perm())
 }
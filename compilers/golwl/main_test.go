@@ -1 +1,574 @@
 package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in data/golden")
+
+// compileAndRun compiles the source into a binary, through the plugins given, and returns its exit code,
+// the interpreter is the oracle it must agree with, and when as and ld are around
+// the GAS backend is cross-checked against the native one too
+func compileAndRun(t *testing.T, source string, ps ...plugin) int {
+	t.Helper()
+	return compileAndRunFunctions(t, tokenizeSource(t, source), ps...)
+}
+
+// compileAndRunFunctions is compileAndRun for sources already tokenized, such as the files of a few modules
+func compileAndRunFunctions(t *testing.T, functions []function, ps ...plugin) int {
+	t.Helper()
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("end to end tests only run on linux/amd64")
+	}
+
+	functions, err := plugins(ps).afterTokenize(functions)
+	if err != nil {
+		t.Fatalf("afterTokenize() error = %v", err)
+	}
+	decls, err := parse(functions)
+	if err != nil {
+		t.Fatalf("parse() error = %v: %v", err, collectDiagnostics(functions))
+	}
+	if decls, err = plugins(ps).afterParse(decls); err != nil {
+		t.Fatalf("afterParse() error = %v", err)
+	}
+	checkTypes(t, decls)
+	instructions, err := passemble(decls)
+	if err != nil {
+		t.Fatalf("passemble() error = %v", err)
+	}
+	if instructions, err = plugins(ps).afterPassemble(instructions); err != nil {
+		t.Fatalf("afterPassemble() error = %v", err)
+	}
+	output := filepath.Join(t.TempDir(), "output")
+	if err := writeExecutable(instructions, output); err != nil {
+		t.Fatalf("writeExecutable() error = %v", err)
+	}
+	code := run(t, output)
+
+	result, err := interpret(decls)
+	switch {
+	case errors.Is(err, errOutOfRange):
+		if code != outOfRangeExitCode {
+			t.Errorf("exit code %v, interpreted %v (exit code %v)", code, err, outOfRangeExitCode)
+		}
+	case err != nil:
+		t.Fatalf("interpret() error = %v", err)
+	case exitCode(result) != code:
+		t.Errorf("exit code %v, interpreted result %v (exit code %v)", code, result, exitCode(result))
+	}
+
+	if !hasTools("as", "ld") {
+		return code
+	}
+	if err := magic(instructions, output+".gas"); err != nil {
+		t.Fatalf("magic() error = %v", err)
+	}
+	if gasCode := run(t, output+".gas"); gasCode != code {
+		t.Errorf("native exit code %v, GAS exit code %v", code, gasCode)
+	}
+	return code
+}
+
+func hasTools(tools ...string) bool {
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// run executes the binary and returns its exit code
+func run(t *testing.T, binary string) int {
+	t.Helper()
+	err := exec.Command(binary).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("run %v: %v", binary, err)
+	}
+	return 0
+}
+
+func Test_endToEnd(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   int
+	}{
+		{
+			name:   "addition",
+			source: "main = 1+3+1\n",
+			want:   5,
+		},
+		{
+			name:   "multiplication before addition",
+			source: "main = 1+2*3\n",
+			want:   7,
+		},
+		{
+			name:   "parenthesis before multiplication",
+			source: "main = (1+2)*3\n",
+			want:   9,
+		},
+		{
+			name:   "right side needs the stack",
+			source: "main = 2*(3+4*(1+1))+(5)\n",
+			want:   27,
+		},
+		{
+			name:   "function call as data/function.lwl",
+			source: "f(x,y)=x+y\nmain = f(1,2)\n",
+			want:   3,
+		},
+		{
+			name:   "nested calls keep the caller parameters",
+			source: "f(x)=x*2\ng(x,y)=f(y)+x*f(x+y)\nmain = g(f(1),3)\n",
+			want:   6 + 2*10,
+		},
+		{
+			name:   "arguments on the stack",
+			source: "f(a,b,c,d,e,g,h,i)=a+2*b+3*c+4*d+5*e+6*g+7*h+8*i\nmain = f(1,1,1,1,1,1,2,3)\n",
+			want:   1 + 2 + 3 + 4 + 5 + 6 + 14 + 24,
+		},
+		{
+			name:   "stack arguments are computed calls",
+			source: "f(a,b,c,d,e,g,h)=h+a\ng(x)=x*x\nmain = f(g(1),2,3,4,5,6,g(f(1,2,3,4,5,6,8)))\n",
+			want:   82,
+		},
+		{
+			name:   "subtraction is left associative",
+			source: "main = 10-3-2\n",
+			want:   5,
+		},
+		{
+			name:   "negative results wrap the exit code",
+			source: "main = 3-5\n",
+			want:   254,
+		},
+		{
+			name:   "division and modulo",
+			source: "main = 100/7*10+100%7\n",
+			want:   142,
+		},
+		{
+			name:   "signed division truncates towards zero",
+			source: "main = (0-7)/2+10\n",
+			want:   7,
+		},
+		{
+			name:   "signed modulo takes the sign of the dividend",
+			source: "main = (0-7)%3+10\n",
+			want:   9,
+		},
+		{
+			name:   "division keeps the third parameter in RDX",
+			source: "f(a,b,c)=a/b+c%b+c\nmain = f(20,3,5)\n",
+			want:   6 + 2 + 5,
+		},
+		{
+			name:   "unary minus",
+			source: "f(x)=-x\nmain = -f(-3)-(-2)*-4\n",
+			want:   256 - 11,
+		},
+		{
+			name:   "overflow wraps around",
+			source: "main = (9223372036854775807+1)/4611686018427387904\n",
+			want:   256 - 2,
+		},
+		{
+			name:   "smallest integer divided by -1 is itself",
+			source: "f(x,y)=x/y\nmain = f(-9223372036854775808,-1)/4611686018427387904\n",
+			want:   256 - 2,
+		},
+		{
+			name:   "smallest integer modulo -1 is zero",
+			source: "f(x,y)=x%y+7\nmain = f(-9223372036854775808,-1)\n",
+			want:   7,
+		},
+		{
+			name:   "division by -1",
+			source: "f(x,y)=x/y+x%y\nmain = f(5,-1)+10\n",
+			want:   5,
+		},
+		{
+			name:   "u8 arithmetic wraps at 8 bits",
+			source: "f(x:u8,y:u8):u8=x*y\nmain = f(20,13)/2\n",
+			want:   260 % 256 / 2,
+		},
+		{
+			name:   "i8 arithmetic wraps to negative",
+			source: "f(x:i8):i8=x+1\nmain = f(127)/2+100\n",
+			want:   -128/2 + 100,
+		},
+		{
+			name:   "i16 and u16",
+			source: "f(x:i16):i16=x*3\ng(x:u16):u16=x*3\nmain = (i32(g(20000))-f(20000))/1000\n",
+			want:   (60000 - (60000 - 65536)) / 1000,
+		},
+		{
+			name:   "i32 overflow",
+			source: "f(x:i32):i32=x*65536\nmain = f(32768)/65536/256\n",
+			want:   256 - 128,
+		},
+		{
+			name:   "u32 overflow",
+			source: "f(x:u32):u32=x*65536\nmain = f(65537)/65536\n",
+			want:   1,
+		},
+		{
+			name:   "unsigned division",
+			source: "f(x:u64):u64=x/2\nmain = i64(f(u64(-2))/4611686018427387904)\n",
+			want:   1,
+		},
+		{
+			name:   "unsigned modulo",
+			source: "f(x:u8,y:u8):u8=x%y\nmain = f(u8(-1),10)\n",
+			want:   5,
+		},
+		{
+			name:   "casts truncate and extend",
+			source: "main = i64(i8(200)/2)+u8(-1)/5+i16(u16(-1))\n",
+			want:   -28 + 51 - 1,
+		},
+		{
+			name:   "smallest i8 divided by -1 is itself",
+			source: "f(x:i8,y:i8):i8=x/y\nmain = f(-128,-1)/2+100\n",
+			want:   -64 + 100,
+		},
+		{
+			name:   "narrow arguments on the stack",
+			source: "f(a:u8,b,c,d,e,g,h:i8,i:i16)=h*i+a\nmain = f(255,2,3,4,5,6,-3,70)\n",
+			want:   255 - 210,
+		},
+		{
+			name:   "struct literals and fields",
+			source: "struct point { x:i32, y:i32 }\nmain = point{y: 2, x: 40}.x + point{x: 1, y: -2}.y\n",
+			want:   38,
+		},
+		{
+			name:   "structs in one register",
+			source: "struct point { x:i32, y:i32 }\nadd(p:point, q:point):point = point{x: p.x + q.x, y: p.y + q.y}\nmain = add(point{x: 1, y: 2}, point{x: 20, y: 10}).y\n",
+			want:   12,
+		},
+		{
+			name:   "structs in two registers and the narrow fields extended",
+			source: "struct s { a:i8, b, c:u16 }\nf(x:i8, c:u16):s = s{a: x, b: 1000, c: c}\ng(v:s, k) = v.a * k + v.b + v.c\nmain = g(f(-3, 65535), 5)\n",
+			want:   (-15 + 1000 + 65535) % 256,
+		},
+		{
+			name:   "structs too big for registers go in memory",
+			source: "struct big { a, b:u8, c:i16, d }\nmk(a, b:u8, c:i16, d):big = big{d: d, a: a, b: b, c: c}\nsum(g:big) = g.a + g.b + g.c + g.d\nmain = sum(mk(1, 200, -3, 4))\n",
+			want:   202,
+		},
+		{
+			name: "structs on the stack once the registers run out",
+			source: "struct pair { lo:u8, hi }\nstruct point { x:i32, y:i32 }\n" +
+				"f(a, b, c, d, p:point, e:pair, g, h:pair) = a + b + c + d + p.x * p.y + e.lo - e.hi + g + h.lo * h.hi\n" +
+				"main = f(1, 2, 3, 4, point{x: 2, y: 3}, pair{lo: 5, hi: 6}, 7, pair{lo: 8, hi: 9})\n",
+			want: 1 + 2 + 3 + 4 + 6 + 5 - 6 + 7 + 72,
+		},
+		{
+			name:   "struct parameters passed along",
+			source: "struct v { x, y, z }\nid(a:v):v = a\ndot(a:v, k) = id(id(a)).x * k + a.z\nmain = dot(v{x: 3, y: 0, z: 7}, 11)\n",
+			want:   40,
+		},
+		{
+			name:   "array literals and indices",
+			source: "f(a:[4]i16, i:u8) = a[i] * a[3]\nmain = f([4]i16{-1, 300, 5, 2}, 1) + [3]u8{7, 8, 9}[2] + len([3]u8{1, 2, 3})\n",
+			want:   (600 + 9 + 3) % 256,
+		},
+		{
+			name: "arrays passed and returned in registers and memory",
+			source: "mk(x:u32):[3]u32 = [3]u32{x, x + 1, x + 2}\nbig(x):[5]i64 = [5]i64{x, x, x, x, -x}\n" +
+				"sum(a:[3]u32, b:[5]i64, i) = a[i] + a[2] + b[i+2] + b[4]\nmain = sum(mk(10), big(100), 1)\n",
+			want: 11 + 12 + 100 - 100,
+		},
+		{
+			name:   "indices out of range exit right away",
+			source: "at(a:[3]u8, i) = a[i]\nmain = at([3]u8{1, 2, 3}, 2) + at([3]u8{1, 2, 3}, 3)\n",
+			want:   outOfRangeExitCode,
+		},
+		{
+			name:   "negative indices are out of range",
+			source: "at(a:[3]u8, i:i8) = a[i * 2]\nmain = at([3]u8{1, 2, 3}, -1)\n",
+			want:   outOfRangeExitCode,
+		},
+		{
+			name:   "signed comparisons",
+			source: "f(x:i16, y:i16) = (x < y) + (x <= y) * 2 + (x > y) * 4 + (x >= y) * 8 + (x == y) * 16 + (x != y) * 32\nmain = f(-5, 3) + f(3, 3) * 64\n",
+			want:   (1 + 2 + 32 + (2+8+16)*64) % 256,
+		},
+		{
+			name:   "unsigned comparisons",
+			source: "f(x:u64, y:u64):u8 = (x < y) + (x <= y) * 2 + (x > y) * 4 + (x >= y) * 8\nmain = f(u64(-1), 1) + f(1, u64(-1)) * 16\n",
+			want:   4 + 8 + (1+2)*16,
+		},
+		{
+			name:   "recursion ends with a conditional",
+			source: "fib(n:u8):u32 = n < 2 ? n : fib(n - 1) + fib(n - 2)\nmain = fib(13) % 256\n",
+			want:   233,
+		},
+		{
+			name:   "recursion deeper than the interpreter used to allow",
+			source: "f(n) = n == 0 ? 0 : 1 + f(n - 1)\nmain = f(100000)\n",
+			want:   100000 % 256,
+		},
+		{
+			name:   "nested conditionals and comparisons as conditions",
+			source: "sign(x) = x < 0 ? -1 : x == 0 ? 0 : 1\nmain = sign(-7) + sign(0) * 10 + sign(99) * 100 + (2 > 1 ? 3 : 4 ? 5 : 6)\n",
+			want:   (-1 + 100 + 3) % 256,
+		},
+		{
+			name:   "branches not taken are never run",
+			source: "at(a:[2]u8, i) = i < 2 ? a[i] : 0\ndiv(x, y) = y != 0 ? x / y : 0\nmain = at([2]u8{5, 6}, 7) + at([2]u8{5, 6}, 1) + div(7, 0) + div(8, 2)\n",
+			want:   6 + 4,
+		},
+		{
+			name:   "conditionals of structs and arrays",
+			source: "struct p { x:u8, y }\nf(c, a:p, b:p):p = c ? a : b\ng(c):[5]i16 = c ? [5]i16{1, 2, 3, 4, 5} : [5]i16{6, 7, 8, 9, 10}\nmain = f(1, p{x: 1, y: 2}, p{x: 3, y: 4}).y + f(0, p{x: 1, y: 2}, p{x: 3, y: 4}).x * 10 + g(0)[4] * 100\n",
+			want:   (2 + 30 + 1000) % 256,
+		},
+		{
+			name:   "functions used before they are defined",
+			source: "main = twice(inc(20))\ntwice(x) = x * 2\ninc(x) = x + 1\n",
+			want:   42,
+		},
+		{
+			name:   "functions calling each other",
+			source: "even(n:u16):u8 = n == 0 ? 1 : odd(n - 1)\nodd(n:u16):u8 = n == 0 ? 0 : even(n - 1)\nmain = even(1000) * 10 + odd(777)\n",
+			want:   11,
+		},
+		{
+			name:   "lets kept across calls and shadowed",
+			source: "sq(x:u16):u16 = x * x\nf(x:u8):u16 = let y:u16 = sq(x) in let x = sq(y) in x + y\nmain = f(3) + let z = f(2) in z / 2\n",
+			want:   81 + 9 + (16+4)/2,
+		},
+		{
+			name:   "comments and functions continued over several lines",
+			source: "# the sum of the squares\nsq(x) = x * x // one square\nf(x,\n  y) =\n  sq(x) + /* and */\n  sq(y)\nmain = f(\n  3,\n  4\n)\n",
+			want:   25,
+		},
+		{
+			name:   "lets of structs and arrays",
+			source: "struct p { x:u8, y, z, w }\nmk(n):p = p{x: 1, y: n, z: 3, w: 4}\nf(a:p) = let b = mk(a.y * 2) in let c = [3]i16{1, 2, 3} in let a = c in b.y + a[2] + b.w\nmain = f(mk(5))\n",
+			want:   10 + 3 + 4,
+		},
+		{
+			name:   "names clashing with assembler keywords and labels",
+			source: "_start(rax)=rax+1\nCALL(rdi,ret)=_start(ret)*rdi\nsyscall()=CALL(2,20)\nmain = syscall()\n",
+			want:   42,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := compileAndRun(t, tc.source); got != tc.want {
+				t.Errorf("exit code = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Test_golden compares the output of every stage for the programs in data/ against
+// the golden files in data/golden, run with -update to regenerate them
+func Test_golden(t *testing.T) {
+	sources, err := filepath.Glob("data/*.lwl")
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	for _, source := range sources {
+		name := strings.TrimSuffix(filepath.Base(source), ".lwl")
+		t.Run(name, func(t *testing.T) {
+			functions, err := tokenize([]string{source})
+			if err != nil {
+				t.Fatalf("tokenize() error = %v", err)
+			}
+			decls, err := parse(functions)
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			checkTypes(t, decls)
+			instructions, err := passemble(decls)
+			if err != nil {
+				t.Fatalf("passemble() error = %v", err)
+			}
+
+			stages := map[string]func(w io.Writer) error{
+				emitTokens: func(w io.Writer) error { return dumpTokens(w, functions) },
+				emitAST:    func(w io.Writer) error { return dumpAST(w, decls) },
+				emitIR:     func(w io.Writer) error { return dumpIR(w, instructions) },
+				emitAsm: func(w io.Writer) error {
+					asCode, err := toGAS(instructions)
+					if err != nil {
+						return err
+					}
+					_, err = io.WriteString(w, asCode)
+					return err
+				},
+			}
+			for stage, dump := range stages {
+				b := bytes.Buffer{}
+				if err := dump(&b); err != nil {
+					t.Fatalf("emit %v: %v", stage, err)
+				}
+				golden := filepath.Join("data", "golden", name+"."+stage)
+				if *update {
+					if err := os.WriteFile(golden, b.Bytes(), 0o600); err != nil {
+						t.Fatalf("update %v: %v", golden, err)
+					}
+					continue
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("read %v: %v", golden, err)
+				}
+				if b.String() != string(want) {
+					t.Errorf("emit %v =\n%v\nwant (%v)\n%v", stage, b.String(), golden, string(want))
+				}
+			}
+		})
+	}
+}
+
+// Test_library links C callers against LWL libraries, both the native and the GAS objects
+func Test_library(t *testing.T) {
+	if !hasTools("gcc") {
+		t.Skip("linking against C needs gcc")
+	}
+
+	tests := []struct {
+		name    string
+		source  string
+		caller  string
+		wantRet int
+	}{
+		{
+			name:    "data/function.c style caller",
+			source:  "f(x,y)=x+y\n",
+			caller:  "int main()\n{\n    return f(1, 2);\n}\n",
+			wantRet: 3,
+		},
+		{
+			name:   "every argument register, the stack and the callee saved registers",
+			source: "sq(x)=x*x\nmany(a,b,c,d,e,g,h,i)=sq(a)+b+c+d+e+g+h*i\n",
+			caller: "int main(void)\n{\n" +
+				"    register long kept asm(\"rbx\") = 7;\n" +
+				"    long r = many(3, 1, 1, 1, 1, 1, 2, 10) + sq(2);\n" +
+				"    asm volatile(\"\" : \"+r\"(kept));\n" +
+				"    return kept == 7 ? r : 255;\n}\n",
+			wantRet: 9 + 5 + 20 + 4,
+		},
+		{
+			name:   "narrow arguments are extended whatever the caller left in the upper bits",
+			source: "f(x:u8,a,b,c,d,e,y:i8):i16=i16(x)+y\n",
+			caller: "int main(void)\n{\n" +
+				"    long (*g)(long, long, long, long, long, long, long) = (void *)f;\n" +
+				"    return g(0x1ff, 0, 0, 0, 0, 0, 0x1ff) == 254 && f(255, 0, 0, 0, 0, 0, -1) == 254;\n}\n",
+			wantRet: 1,
+		},
+		{
+			name: "structs in registers, on the stack and in memory",
+			source: "struct point { x:i32, y:i32 }\nstruct pair { lo:u8, hi }\nstruct big { a, b:u8, c:i16, d }\n" +
+				"add(p:point, q:point):point = point{x: p.x + q.x, y: p.y + q.y}\n" +
+				"swap(p:pair):pair = pair{lo: u8(p.hi), hi: p.lo}\n" +
+				"mk(a, b:u8, c:i16, d):big = big{a: a, b: b, c: c, d: d}\n" +
+				"sum(a, b, c, d, e, p:pair, g:big) = a + b + c + d + e + p.lo + p.hi + g.a + g.b + g.c + g.d\n",
+			caller: "int main(void)\n{\n" +
+				"    struct point p = add((struct point){1, 2}, (struct point){20, 10});\n" +
+				"    struct pair s = swap((struct pair){7, 300});\n" +
+				"    struct big g = mk(1, 200, -3, 4);\n" +
+				"    long total = sum(1, 1, 1, 1, 1, (struct pair){2, 3}, (struct big){4, 5, 6, 7});\n" +
+				"    return p.x == 21 && p.y == 12 && s.lo == 44 && s.hi == 7 && g.a == 1 && g.b == 200 && g.c == -3 && g.d == 4 && total == 32;\n}\n",
+			wantRet: 1,
+		},
+		{
+			name: "arrays wrapped in structs",
+			source: "rev(a:[3]i16):[3]i16 = [3]i16{a[2], a[1], a[0]}\n" +
+				"mk(x):[5]u32 = [5]u32{u32(x), 1, 2, 3, 4}\n" +
+				"at(a:[5]u32, i) = a[i]\n",
+			caller: "int main(void)\n{\n" +
+				"    struct lwl_array_i16_3 r = rev((struct lwl_array_i16_3){{1, -2, 3}});\n" +
+				"    struct lwl_array_u32_5 m = mk(70000);\n" +
+				"    return r.items[0] == 3 && r.items[1] == -2 && r.items[2] == 1 && m.items[0] == 70000 && m.items[4] == 4 && at(m, 3) == 3;\n}\n",
+			wantRet: 1,
+		},
+		{
+			name:    "comparisons and conditionals",
+			source:  "clamp(x:i32, lo:i32, hi:i32):i32 = x < lo ? lo : x > hi ? hi : x\nbelow(x:u8, y:u8):u8 = x < y\n",
+			caller:  "int main(void)\n{\n    return clamp(-5, 0, 10) == 0 && clamp(50, 0, 10) == 10 && clamp(7, 0, 10) == 7 && below(1, 200) == 1 && below(200, 1) == 0;\n}\n",
+			wantRet: 1,
+		},
+		{
+			name:    "main is left out of the library",
+			source:  "f()=2\nmain = f()+40\n",
+			caller:  "int main(void)\n{\n    return f();\n}\n",
+			wantRet: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			decls, err := parse(tokenizeSource(t, tc.source))
+			if err != nil && !errors.Is(err, errNoMain) {
+				t.Fatalf("parse() error = %v", err)
+			}
+			checkTypes(t, decls)
+			instructions, err := passembleLibrary(decls)
+			if err != nil {
+				t.Fatalf("passembleLibrary() error = %v", err)
+			}
+
+			header := bytes.Buffer{}
+			if err := writeCHeader(&header, decls, "lib"); err != nil {
+				t.Fatalf("writeCHeader() error = %v", err)
+			}
+			caller := "#include \"lib.h\"\n\n" + tc.caller
+			for name, contents := range map[string]string{"lib.h": header.String(), "caller.c": caller} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o600); err != nil {
+					t.Fatalf("write %v: %v", name, err)
+				}
+			}
+
+			objects := map[string]func(string) error{
+				"native": func(obj string) error { return writeObject(instructions, obj) },
+			}
+			if hasTools("as") {
+				objects["gas"] = func(obj string) error {
+					asCode, err := toGAS(instructions)
+					if err != nil {
+						return err
+					}
+					return assemble(asCode, obj)
+				}
+			}
+			for backend, build := range objects {
+				obj := filepath.Join(dir, backend+".o")
+				if err := build(obj); err != nil {
+					t.Fatalf("%v object: %v", backend, err)
+				}
+				exe := filepath.Join(dir, backend)
+				o, err := exec.Command("gcc", "-Wall", "-Werror", "-o", exe, filepath.Join(dir, "caller.c"), obj).CombinedOutput()
+				if err != nil {
+					t.Fatalf("gcc with %v object: %v: %s", backend, err, o)
+				}
+				if got := run(t, exe); got != tc.wantRet {
+					t.Errorf("%v exit code = %v, want %v", backend, got, tc.wantRet)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The files given to the compiler make up the main module and share their functions. Any other file is a
+// module of its own, imported with import "path" relative to the importing file and named after it: math.lwl
+// is math, and other modules call its functions as math.sq(x) when it declares them as export sq(x) = ...
+// From parse on functions are named after their module, math.sq, so two modules can both define f.
+
+// lwlExtension is added to the path of an import that does not end with it
+const lwlExtension = ".lwl"
+
+// qualify names a function of a module, the functions of the main module keep their own name
+func qualify(module, name string) string {
+	if module == "" {
+		return name
+	}
+	return module + "." + name
+}
+
+// loadImports tokenizes the file of every import, and of every import in those, each file once. The
+// import lines are named after the module they import once its file is found.
+func loadImports(functions []function, roots []string) []function {
+	files := make(map[string]string) // the absolute path of every file tokenized to its module
+	for _, file := range roots {
+		files[absPath(file)] = ""
+	}
+	paths := make(map[string]string) // every module to the path of its file
+
+	// functions grows with the lines of every module loaded, so their imports are loaded as well
+	for i := 0; i < len(functions); i++ {
+		f := &functions[i]
+		if !f.importDecl || len(f.errs) > 0 {
+			continue
+		}
+		path, name, ok := importPath(f)
+		if !ok {
+			continue
+		}
+		if module, loaded := files[absPath(path)]; loaded {
+			if module == "" {
+				f.errorAt(f.tkns[1], codeInvalidImport, "can not import "+path+", it is part of the main module",
+					"the files given to the compiler make up the main module")
+				continue
+			}
+			f.name = module
+			continue
+		}
+		if other, taken := paths[name]; taken {
+			f.errorAt(f.tkns[1], codeInvalidImport, "module "+name+" is already imported from "+other,
+				"a module is named after its file, so two files with the same name can not both be imported")
+			continue
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			f.errorAt(f.tkns[1], codeInvalidImport, fmt.Sprintf("can not import %v: %v", path, err))
+			continue
+		}
+		f.name = name
+		files[absPath(path)], paths[name] = name, path
+		functions = append(functions, tokenizeFile(path, string(contents), name)...)
+	}
+	reportImportCycles(functions)
+	return functions
+}
+
+// importPath is the file an import refers to, relative to the file importing it, and the module it names
+func importPath(f *function) (string, string, bool) {
+	if len(f.tkns) != 2 || f.tkns[1].t != tstring {
+		f.errorAt(f.tkns[0], codeInvalidImport, "invalid import", `a module is imported as import "path"`)
+		return "", "", false
+	}
+	path := f.tkns[1].v[1 : len(f.tkns[1].v)-1]
+	if path == "" || strings.Contains(path, `\`) {
+		f.errorAt(f.tkns[1], codeInvalidImport, "invalid import path "+f.tkns[1].v, "paths can not be empty nor have escapes")
+		return "", "", false
+	}
+	if !strings.HasSuffix(path, lwlExtension) {
+		path += lwlExtension
+	}
+	name := strings.TrimSuffix(filepath.Base(path), lwlExtension)
+	_, isKeyword := keywords[name]
+	_, isType := intTypes[name]
+	if !isName(name) || isKeyword || isType {
+		f.errorAt(f.tkns[1], codeInvalidImport, "invalid module name "+name, "a module is named after its file, which must be a valid name")
+		return "", "", false
+	}
+	return filepath.Join(filepath.Dir(f.file), path), name, true
+}
+
+// absPath cleans up path so the same file is always found by the same path
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// reportImportCycles reports the imports that close a cycle, going depth first from the main module
+// through the imports of every module and reporting the ones back to a module still being visited
+func reportImportCycles(functions []function) {
+	imports := make(map[string][]*function)
+	for i := range functions {
+		if f := &functions[i]; f.importDecl && f.name != "" {
+			imports[f.module] = append(imports[f.module], f)
+		}
+	}
+
+	visiting, visited := make(map[string]bool), make(map[string]bool)
+	stack := make([]string, 0)
+	var visit func(module string)
+	visit = func(module string) {
+		visiting[module] = true
+		stack = append(stack, module)
+		for _, f := range imports[module] {
+			switch {
+			case visiting[f.name]:
+				cycle := append(slices.Clone(stack[slices.Index(stack, f.name):]), f.name)
+				f.errorAt(f.tkns[1], codeImportCycle, "import cycle: "+strings.Join(cycle, " -> "))
+			case !visited[f.name]:
+				visit(f.name)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		visiting[module], visited[module] = false, true
+	}
+	visit("")
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// tokenizeModules writes every source into a temporary directory and tokenizes the roots, the files given
+// to the compiler or just main.lwl without them, and every module they import
+func tokenizeModules(t *testing.T, sources map[string]string, roots ...string) []function {
+	t.Helper()
+	dir := t.TempDir()
+	for name, source := range sources {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			t.Fatalf("failed to create test directory: %v", err)
+		}
+		if err := os.WriteFile(file, []byte(source), 0o600); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+	}
+	if len(roots) == 0 {
+		roots = []string{"main.lwl"}
+	}
+	files := make([]string, 0, len(roots))
+	for _, root := range roots {
+		files = append(files, filepath.Join(dir, root))
+	}
+	functions, err := tokenize(files)
+	if err != nil {
+		t.Fatalf("tokenize() error = %v", err)
+	}
+	return functions
+}
+
+func Test_loadImports(t *testing.T) {
+	tests := []struct {
+		name        string
+		sources     map[string]string
+		roots       []string
+		wantModules []string // the modules of every line, in order
+		wantCode    diagnosticCode
+		wantMsg     string
+	}{
+		{
+			name: "imports a module",
+			sources: map[string]string{
+				"main.lwl": "import \"math\"\nmain = math.sq(2)\n",
+				"math.lwl": "export sq(x) = x * x\n",
+			},
+			wantModules: []string{"", "", "math"},
+		},
+		{
+			name: "imports relative to the importing file",
+			sources: map[string]string{
+				"main.lwl":      "import \"lib/shape.lwl\"\nmain = shape.side()\n",
+				"lib/shape.lwl": "import \"math\"\nexport side() = math.sq(2)\n",
+				"lib/math.lwl":  "export sq(x) = x * x\n",
+			},
+			wantModules: []string{"", "", "shape", "shape", "math"},
+		},
+		{
+			name: "loads a module imported twice once",
+			sources: map[string]string{
+				"main.lwl":  "import \"math\"\nimport \"shape\"\nmain = shape.side()\n",
+				"shape.lwl": "import \"math\"\nexport side() = math.sq(2)\n",
+				"math.lwl":  "export sq(x) = x * x\n",
+			},
+			wantModules: []string{"", "", "", "math", "shape", "shape"},
+		},
+		{
+			name:     "without a path",
+			sources:  map[string]string{"main.lwl": "import math\nmain = 1\n"},
+			wantCode: codeInvalidImport,
+			wantMsg:  "invalid import",
+		},
+		{
+			name:     "a file that does not exist",
+			sources:  map[string]string{"main.lwl": "import \"math\"\nmain = 1\n"},
+			wantCode: codeInvalidImport,
+			wantMsg:  "can not import",
+		},
+		{
+			name: "a file that is not a valid name",
+			sources: map[string]string{
+				"main.lwl":    "import \"2d-math\"\nmain = 1\n",
+				"2d-math.lwl": "export sq(x) = x * x\n",
+			},
+			wantCode: codeInvalidImport,
+			wantMsg:  "invalid module name 2d-math",
+		},
+		{
+			name: "two files with the same module name",
+			sources: map[string]string{
+				"main.lwl":     "import \"math\"\nimport \"lib/math\"\nmain = 1\n",
+				"math.lwl":     "export sq(x) = x * x\n",
+				"lib/math.lwl": "export cube(x) = x * x * x\n",
+			},
+			wantCode: codeInvalidImport,
+			wantMsg:  "module math is already imported from ",
+		},
+		{
+			name: "a file of the main module",
+			sources: map[string]string{
+				"main.lwl": "import \"util\"\nmain = 1\n",
+				"util.lwl": "one() = 1\n",
+			},
+			roots:    []string{"main.lwl", "util.lwl"},
+			wantCode: codeInvalidImport,
+			wantMsg:  "it is part of the main module",
+		},
+		{
+			name: "a module importing itself",
+			sources: map[string]string{
+				"main.lwl": "import \"math\"\nmain = 1\n",
+				"math.lwl": "import \"math\"\nexport sq(x) = x * x\n",
+			},
+			wantCode: codeImportCycle,
+			wantMsg:  "import cycle: math -> math",
+		},
+		{
+			name: "modules importing each other",
+			sources: map[string]string{
+				"main.lwl":  "import \"shape\"\nmain = 1\n",
+				"shape.lwl": "import \"math\"\nexport side() = 2\n",
+				"math.lwl":  "import \"shape\"\nexport sq(x) = x * x\n",
+			},
+			wantCode: codeImportCycle,
+			wantMsg:  "import cycle: shape -> math -> shape",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			functions := tokenizeModules(t, tc.sources, tc.roots...)
+			diags := collectDiagnostics(functions)
+			if tc.wantMsg != "" {
+				if len(diags) != 1 || diags[0].code != tc.wantCode || !strings.Contains(diags[0].msg, tc.wantMsg) {
+					t.Fatalf("loadImports() diagnostics = %v, want one %v containing %q", diags, tc.wantCode, tc.wantMsg)
+				}
+				return
+			}
+			if len(diags) > 0 {
+				t.Fatalf("loadImports() diagnostics = %v", diags)
+			}
+			modules := make([]string, 0, len(functions))
+			for _, f := range functions {
+				modules = append(modules, f.module)
+			}
+			if !slices.Equal(modules, tc.wantModules) {
+				t.Errorf("loadImports() modules = %q, want %q", modules, tc.wantModules)
+			}
+		})
+	}
+}
+
+func Test_parseModules(t *testing.T) {
+	tests := []struct {
+		name     string
+		sources  map[string]string
+		want     []string
+		wantCode diagnosticCode
+		wantMsg  string
+	}{
+		{
+			name: "qualifies the functions of a module",
+			sources: map[string]string{
+				"main.lwl": "import \"math\"\nf(x) = math.sq(x)\nmain = f(3)\n",
+				"math.lwl": "export sq(x) = twice(x) * x / 2\ntwice(x) = x + x\n",
+			},
+			want: []string{"f(x) = (math.sq x)", "main = (f 3)", "export math.sq(x) = (/ (* (math.twice x) x) 2)", "math.twice(x) = (+ x x)"},
+		},
+		{
+			name: "two modules define the same function",
+			sources: map[string]string{
+				"main.lwl":  "import \"math\"\nimport \"shape\"\nf() = 1\nmain = f() + math.f() + shape.f()\n",
+				"math.lwl":  "export f() = 2\n",
+				"shape.lwl": "export f() = 3\n",
+			},
+			want: []string{"f() = 1", "main = (+ (+ (f) (math.f)) (shape.f))", "export math.f() = 2", "export shape.f() = 3"},
+		},
+		{
+			name: "a module calls itself qualified",
+			sources: map[string]string{
+				"main.lwl": "import \"math\"\nmain = math.sq(2)\n",
+				"math.lwl": "export sq(x) = math.twice(x) * x / 2\ntwice(x) = x + x\n",
+			},
+			want: []string{"main = (math.sq 2)", "export math.sq(x) = (/ (* (math.twice x) x) 2)", "math.twice(x) = (+ x x)"},
+		},
+		{
+			name: "a variable named after a module",
+			sources: map[string]string{
+				"main.lwl": "import \"math\"\nstruct p { sq:u8 }\nf(math:p) = math.sq\nmain = f(p{sq: 1})\n",
+				"math.lwl": "export sq(x) = x * x\n",
+			},
+			want: []string{"f(math:p) = (. math sq)", "main = (f p{sq: 1})", "export math.sq(x) = (* x x)"},
+		},
+		{
+			name: "a function that is not exported",
+			sources: map[string]string{
+				"main.lwl": "import \"math\"\nmain = math.twice(2)\n",
+				"math.lwl": "twice(x) = x + x\n",
+			},
+			wantCode: codePrivateFunction,
+			wantMsg:  "function math.twice is not exported",
+		},
+		{
+			name: "a module that is not imported",
+			sources: map[string]string{
+				"main.lwl":  "import \"shape\"\nmain = math.sq(2)\n",
+				"shape.lwl": "import \"math\"\nexport side() = 2\n",
+				"math.lwl":  "export sq(x) = x * x\n",
+			},
+			wantCode: codeUndefinedFunction,
+			wantMsg:  "undefined function math.sq",
+		},
+		{
+			name: "a function the module does not have",
+			sources: map[string]string{
+				"main.lwl": "import \"math\"\nmain = math.cube(2)\n",
+				"math.lwl": "export sq(x) = x * x\n",
+			},
+			wantCode: codeUndefinedFunction,
+			wantMsg:  "undefined function math.cube",
+		},
+		{
+			name: "the functions of the main module from a module",
+			sources: map[string]string{
+				"main.lwl": "import \"math\"\none() = 1\nmain = math.sq(2)\n",
+				"math.lwl": "export sq(x) = x * one()\n",
+			},
+			wantCode: codeUndefinedFunction,
+			wantMsg:  "undefined function math.one",
+		},
+		{
+			name: "main in a module",
+			sources: map[string]string{
+				"main.lwl": "import \"math\"\nmain = 1\n",
+				"math.lwl": "main = 2\n",
+			},
+			wantCode: codeMainInModule,
+			wantMsg:  "main declared in module math",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			functions := tokenizeModules(t, tc.sources)
+			decls, err := parse(functions)
+			diags := collectDiagnostics(functions)
+			if tc.wantMsg != "" {
+				if len(diags) != 1 || diags[0].code != tc.wantCode || !strings.Contains(diags[0].msg, tc.wantMsg) {
+					t.Fatalf("parse() diagnostics = %v, want one %v containing %q", diags, tc.wantCode, tc.wantMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse() error = %v: %v", err, diags)
+			}
+			got := make([]string, 0, len(decls))
+			for _, d := range decls {
+				got = append(got, d.String())
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("parse() =\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+func Test_endToEndModules(t *testing.T) {
+	functions := tokenizeModules(t, map[string]string{
+		"main.lwl":  "import \"math\"\nimport \"shape\"\nf(x) = x + 1\nmain = f(math.f(shape.f(2)))\n",
+		"math.lwl":  "export f(x) = g(x) * 2\ng(x) = x + 10\n",
+		"shape.lwl": "import \"math\"\nexport f(x) = math.f(x) + g(x)\ng(x) = x * 3\n",
+	})
+	// shape.f(2) = (2 + 10) * 2 + 2 * 3 = 30, math.f(30) = 80, f(80) = 81
+	if code := compileAndRunFunctions(t, functions); code != 81 {
+		t.Errorf("exit code %v, want 81", code)
+	}
+}
+
+func Test_libraryModules(t *testing.T) {
+	functions := tokenizeModules(t, map[string]string{
+		"main.lwl": "import \"math\"\narea(w, h) = math.mul(w, h)\n",
+		"math.lwl": "export mul(x, y) = x * y\n",
+	})
+	decls, err := parse(functions)
+	if err != nil && !errors.Is(err, errNoMain) {
+		t.Fatalf("parse() error = %v: %v", err, collectDiagnostics(functions))
+	}
+	checkTypes(t, decls)
+
+	// only the functions of the main module make up the library, the ones of its modules are called by them
+	instructions, err := passembleLibrary(decls)
+	if err != nil {
+		t.Fatalf("passembleLibrary() error = %v", err)
+	}
+	globals := make([]string, 0)
+	for _, i := range instructions {
+		if i.opcode == globalop {
+			globals = append(globals, i.args...)
+		}
+	}
+	if !slices.Equal(globals, []string{"area"}) {
+		t.Errorf("passembleLibrary() globals = %v, want [area]", globals)
+	}
+	b := bytes.Buffer{}
+	if err := writeCHeader(&b, decls, "lib"); err != nil {
+		t.Fatalf("writeCHeader() error = %v", err)
+	}
+	if got := b.String(); !strings.Contains(got, "long area(long, long);\n") || strings.Contains(got, "mul") {
+		t.Errorf("writeCHeader() =\n%v\nwant only area", got)
+	}
+}
@@ -3,137 +3,855 @@ package main
 import (
 	"errors"
 	"fmt"
-	"log"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
 )
 
+// builtinLen is the name of the only builtin function, the length of an array
+const builtinLen = "len"
+
+// defaultEntry is where a program starts unless another function is picked with -entry
+const defaultEntry = "main"
+
 var (
 	errParse         = errors.New("parse error")
 	errNoMain        = errors.New("no main function defined")
 	errMultipleMains = errors.New("multiple main functions defined")
+	errEntry         = errors.New("invalid entry point")
 )
 
-// NOTE: for now parse only checks syntax parsing
-// there's no abstract syntax tree or semantic analysis due to the simplicity of the language
-// TODO: accept parenthesis syntax in expressions for grouping order
-func parse(functions []function) error {
-	functionRegistry := make(map[string]struct{})
-	mainFunctions := make([]function, 0, 1)
+// parse checks the syntax of every function and builds its abstract syntax tree, in three passes over
+// every file: first the structs, then the headers of the functions and last their bodies, so whatever
+// is declared anywhere can be used anywhere, and functions can call themselves and each other. Structs
+// are shared by every module, functions are named after theirs.
+func parse(functions []function) ([]*funcDecl, error) {
+	typeRegistry := maps.Clone(intTypes)
+	imports := make(map[string]map[string]bool) // every module to the modules it imports
 	for i := range functions {
-		// TODO: make this possible to run in parallel and safer than this
 		f := &functions[i] // get the pointer to be able to append to errs
-		if _, exists := functionRegistry[f.name]; exists && !f.main {
-			f.errs = append(f.errs, errors.New("function "+f.name+" already defined"))
-			continue
+		if f.structDecl && len(f.errs) == 0 {
+			p := parser{f: f, types: typeRegistry}
+			p.parseStruct()
 		}
-		functionRegistry[f.name] = struct{}{}
+		if f.importDecl && f.name != "" {
+			if imports[f.module] == nil {
+				imports[f.module] = make(map[string]bool)
+			}
+			imports[f.module][f.name] = true
+		}
+	}
 
-		// check only one or zero eq are defined
-		eqCount := 0
+	type header struct {
+		decl *funcDecl
+		p    *parser // right after the '='
+	}
+	functionRegistry := make(map[string]*funcDecl)
+	mainFunctions := make([]*function, 0, 1)
+	headers := make([]header, 0, len(functions))
+	for i := range functions {
+		// TODO: make this possible to run in parallel and safer than this
+		f := &functions[i]
+		if f.structDecl || f.importDecl || len(f.tkns) == 0 {
+			continue // nothing but the errors of the tokenizer, if any
+		}
+		// check only one or zero eq are defined, besides the one of every let
+		eqCount, lets := 0, 0
 		for _, t := range f.tkns {
-			if t.t == teq {
+			switch {
+			case t.t == tlet:
+				lets++
+			case t.t == teq && lets > 0:
+				lets--
+			case t.t == teq:
 				eqCount++
 			}
+			if eqCount == 2 {
+				f.errorAt(t, codeMultipleEq, "function "+f.name+" has multiple '='")
+				break
+			}
 		}
 		if eqCount > 1 {
-			f.errs = append(f.errs, errors.New("function "+f.name+" has multiple '='"))
 			continue
 		}
-		if eqCount == 0 && !f.main { // should not be possible due to tokenizer logic
-			f.errs = append(f.errs, errors.New("function "+f.name+" has no '='"))
+		if eqCount == 0 {
+			// most likely a definition with a typo, or an expression written as if it was main
+			switch {
+			case f.main:
+				f.errorAt(f.tkns[0], codeMissingEq, "main has no '='", "the entry point is defined as main = expr")
+			case f.name != "":
+				f.errorAt(f.tkns[0], codeMissingEq, "function "+f.name+" has no '='", "the entry point is defined as main = expr")
+			default:
+				f.errorAt(f.tkns[0], codeMissingEq, "expression outside of any function", "the entry point is defined as main = expr")
+			}
+			continue
+		}
+		name := qualify(f.module, f.name)
+		if previous, exists := functionRegistry[name]; exists && !f.main {
+			f.errorAt(f.tkns[0], codeDuplicateFunction, "function "+name+" already defined",
+				fmt.Sprintf("previously defined at %v:%v", previous.file, previous.line))
+			continue
+		}
+		if f.main && f.module != "" {
+			f.errorAt(f.tkns[0], codeMainInModule, "main declared in module "+f.module,
+				"main = expr goes in the files given to the compiler, imported modules only declare functions")
 			continue
 		}
 		if f.main {
-			mainFunctions = append(mainFunctions, *f)
+			mainFunctions = append(mainFunctions, f)
+		}
+		if len(f.errs) > 0 {
+			continue // the tokens are not to be trusted
+		}
+
+		decl := &funcDecl{
+			position: position{file: f.file, line: f.line, col: f.tkns[0].col},
+			name:     name,
+			main:     f.main,
+		}
+		p := &parser{f: f, functions: functionRegistry, types: typeRegistry, variables: make(map[string]struct{}),
+			module: f.module, imports: imports[f.module]}
+		if f.main {
+			p.i++ // main takes no parameters, the '=' comes right after it
+			if _, ok := p.expect(teq); !ok {
+				continue
+			}
+		} else {
+			// registered even if the header is wrong, so the calls to it are not undefined
+			functionRegistry[name] = decl
+			if !p.parseHeader(decl) {
+				continue
+			}
 		}
+		headers = append(headers, header{decl: decl, p: p})
+	}
 
-		if f.main && f.tkns[0].t != tvariable && f.tkns[0].t != tconstant {
-			f.errs = append(f.errs, errors.New("main function must start with a variable or constant"))
+	decls := make([]*funcDecl, 0, len(headers))
+	for _, h := range headers {
+		h.decl.body = h.p.parseExpr()
+		if h.decl.body == nil {
+			continue
+		}
+		if _, ok := h.p.next(); ok {
+			h.p.unexpected()
 			continue
 		}
+		decls = append(decls, h.decl)
+	}
+
+	if len(mainFunctions) > 1 {
+		first := mainFunctions[0]
+		definedMains := []string{fmt.Sprintf("%v:%v", first.file, first.line)}
+		for _, f := range mainFunctions[1:] {
+			definedMains = append(definedMains, fmt.Sprintf("%v:%v", f.file, f.line))
+			f.errorAt(f.tkns[0], codeMultipleMains, "main already defined",
+				fmt.Sprintf("previously defined at %v:%v", first.file, first.line))
+		}
+		return nil, fmt.Errorf("%w: %v", errMultipleMains, definedMains)
+	}
 
-		// for each token, check if it is a valid declared variable on the function or an existing function call
-		declaredVariables := make(map[string]struct{})
-		functionHeaderEnded := false
-		i := 0
+	// at the end of the parsing, count all errors, they are kept in each function to be reported
+	foundErrors := countErrors(collectDiagnostics(functions))
+	if foundErrors > 0 {
+		return nil, fmt.Errorf("%w %v found errors", errParse, foundErrors)
+	}
+
+	// the declarations are still good for a library, which has no main
+	if len(mainFunctions) == 0 {
+		return decls, errNoMain
+	}
+	return decls, nil
+}
+
+// noMain is the diagnostic of a program without an entry point, it has no line to point to so it is
+// reported on the whole of the file, the first one given to the compiler
+func noMain(file string) diagnostic {
+	return diagnostic{file: file, severity: severityError, code: codeNoMain, msg: errNoMain.Error(),
+		notes: []string{"the entry point is defined as main = expr, or picked with -entry name"}}
+}
+
+// withEntry makes the function named entry the start of the program instead of main, which is left out:
+// the new main calls it and exits with its result, so it must take no parameters. An entry that is not
+// defined is reported on the whole of the file, the first one given to the compiler
+func withEntry(decls []*funcDecl, entry string, file string) ([]*funcDecl, []diagnostic, error) {
+	if entry == defaultEntry {
+		return decls, nil, nil
+	}
+	i := slices.IndexFunc(decls, func(d *funcDecl) bool { return !d.main && d.name == entry })
+	if i < 0 {
+		msg := fmt.Sprintf("function %v is not defined", entry)
+		d := diagnostic{file: file, severity: severityError, code: codeInvalidEntry, msg: "invalid entry point: " + msg}
+		return nil, []diagnostic{d}, fmt.Errorf("%w: %v", errEntry, msg)
+	}
+	f := decls[i]
+	if len(f.params) > 0 {
+		msg := fmt.Sprintf("function %v takes %v parameters, it must take none", entry, len(f.params))
+		d := newDiagnostic(severityError, codeInvalidEntry, f.position, len(f.name), "invalid entry point: "+msg,
+			"-entry picks a function called without arguments")
+		return nil, []diagnostic{d}, fmt.Errorf("%w: %v", errEntry, msg)
+	}
+	decls = slices.DeleteFunc(slices.Clone(decls), func(d *funcDecl) bool { return d.main })
+	call := &callExpr{position: f.position, name: f.name}
+	return append(decls, &funcDecl{position: f.position, body: call, main: true}), nil, nil
+}
+
+// parser is a recursive descent parser over the tokens of a single function or struct
+//
+//	import   = "import" string
+//	struct   = "struct" name "{" param { "," param } "}"
+//	function = [ "export" ] name "(" [ param { "," param } ] ")" [ ":" type ] "=" expr
+//	param    = name [ ":" type ]
+//	type     = name | "[" constant "]" type
+//	main     = "main" "=" expr
+//	expr     = binary [ "?" expr ":" expr ]
+//	binary   = primary { op primary } // climbing by operator precedence
+//	primary  = operand { "." name | "[" expr "]" }
+//	operand  = constant | name | [ name "." ] name "(" [ expr { "," expr } ] ")" | "(" expr ")" | "-" primary
+//	         | name "{" [ name ":" expr { "," name ":" expr } ] "}"
+//	         | "[" constant "]" type "{" [ expr { "," expr } ] "}"
+//	         | "let" param "=" expr "in" expr // the body takes in as much as it can: 1 + let y = 2 in y * 3 is 1 + (y * 3)
+//
+// calls to a type name are casts: type "(" expr ")", and len "(" expr ")" is the length of an array,
+// a call to module "." name "(" ... ")" is to an exported function of an imported module
+type parser struct {
+	f         *function
+	i         int
+	functions map[string]*funcDecl
+	types     map[string]*lwlType // the integer types and every struct declared so far
+	variables map[string]struct{}
+	module    string          // the module of the function, its calls are to the functions of the module
+	imports   map[string]bool // the modules imported by the module
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.i >= len(p.f.tkns) {
+		return token{}, false
+	}
+	return p.f.tkns[p.i], true
+}
+
+func (p *parser) next() (token, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.i++
+	}
+	return t, ok
+}
+
+func (p *parser) at(t token) position {
+	return position{file: p.f.file, line: t.line, col: t.col}
+}
+
+// unexpected records a syntax error for the last token read
+func (p *parser) unexpected() {
+	t := p.f.tkns[p.i-1]
+	what := "'" + t.v + "'"
+	switch {
+	case t.isOp():
+		what = "operator"
+	case t.t == tconstant:
+		what = "constant"
+	case t.t == tvariable:
+		what = "variable"
+	}
+	after := "start of line"
+	if p.i > 1 {
+		after = p.f.tkns[p.i-2].v
+	}
+	p.f.errorAt(t, codeUnexpectedToken, "unexpected "+what+" after "+after)
+}
+
+// unexpectedEnd records a syntax error for a line that ended too soon
+func (p *parser) unexpectedEnd() {
+	last := p.f.tkns[len(p.f.tkns)-1]
+	end := token{line: last.line, col: last.col + len(last.v)}
+	p.f.errorAt(end, codeUnexpectedEnd, "unexpected end of line after "+last.v)
+}
+
+// expect reads the next token and records a syntax error if it is not of type tt
+func (p *parser) expect(tt tokenType) (token, bool) {
+	t, ok := p.next()
+	if !ok {
+		p.unexpectedEnd()
+		return t, false
+	}
+	if t.t != tt {
+		p.unexpected()
+		return t, false
+	}
+	return t, true
+}
+
+func (p *parser) parseHeader(decl *funcDecl) bool {
+	if t, ok := p.peek(); ok && t.t == texport {
+		p.i++
+		decl.exported = true
+	}
+	name, ok := p.expect(tvariable)
+	if !ok {
+		return false
+	}
+	if name.v == builtinLen {
+		p.f.errorAt(name, codeReservedName, "function "+name.v+" is named after a builtin", "len(...) is the length of an array")
+	}
+	if t, isType := p.types[name.v]; isType {
+		note := "calling " + name.v + "(...) is a cast"
+		if !t.isInt() {
+			note = fmt.Sprintf("struct %v is declared at %v:%v", t, t.file, t.line)
+		}
+		p.f.errorAt(name, codeReservedName, "function "+name.v+" is named after a type", note)
+	}
+	if _, ok := p.expect(tlparenth); !ok {
+		return false
+	}
+	if t, ok := p.peek(); ok && t.t == trparenth {
+		p.i++
+	} else {
 		for {
-			prevToken := f.tkns[i]
-			i++
-			if i >= len(f.tkns) {
-				break
+			t, ok := p.expect(tvariable)
+			if !ok {
+				return false
 			}
-			t := f.tkns[i]
+			if _, exists := p.variables[t.v]; exists {
+				p.f.errorAt(t, codeDuplicateParameter, "parameter "+t.v+" already declared")
+			}
+			p.variables[t.v] = struct{}{}
+			param := &ident{position: p.at(t), name: t.v}
+			decl.params = append(decl.params, param)
 
-			// function declaration is going to b X(vars)=, unless it is the main function
-			if t.t == tvariable && !f.main && !functionHeaderEnded {
-				declaredVariables[t.v] = struct{}{}
-				continue
+			t, ok = p.next()
+			if ok && t.t == tcolon {
+				if param.typ, ok = p.parseType(); !ok {
+					return false
+				}
+				t, ok = p.next()
 			}
-			if t.t == teq {
-				functionHeaderEnded = true
-				continue
+			if ok && t.t == trparenth {
+				break
 			}
-			if t.t == tlparenth && prevToken.t != tvariable {
-				f.errs = append(f.errs, errors.New("unexpected '(' after "+prevToken.v))
-				continue
+			if !ok {
+				p.unexpectedEnd()
+				return false
 			}
-			if t.t == trparenth && prevToken.t != tconstant && prevToken.t != tvariable {
-				f.errs = append(f.errs, errors.New("unexpected ')' after "+prevToken.v))
-				continue
+			if t.t != tcomma {
+				p.unexpected()
+				return false
 			}
-			if t.t == tcomma && prevToken.t != tconstant && prevToken.t != tvariable {
-				f.errs = append(f.errs, errors.New("unexpected ',' after "+prevToken.v))
-				continue
+		}
+	}
+	if t, ok := p.peek(); ok && t.t == tcolon {
+		p.i++
+		if decl.result, ok = p.parseType(); !ok {
+			return false
+		}
+	}
+	_, ok = p.expect(teq)
+	return ok
+}
+
+// parseType parses the type of an annotation, the ':' was already read
+func (p *parser) parseType() (*lwlType, bool) {
+	t, ok := p.next()
+	if !ok {
+		p.unexpectedEnd()
+		return nil, false
+	}
+	if t.t == tlbracket {
+		return p.parseArrayType()
+	}
+	if t.t != tvariable {
+		p.unexpected()
+		return nil, false
+	}
+	typ, ok := p.types[t.v]
+	if !ok {
+		p.f.errorAt(t, codeUnknownType, "unknown type "+t.v, "the types are "+typeNames+", or a struct")
+	}
+	return typ, ok
+}
+
+// parseArrayType parses the length and the type of the elements of an array, the "[" was already read,
+// every array type is registered by its name so the same array types are the very same type
+func (p *parser) parseArrayType() (*lwlType, bool) {
+	n, ok := p.expect(tconstant)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := p.expect(trbracket); !ok {
+		return nil, false
+	}
+	elem, ok := p.parseType()
+	if !ok {
+		return nil, false
+	}
+	length, err := strconv.Atoi(n.v)
+	switch {
+	case err != nil || length > maxArrayLength:
+		p.f.errorAt(n, codeInvalidConstant, "array length "+n.v+" is too big", fmt.Sprintf("arrays hold up to %v elements", maxArrayLength))
+		return nil, false
+	case length == 0:
+		p.f.errorAt(n, codeArrayLength, "array of length 0", "arrays have at least one element")
+		return nil, false
+	case !elem.isInt():
+		p.f.errorAt(p.f.tkns[p.i-1], codeUnknownType, fmt.Sprintf("array elements must be integers, got %v", elem),
+			"the types of elements are "+typeNames)
+		return nil, false
+	}
+	t := newArrayType(elem, length)
+	if existing, ok := p.types[t.name]; ok {
+		return existing, true
+	}
+	p.types[t.name] = t
+	return t, true
+}
+
+// parseStruct parses a struct declaration and registers its type, its fields are integers
+// annotated just like parameters are
+func (p *parser) parseStruct() {
+	start, _ := p.next() // checked by tokenize
+	name, ok := p.expect(tvariable)
+	if !ok {
+		return
+	}
+	if previous, exists := p.types[name.v]; exists {
+		if previous.isInt() {
+			p.f.errorAt(name, codeDuplicateType, "struct "+name.v+" is named after an integer type")
+		} else {
+			p.f.errorAt(name, codeDuplicateType, "struct "+name.v+" already defined",
+				fmt.Sprintf("previously defined at %v:%v", previous.file, previous.line))
+		}
+		return
+	}
+	if _, ok := p.expect(tlbrace); !ok {
+		return
+	}
+	fields := make([]*field, 0)
+	if t, ok := p.peek(); ok && t.t == trbrace {
+		p.i++
+	} else {
+		for {
+			t, ok := p.expect(tvariable)
+			if !ok {
+				return
 			}
-			if t.t == tvariable {
-				_, isDeclared := declaredVariables[t.v]
-				_, isFunction := functionRegistry[t.v]
-				if !isDeclared && !isFunction {
-					f.errs = append(f.errs, errors.New("undefined variable "+t.v))
-					continue
+			f := &field{name: t.v, typ: typeI64}
+			for _, other := range fields {
+				if other.name == f.name {
+					p.f.errorAt(t, codeDuplicateField, "field "+t.v+" already declared")
 				}
 			}
+			fields = append(fields, f)
 
-			if t.isOp() && prevToken.t != tconstant && prevToken.t != tvariable && prevToken.t != trparenth {
-				f.errs = append(f.errs, errors.New("unexpected operator after "+prevToken.v))
-				continue
+			n, ok := p.next()
+			if ok && n.t == tcolon {
+				if f.typ, ok = p.parseType(); !ok {
+					return
+				}
+				if !f.typ.isInt() {
+					p.f.errorAt(p.f.tkns[p.i-1], codeUnknownType, fmt.Sprintf("field %v of %v must be an integer, got %v", t.v, name.v, f.typ),
+						"the types of fields are "+typeNames)
+				}
+				n, ok = p.next()
+			}
+			if ok && n.t == trbrace {
+				break
+			}
+			if !ok {
+				p.unexpectedEnd()
+				return
 			}
+			if n.t != tcomma {
+				p.unexpected()
+				return
+			}
+		}
+	}
+	if _, ok := p.next(); ok {
+		p.unexpected()
+		return
+	}
+	if len(fields) == 0 {
+		p.f.errorAt(name, codeMissingField, "struct "+name.v+" has no fields")
+		return
+	}
+	p.types[name.v] = newStructType(p.at(start), name.v, fields)
+}
 
-			if t.t == tconstant && prevToken.t != tcomma && !prevToken.isOp() && prevToken.t != tlparenth && prevToken.t != teq {
-				f.errs = append(f.errs, errors.New("unexpected constant after "+prevToken.v))
-				continue
+// precedence of the binary operators, the higher the tighter they bind
+var precedence = map[tokenType]int{
+	teqeq: 1,
+	tneq:  1,
+	tlt:   1,
+	tle:   1,
+	tgt:   1,
+	tge:   1,
+	tadd:  2,
+	tsub:  2,
+	tmul:  3,
+	tdiv:  3,
+	tmod:  3,
+}
+
+// parseExpr parses an expression, a conditional binding the loosest of all and to the right:
+// a ? b : c ? d : e is a ? b : (c ? d : e)
+func (p *parser) parseExpr() expr {
+	cond := p.parseBinary(1)
+	if cond == nil {
+		return nil
+	}
+	t, ok := p.peek()
+	if !ok || t.t != tquestion {
+		return cond
+	}
+	p.i++
+	then := p.parseExpr()
+	if then == nil {
+		return nil
+	}
+	if _, ok := p.expect(tcolon); !ok {
+		return nil
+	}
+	els := p.parseExpr()
+	if els == nil {
+		return nil
+	}
+	return &condExpr{position: p.at(t), cond: cond, then: then, els: els}
+}
+
+// parseLet parses a local binding, the "let" was already read. The name is only declared for the body,
+// so the value sees whatever the name was before, and it is undeclared again once the body is parsed.
+func (p *parser) parseLet(start token) expr {
+	name, ok := p.expect(tvariable)
+	if !ok {
+		return nil
+	}
+	l := &letExpr{position: p.at(start), name: &ident{position: p.at(name), name: name.v}}
+	if t, ok := p.peek(); ok && t.t == tcolon {
+		p.i++
+		if l.name.typ, ok = p.parseType(); !ok {
+			return nil
+		}
+	}
+	if _, ok := p.expect(teq); !ok {
+		return nil
+	}
+	if l.value = p.parseExpr(); l.value == nil {
+		return nil
+	}
+	if _, ok := p.expect(tin); !ok {
+		return nil
+	}
+
+	_, shadows := p.variables[name.v]
+	switch {
+	case shadows:
+		p.f.warnAt(name, codeShadowedVariable, "let "+name.v+" shadows the variable "+name.v, "the outer "+name.v+" can not be used within the let")
+	case p.imports[name.v]:
+		p.f.warnAt(name, codeShadowedVariable, "let "+name.v+" shadows the module "+name.v, "the functions of "+name.v+" can not be called within the let")
+	}
+	p.variables[name.v] = struct{}{}
+	l.body = p.parseExpr()
+	if !shadows {
+		delete(p.variables, name.v)
+	}
+	if l.body == nil {
+		return nil
+	}
+	return l
+}
+
+// parseBinary parses an expression whose operators bind at least as tight as minPrec,
+// all operators are left associative so the right side must bind tighter
+func (p *parser) parseBinary(minPrec int) expr {
+	lhs := p.parsePrimary()
+	for lhs != nil {
+		t, ok := p.peek()
+		if !ok || precedence[t.t] < minPrec { // non operators have no precedence
+			break
+		}
+		p.i++
+		rhs := p.parseBinary(precedence[t.t] + 1)
+		if rhs == nil {
+			return nil
+		}
+		// dividing by zero would only trap at runtime, better to stop it right here
+		if l, ok := rhs.(*literal); ok && l.value == 0 && (t.t == tdiv || t.t == tmod) {
+			p.f.errorAt(t, codeDivisionByZero, "division by constant zero", "integer division by zero has no defined result")
+		}
+		lhs = &binaryExpr{position: p.at(t), op: t.t, lhs: lhs, rhs: rhs}
+	}
+	return lhs
+}
+
+func (p *parser) parsePrimary() expr {
+	e := p.parseOperand()
+	for e != nil {
+		t, ok := p.peek()
+		if !ok || t.t != tdot && t.t != tlbracket {
+			break
+		}
+		p.i++
+		if t.t == tlbracket {
+			index := p.parseExpr()
+			if index == nil {
+				return nil
+			}
+			if _, ok := p.expect(trbracket); !ok {
+				return nil
 			}
+			e = &indexExpr{position: p.at(t), operand: e, index: index}
+			continue
+		}
+		name, ok := p.expect(tvariable)
+		if !ok {
+			return nil
+		}
+		e = &fieldExpr{position: p.at(name), operand: e, name: name.v}
+	}
+	return e
+}
 
-			if t.t == tvariable && prevToken.t != tcomma && !prevToken.isOp() && prevToken.t != tlparenth && prevToken.t != teq {
-				f.errs = append(f.errs, errors.New("unexpected variable after "+prevToken.v))
-				continue
+func (p *parser) parseOperand() expr {
+	t, ok := p.next()
+	if !ok {
+		p.unexpectedEnd()
+		return nil
+	}
+	switch t.t {
+	case tlparenth:
+		e := p.parseExpr()
+		if e == nil {
+			return nil
+		}
+		if _, ok := p.expect(trparenth); !ok {
+			return nil
+		}
+		return e
+	case tconstant:
+		return p.parseConstant(t, "")
+	case tlet:
+		return p.parseLet(t)
+	case tstring:
+		p.f.errorAt(t, codeStringLiteral, "string "+t.v+" is not lowered into an array", "strings are lowered into [N]u8 arrays with -plugin strings")
+		return nil
+	case tlbracket:
+		typ, ok := p.parseArrayType()
+		if !ok {
+			return nil
+		}
+		return p.parseArrayLit(t, typ)
+	case tsub:
+		// a negated constant is a constant on its own, otherwise the smallest integer could not be written
+		if n, ok := p.peek(); ok && n.t == tconstant {
+			p.i++
+			l := p.parseConstant(n, "-")
+			l.position = p.at(t)
+			return l
+		}
+		operand := p.parsePrimary()
+		if operand == nil {
+			return nil
+		}
+		return &unaryExpr{position: p.at(t), op: tsub, operand: operand}
+	case tvariable:
+		if p.isQualifiedCall(t) {
+			p.i++ // the '.'
+			name, _ := p.next()
+			p.i++ // the '('
+			return p.parseCall(name, &t)
+		}
+		if n, ok := p.peek(); ok && n.t == tlparenth {
+			p.i++
+			return p.parseCall(t, nil)
+		}
+		if n, ok := p.peek(); ok && n.t == tlbrace {
+			p.i++
+			return p.parseStructLit(t)
+		}
+		if _, isDeclared := p.variables[t.v]; !isDeclared {
+			if _, isFunction := p.functions[qualify(p.module, t.v)]; isFunction {
+				p.f.errorAt(t, codeFunctionAsVariable, "function "+t.v+" used as a variable", "functions must be called: "+t.v+"(...)")
+			} else {
+				p.f.errorAt(t, codeUndefinedVariable, "undefined variable "+t.v)
 			}
 		}
+		return &ident{position: p.at(t), name: t.v}
+	default:
+		p.unexpected()
+		return nil
 	}
+}
 
-	if len(mainFunctions) == 0 {
-		return errNoMain
+// parseConstant parses the constant t, with the sign given, checking it fits in a signed 64 bit integer
+func (p *parser) parseConstant(t token, sign string) *literal {
+	v, err := strconv.ParseInt(sign+t.v, 10, 64)
+	if err != nil {
+		p.f.errorAt(t, codeInvalidConstant, "constant "+sign+t.v+" overflows a 64 bit integer",
+			fmt.Sprintf("integers are signed 64 bit, from %v to %v", math.MinInt64, math.MaxInt64))
 	}
+	return &literal{position: p.at(t), value: v}
+}
 
-	if len(mainFunctions) > 1 {
-		definedMains := make([]string, 0, len(mainFunctions))
-		for _, f := range mainFunctions {
-			definedMains = append(definedMains, fmt.Sprintf("%v:%v", f.file, f.line))
-		}
-		return fmt.Errorf("%w: %v", errMultipleMains, definedMains)
+// isQualifiedCall reports if the name t, which is no variable, is followed by "." name "(", a call to
+// a function of another module since values have no functions of their own
+func (p *parser) isQualifiedCall(t token) bool {
+	if _, isDeclared := p.variables[t.v]; isDeclared || p.i+2 >= len(p.f.tkns) {
+		return false
 	}
+	next := p.f.tkns[p.i : p.i+3]
+	return next[0].t == tdot && next[1].t == tvariable && next[2].t == tlparenth
+}
 
-	// at the end of the parsing, collect all errors and return them
-	foundErrors := 0
-	for _, f := range functions {
-		if len(f.errs) > 0 {
-			for _, err := range f.errs {
-				log.Printf("%v:%v: %v", f.file, f.line, err)
+// parseCall parses the arguments of a call, the name and "(" were already read, and so was the module
+// of a qualified call, calls without one are to the functions of the same module
+func (p *parser) parseCall(name token, module *token) expr {
+	call := &callExpr{position: p.at(name), name: qualify(p.module, name.v)}
+	if module != nil {
+		call.position, call.name = p.at(*module), qualify(module.v, name.v)
+	}
+	if t, ok := p.peek(); ok && t.t == trparenth {
+		p.i++
+	} else {
+		for {
+			arg := p.parseExpr()
+			if arg == nil {
+				return nil
+			}
+			call.args = append(call.args, arg)
+
+			t, ok := p.next()
+			if ok && t.t == trparenth {
+				break
+			}
+			if !ok {
+				p.unexpectedEnd()
+				return nil
+			}
+			if t.t != tcomma {
+				p.unexpected()
+				return nil
 			}
-			foundErrors += len(f.errs)
 		}
 	}
-	if foundErrors > 0 {
-		return fmt.Errorf("%w %v found errors", errParse, foundErrors)
+
+	if module != nil {
+		if module.v != p.module && !p.imports[module.v] {
+			p.f.errorAt(*module, codeUndefinedFunction, "undefined function "+call.name,
+				"module "+module.v+" is not imported: import \""+module.v+"\"")
+			return call
+		}
+		return p.checkCall(call, name, module.v)
+	}
+	if name.v == builtinLen {
+		if len(call.args) != 1 {
+			p.f.errorAt(name, codeArgumentCount, fmt.Sprintf("len expects 1 argument, got %v", len(call.args)))
+			return call
+		}
+		return &lenExpr{position: call.position, operand: call.args[0]}
+	}
+	if to, isType := intTypes[name.v]; isType {
+		if len(call.args) != 1 {
+			p.f.errorAt(name, codeArgumentCount, fmt.Sprintf("cast to %v expects 1 argument, got %v", name.v, len(call.args)))
+			return call
+		}
+		cast := &castExpr{position: call.position, operand: call.args[0]}
+		cast.setType(to)
+		return cast
+	}
+	return p.checkCall(call, name, p.module)
+}
+
+// checkCall records an error for a call to a function that does not exist, is private to another
+// module or takes another number of arguments
+func (p *parser) checkCall(call *callExpr, name token, module string) expr {
+	decl, exists := p.functions[call.name]
+	switch {
+	case !exists:
+		p.f.errorAt(name, codeUndefinedFunction, "undefined function "+call.name)
+	case module != p.module && !decl.exported:
+		p.f.errorAt(name, codePrivateFunction, "function "+call.name+" is not exported",
+			fmt.Sprintf("%v is defined at %v:%v, declared as export %v(...) = ... other modules can call it", call.name, decl.file, decl.line, name.v))
+	case len(decl.params) != len(call.args):
+		p.f.errorAt(name, codeArgumentCount, fmt.Sprintf("function %v expects %v arguments, got %v", call.name, len(decl.params), len(call.args)),
+			fmt.Sprintf("%v is defined at %v:%v", call.name, decl.file, decl.line))
+	}
+	return call
+}
+
+// parseStructLit parses the fields of a struct literal, the name and "{" were already read,
+// which fields are given is up to check
+func (p *parser) parseStructLit(name token) expr {
+	typ, ok := p.types[name.v]
+	if !ok || typ.isInt() {
+		p.f.errorAt(name, codeUnknownType, "unknown struct "+name.v)
+		return nil
+	}
+	lit := &structLit{position: p.at(name)}
+	lit.setType(typ)
+	if t, ok := p.peek(); ok && t.t == trbrace {
+		p.i++
+		return lit
+	}
+	for {
+		t, ok := p.expect(tvariable)
+		if !ok {
+			return nil
+		}
+		if _, ok := p.expect(tcolon); !ok {
+			return nil
+		}
+		value := p.parseExpr()
+		if value == nil {
+			return nil
+		}
+		lit.fields = append(lit.fields, &fieldInit{position: p.at(t), name: t.v, value: value})
+
+		t, ok = p.next()
+		if ok && t.t == trbrace {
+			return lit
+		}
+		if !ok {
+			p.unexpectedEnd()
+			return nil
+		}
+		if t.t != tcomma {
+			p.unexpected()
+			return nil
+		}
+	}
+}
+
+// parseArrayLit parses the elements of an array literal, its type was already read,
+// how many are given is up to check
+func (p *parser) parseArrayLit(start token, typ *lwlType) expr {
+	if _, ok := p.expect(tlbrace); !ok {
+		return nil
+	}
+	lit := &arrayLit{position: p.at(start)}
+	lit.setType(typ)
+	if t, ok := p.peek(); ok && t.t == trbrace {
+		p.i++
+		return lit
+	}
+	for {
+		elem := p.parseExpr()
+		if elem == nil {
+			return nil
+		}
+		lit.elems = append(lit.elems, elem)
+
+		t, ok := p.next()
+		if ok && t.t == trbrace {
+			return lit
+		}
+		if !ok {
+			p.unexpectedEnd()
+			return nil
+		}
+		if t.t != tcomma {
+			p.unexpected()
+			return nil
+		}
 	}
-	return nil
 }
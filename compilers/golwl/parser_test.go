@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"errors"
-	"log"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -13,7 +15,7 @@ func Test_parse(t *testing.T) {
 		name      string
 		functions []function
 		wantErr   error
-		wantLog   string
+		wantDiag  string
 	}{
 		{
 			name: "valid function and main",
@@ -36,6 +38,8 @@ func Test_parse(t *testing.T) {
 					file: "test.lwl",
 					line: 2,
 					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
 						{t: tvariable, v: "f"},
 						{t: tlparenth, v: "("},
 						{t: tconstant, v: "1"},
@@ -72,6 +76,8 @@ func Test_parse(t *testing.T) {
 					file: "test1.lwl",
 					line: 1,
 					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
 						{t: tvariable, v: "f"},
 						{t: tlparenth, v: "("},
 						{t: tconstant, v: "1"},
@@ -84,6 +90,8 @@ func Test_parse(t *testing.T) {
 					file: "test2.lwl",
 					line: 1,
 					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
 						{t: tvariable, v: "g"},
 						{t: tlparenth, v: "("},
 						{t: tconstant, v: "2"},
@@ -128,6 +136,8 @@ func Test_parse(t *testing.T) {
 					file: "test.lwl",
 					line: 3,
 					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
 						{t: tvariable, v: "f"},
 						{t: tlparenth, v: "("},
 						{t: tconstant, v: "1"},
@@ -136,8 +146,8 @@ func Test_parse(t *testing.T) {
 					main: true,
 				},
 			},
-			wantErr: errParse,
-			wantLog: "already defined",
+			wantErr:  errParse,
+			wantDiag: "already defined",
 		},
 		{
 			name: "multiple equals in function",
@@ -162,6 +172,8 @@ func Test_parse(t *testing.T) {
 					file: "test.lwl",
 					line: 2,
 					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
 						{t: tvariable, v: "f"},
 						{t: tlparenth, v: "("},
 						{t: tconstant, v: "1"},
@@ -170,8 +182,8 @@ func Test_parse(t *testing.T) {
 					main: true,
 				},
 			},
-			wantErr: errParse,
-			wantLog: "multiple '='",
+			wantErr:  errParse,
+			wantDiag: "multiple '='",
 		},
 		{
 			name: "undefined variable",
@@ -194,6 +206,8 @@ func Test_parse(t *testing.T) {
 					file: "test.lwl",
 					line: 2,
 					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
 						{t: tvariable, v: "f"},
 						{t: tlparenth, v: "("},
 						{t: tconstant, v: "1"},
@@ -202,8 +216,8 @@ func Test_parse(t *testing.T) {
 					main: true,
 				},
 			},
-			wantErr: errParse,
-			wantLog: "undefined variable",
+			wantErr:  errParse,
+			wantDiag: "undefined variable",
 		},
 		{
 			name: "unexpected operator",
@@ -227,6 +241,8 @@ func Test_parse(t *testing.T) {
 					file: "test.lwl",
 					line: 2,
 					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
 						{t: tvariable, v: "f"},
 						{t: tlparenth, v: "("},
 						{t: tconstant, v: "1"},
@@ -235,40 +251,638 @@ func Test_parse(t *testing.T) {
 					main: true,
 				},
 			},
-			wantErr: errParse,
-			wantLog: "unexpected operator",
+			wantErr:  errParse,
+			wantDiag: "unexpected operator",
 		},
 		{
-			name: "main function not starting with variable or constant",
+			name: "main without '='",
 			functions: []function{
 				{
 					name: "",
 					file: "test.lwl",
 					line: 1,
 					tkns: []token{
-						{t: tadd, v: "+"}, // Should start with variable or constant
+						{t: tmain, v: "main"},
 						{t: tconstant, v: "1"},
 					},
 					main: true,
 				},
 			},
-			wantErr: errParse,
-			wantLog: "must start with a variable or constant",
+			wantErr:  errParse,
+			wantDiag: "main has no '='",
+		},
+		{
+			name: "a line of invalid tokens after a function without a name",
+			functions: []function{
+				{
+					file: "test.lwl",
+					line: 1,
+					tkns: []token{
+						{t: tlparenth, v: "(", line: 1, col: 1},
+						{t: tvariable, v: "x", line: 1, col: 2},
+						{t: trparenth, v: ")", line: 1, col: 3},
+						{t: teq, v: "=", line: 1, col: 5},
+						{t: tconstant, v: "1", line: 1, col: 7},
+					},
+				},
+				{
+					file: "test.lwl",
+					line: 2,
+					errs: []diagnostic{
+						{file: "test.lwl", line: 2, col: 1, endCol: 2, code: codeInvalidToken, msg: "invalid token: $"},
+					},
+				},
+				{
+					file: "test.lwl",
+					line: 3,
+					tkns: []token{
+						{t: tmain, v: "main", line: 3, col: 1},
+						{t: teq, v: "=", line: 3, col: 6},
+						{t: tconstant, v: "1", line: 3, col: 8},
+					},
+					main: true,
+				},
+			},
+			wantErr:  errParse,
+			wantDiag: "invalid token: $",
 		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
+			_, err := parse(tc.functions)
 			b := bytes.Buffer{}
-			originalOutput := log.Writer()
-			defer log.SetOutput(originalOutput)
-			log.SetOutput(&b) // TODO: make the logger parallel safe in unit tests
-			err := parse(tc.functions)
+			printDiagnostics(&b, collectDiagnostics(tc.functions))
 			if !errors.Is(err, tc.wantErr) {
 				t.Errorf("parse() error = %v, wantErr %v", err, tc.wantErr)
 			}
-			if !strings.Contains(b.String(), tc.wantLog) {
-				t.Errorf("log output = %v, want to contain %v", b.String(), tc.wantLog)
+			if !strings.Contains(b.String(), tc.wantDiag) {
+				t.Errorf("diagnostics = %v, want to contain %v", b.String(), tc.wantDiag)
+			}
+		})
+	}
+}
+
+// tokenizeSource writes the source into a temporary file and tokenizes it
+func tokenizeSource(t *testing.T, source string) []function {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "test.lwl")
+	if err := os.WriteFile(file, []byte(source), 0o600); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	functions, err := tokenize([]string{file})
+	if err != nil {
+		t.Fatalf("tokenize() error = %v", err)
+	}
+	return functions
+}
+
+func Test_parseTree(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		want     []string
+		wantErr  error
+		wantDiag string
+	}{
+		{
+			name:   "function declaration and call",
+			source: "f(x,y)=x+y\nmain = f(1,2)\n",
+			want:   []string{"f(x, y) = (+ x y)", "main = (f 1 2)"},
+		},
+		{
+			name:   "operators are left associative",
+			source: "main = 1+3+1\n",
+			want:   []string{"main = (+ (+ 1 3) 1)"},
+		},
+		{
+			name:   "multiplication binds tighter than addition",
+			source: "main = 1+2*3\n",
+			want:   []string{"main = (+ 1 (* 2 3))"},
+		},
+		{
+			name:   "parenthesis group",
+			source: "main = (1+2)*3\n",
+			want:   []string{"main = (* (+ 1 2) 3)"},
+		},
+		{
+			name:   "same precedence is left associative",
+			source: "main = 8/4*2-1+1%3\n",
+			want:   []string{"main = (+ (- (* (/ 8 4) 2) 1) (% 1 3))"},
+		},
+		{
+			name:   "nested parenthesis and calls",
+			source: "f(x)=x*(x+1)\nmain = ((f((2))))\n",
+			want:   []string{"f(x) = (* x (+ x 1))", "main = (f 2)"},
+		},
+		{
+			name:     "division by constant zero",
+			source:   "main = 1/0\n",
+			wantErr:  errParse,
+			wantDiag: "division by constant zero",
+		},
+		{
+			name:     "modulo by constant zero",
+			source:   "f(x)=(x+1)%0\nmain = f(1)\n",
+			wantErr:  errParse,
+			wantDiag: "division by constant zero",
+		},
+		{
+			name:     "unbalanced parenthesis",
+			source:   "main = (1+2\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected end of line after 2",
+		},
+		{
+			name:     "empty parenthesis",
+			source:   "main = 1+()\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected ')' after (",
+		},
+		{
+			name:   "nested calls",
+			source: "f(x)=x\ng(x,y)=f(x)+f(y)\nmain = g(f(1),2)\n",
+			want:   []string{"f(x) = x", "g(x, y) = (+ (f x) (f y))", "main = (g (f 1) 2)"},
+		},
+		{
+			name:   "function without parameters",
+			source: "f()=42\nmain = f()\n",
+			want:   []string{"f() = 42", "main = (f)"},
+		},
+		{
+			name:   "functions and variables are keyed on their full names",
+			source: "fo(x)=x\nfoo(xx,x)=fo(xx)*x\nmain = foo(fo(1),2)\n",
+			want:   []string{"fo(x) = x", "foo(xx, x) = (* (fo xx) x)", "main = (foo (fo 1) 2)"},
+		},
+		{
+			name:     "prefix of a variable is not declared",
+			source:   "f(xx)=x\nmain = f(1)\n",
+			wantErr:  errParse,
+			wantDiag: "undefined variable x",
+		},
+		{
+			name:     "wrong number of arguments",
+			source:   "f(x)=x\nmain = f(1,2)\n",
+			wantErr:  errParse,
+			wantDiag: "function f expects 1 arguments, got 2",
+		},
+		{
+			name:     "undefined function",
+			source:   "main = g(1)\n",
+			wantErr:  errParse,
+			wantDiag: "undefined function g",
+		},
+		{
+			name:     "function used as variable",
+			source:   "f(x)=x\nmain = f+1\n",
+			wantErr:  errParse,
+			wantDiag: "function f used as a variable",
+		},
+		{
+			name:     "unterminated call",
+			source:   "f(x)=x\nmain = f(1\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected end of line after 1",
+		},
+		{
+			name:     "duplicate parameter",
+			source:   "f(x,x)=x\nmain = f(1,2)\n",
+			wantErr:  errParse,
+			wantDiag: "parameter x already declared",
+		},
+		{
+			name:   "unary minus binds tighter than any operator",
+			source: "f(x)=-x*-2\nmain = -f(-(1))\n",
+			want:   []string{"f(x) = (* (- x) -2)", "main = (- (f (- 1)))"},
+		},
+		{
+			name:   "subtracting a negative constant",
+			source: "main = 3--2- -1\n",
+			want:   []string{"main = (- (- 3 -2) -1)"},
+		},
+		{
+			name:   "smallest integer",
+			source: "main = -9223372036854775808\n",
+			want:   []string{"main = -9223372036854775808"},
+		},
+		{
+			name:     "constant out of range",
+			source:   "main = 9223372036854775808\n",
+			wantErr:  errParse,
+			wantDiag: "constant 9223372036854775808 overflows a 64 bit integer",
+		},
+		{
+			name:     "negative constant out of range",
+			source:   "main = 1+-9223372036854775809\n",
+			wantErr:  errParse,
+			wantDiag: "constant -9223372036854775809 overflows a 64 bit integer",
+		},
+		{
+			name:   "type annotations and casts",
+			source: "f(x:u8, y):i32=i16(x)*y\nmain = f(1,2)\n",
+			want:   []string{"f(x:u8, y):i32 = (* (i16 x) y)", "main = (f 1 2)"},
+		},
+		{
+			name:     "unknown type",
+			source:   "f(x:int)=x\nmain = f(1)\n",
+			wantErr:  errParse,
+			wantDiag: "unknown type int",
+		},
+		{
+			name:     "missing type",
+			source:   "f(x):=x\nmain = f(1)\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected '=' after :",
+		},
+		{
+			name:     "function named after a type",
+			source:   "u8(x)=x\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "function u8 is named after a type",
+		},
+		{
+			name:     "cast of two values",
+			source:   "main = u8(1,2)\n",
+			wantErr:  errParse,
+			wantDiag: "cast to u8 expects 1 argument, got 2",
+		},
+		{
+			name:   "structs, literals and fields",
+			source: "struct point { x:i32, y }\nf(p:point):point = point{y: p.y, x: -p.x}\nmain = f(point{x: 1, y: 2}).x\n",
+			want:   []string{"f(p:point):point = point{y: (. p y), x: (- (. p x))}", "main = (. (f point{x: 1, y: 2}) x)"},
+		},
+		{
+			name:   "struct used before its declaration",
+			source: "f(p:point)=point{x: p.x}\nstruct point { x }\nmain = f(point{x: 1}).x\n",
+			want:   []string{"f(p:point) = point{x: (. p x)}", "main = (. (f point{x: 1}) x)"},
+		},
+		{
+			name:   "functions used before their definition",
+			source: "main = f(1)\nf(x)=g(x, x)+1\ng(x, y)=x*y\n",
+			want:   []string{"main = (f 1)", "f(x) = (+ (g x x) 1)", "g(x, y) = (* x y)"},
+		},
+		{
+			name:   "functions calling each other",
+			source: "even(n) = n == 0 ? 1 : odd(n - 1)\nodd(n) = n == 0 ? 0 : even(n - 1)\nmain = even(10)\n",
+			want:   []string{"even(n) = (? (== n 0) 1 (odd (- n 1)))", "odd(n) = (? (== n 0) 0 (even (- n 1)))", "main = (even 10)"},
+		},
+		{
+			name:     "calling a function defined later with the wrong arguments",
+			source:   "main = f(1, 2)\nf(x)=x\n",
+			wantErr:  errParse,
+			wantDiag: "function f expects 1 arguments, got 2",
+		},
+		{
+			name:     "function defined twice, the second one is reported",
+			source:   "main = f(1)\nf(x)=x\nf(y)=y\n",
+			wantErr:  errParse,
+			wantDiag: "test.lwl:3:1: error[L0002]: function f already defined",
+		},
+		{
+			name:     "struct declared twice",
+			source:   "struct p { x }\nstruct p { y }\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "struct p already defined",
+		},
+		{
+			name:     "struct named after an integer type",
+			source:   "struct u8 { x }\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "struct u8 is named after an integer type",
+		},
+		{
+			name:     "struct without fields",
+			source:   "struct p {}\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "struct p has no fields",
+		},
+		{
+			name:     "field declared twice",
+			source:   "struct p { x, y:u8, x:i8 }\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "field x already declared",
+		},
+		{
+			name:     "struct fields are integers",
+			source:   "struct p { x }\nstruct q { a:p }\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "field a of q must be an integer, got p",
+		},
+		{
+			name:     "literal of an unknown struct",
+			source:   "main = p{x: 1}.x\n",
+			wantErr:  errParse,
+			wantDiag: "unknown struct p",
+		},
+		{
+			name:     "field without a name",
+			source:   "struct p { x }\nmain = p{x: 1}.\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected end of line after .",
+		},
+		{
+			name:     "function named after a struct",
+			source:   "struct p { x }\np(x)=x\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "function p is named after a type",
+		},
+		{
+			name:   "arrays, literals, indices and lengths",
+			source: "f(a:[4]u8, i):[2]i8 = [2]i8{a[i], len(a)}\nmain = [4]u8{1, 2, 3, 4}[f([4]u8{}, 0)[1]]\n",
+			want:   []string{"f(a:[4]u8, i):[2]i8 = [2]i8{([] a i), (len a)}", "main = ([] [4]u8{1, 2, 3, 4} ([] (f [4]u8{} 0) 1))"},
+		},
+		{
+			name:     "array of length 0",
+			source:   "f(a:[0]u8)=1\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "array of length 0",
+		},
+		{
+			name:     "array too long",
+			source:   "f(a:[65537]u8)=1\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "array length 65537 is too big",
+		},
+		{
+			name:     "array elements are integers",
+			source:   "struct p { x }\nf(a:[2]p)=1\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "array elements must be integers, got p",
+		},
+		{
+			name:     "unterminated index",
+			source:   "main = [1]u8{1}[0\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected end of line after 0",
+		},
+		{
+			name:     "len of two values",
+			source:   "main = len(1, 2)\n",
+			wantErr:  errParse,
+			wantDiag: "len expects 1 argument, got 2",
+		},
+		{
+			name:     "function named after a builtin",
+			source:   "len(x)=x\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "function len is named after a builtin",
+		},
+		{
+			name:   "comparisons bind looser than arithmetic, all of them alike",
+			source: "f(x) = x + 1 < x * 2 == 1 != -x >= 0\nmain = f(1) <= 2\n",
+			want:   []string{"f(x) = (>= (!= (== (< (+ x 1) (* x 2)) 1) (- x)) 0)", "main = (<= (f 1) 2)"},
+		},
+		{
+			name:   "conditionals bind the loosest and to the right",
+			source: "f(x) = x > 0 ? x + 1 : x < 0 ? f(x == 0 ? 1 : 2) : 0\nmain = f(1) ? 2 : 3\n",
+			want:   []string{"f(x) = (? (> x 0) (+ x 1) (? (< x 0) (f (? (== x 0) 1 2)) 0))", "main = (? (f 1) 2 3)"},
+		},
+		{
+			name:     "conditional without else",
+			source:   "main = 1 ? 2\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected end of line after 2",
+		},
+		{
+			name:     "conditional with a second '?'",
+			source:   "main = 1 ? 2 ? 3\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected end of line after 3",
+		},
+		{
+			name:   "let binds a name within its body",
+			source: "f(x) = let y = x*x in y + y\nmain = f(let z:u8 = 2 in z)\n",
+			want:   []string{"f(x) = (let y (* x x) (+ y y))", "main = (f (let z:u8 2 z))"},
+		},
+		{
+			name:   "let takes as much as it can",
+			source: "main = 1 + let y = 2 in y * 3 ? let z = y in z : 4\n",
+			want:   []string{"main = (+ 1 (let y 2 (? (* y 3) (let z y z) 4)))"},
+		},
+		{
+			name:   "the value of a let does not see its own name",
+			source: "f(y) = let y = y + 1 in let z = y in y * z\nmain = f(1)\n",
+			want:   []string{"f(y) = (let y (+ y 1) (let z y (* y z)))", "main = (f 1)"},
+		},
+		{
+			name:     "let shadowing a parameter is a warning",
+			source:   "f(y) = let y = y + 1 in y\nmain = f(1)\n",
+			want:     []string{"f(y) = (let y (+ y 1) y)", "main = (f 1)"},
+			wantDiag: "warning[L0030]: let y shadows the variable y",
+		},
+		{
+			name:     "let is not seen outside of its body",
+			source:   "main = (let y = 1 in y) + y\n",
+			wantErr:  errParse,
+			wantDiag: "undefined variable y",
+		},
+		{
+			name:     "let without in",
+			source:   "main = let y = 1 y\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected variable after 1",
+		},
+		{
+			name:     "let without a name",
+			source:   "main = let = 1 in 2\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected '=' after let",
+		},
+		{
+			name:     "a second '=' besides the one of a let",
+			source:   "f(x) = let y = x = 1 in y\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "function f has multiple '='",
+		},
+		{
+			name:     "single '!' is no operator",
+			source:   "main = 1 ! 2\n",
+			wantErr:  errParse,
+			wantDiag: "invalid token: !",
+		},
+		{
+			name:     "expression outside of any function",
+			source:   "f(x)=x\n-f(1)\n",
+			wantErr:  errParse,
+			wantDiag: "expression outside of any function",
+		},
+		{
+			name:     "a call is no main without main =",
+			source:   "f(x)=x\nf(1)\n",
+			wantErr:  errParse,
+			wantDiag: "function f has no '='",
+		},
+		{
+			name:     "a definition without '=' is not a second main",
+			source:   "f(x) x+1\nmain = 1\n",
+			wantErr:  errParse,
+			wantDiag: "function f has no '='",
+		},
+		{
+			name:     "main takes no parameters",
+			source:   "main(x) = x\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected '(' after main",
+		},
+		{
+			name:     "main is a keyword",
+			source:   "f(main) = main\nmain = f(1)\n",
+			wantErr:  errParse,
+			wantDiag: "unexpected 'main' after (",
+		},
+		{
+			name:    "main defined twice",
+			source:  "main = 1\nmain = 2\n",
+			wantErr: errMultipleMains,
+		},
+		{
+			name:     "division by negative constant zero",
+			source:   "main = 1/-0\n",
+			wantErr:  errParse,
+			wantDiag: "division by constant zero",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			functions := tokenizeSource(t, tc.source)
+			decls, err := parse(functions)
+			b := bytes.Buffer{}
+			printDiagnostics(&b, collectDiagnostics(functions))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("parse() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !strings.Contains(b.String(), tc.wantDiag) {
+				t.Errorf("diagnostics = %v, want to contain %v", b.String(), tc.wantDiag)
+			}
+			got := make([]string, 0, len(decls))
+			for _, d := range decls {
+				got = append(got, d.String())
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("parse() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func Test_parsePositions(t *testing.T) {
+	decls, err := parse(tokenizeSource(t, "f(x, y) = x + y\n  main = f(1, 2)\n"))
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	body, ok := decls[0].body.(*binaryExpr)
+	if !ok {
+		t.Fatalf("body = %T, want *binaryExpr", decls[0].body)
+	}
+	if p := body.pos(); p.line != 1 || p.col != 13 {
+		t.Errorf("'+' position = %v:%v, want 1:13", p.line, p.col)
+	}
+	if p := body.rhs.pos(); p.line != 1 || p.col != 15 {
+		t.Errorf("'y' position = %v:%v, want 1:15", p.line, p.col)
+	}
+	if p := decls[1].body.pos(); p.line != 2 || p.col != 10 {
+		t.Errorf("call position = %v:%v, want 2:10", p.line, p.col)
+	}
+}
+
+func Test_parseFilesInAnyOrder(t *testing.T) {
+	dir := t.TempDir()
+	sources := map[string]string{
+		"main.lwl": "main = area(square(3))\n",
+		"shape.lwl": "square(side:u8):rect = rect{w: side, h: side}\n" +
+			"area(r:rect):u16 = u16(r.w) * r.h\n" +
+			"struct rect { w:u8, h:u8 }\n",
+	}
+	for name, source := range sources {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o600); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+	}
+
+	for _, files := range [][]string{{"main.lwl", "shape.lwl"}, {"shape.lwl", "main.lwl"}} {
+		t.Run(strings.Join(files, " "), func(t *testing.T) {
+			paths := make([]string, 0, len(files))
+			for _, f := range files {
+				paths = append(paths, filepath.Join(dir, f))
+			}
+			functions, err := tokenize(paths)
+			if err != nil {
+				t.Fatalf("tokenize() error = %v", err)
+			}
+			decls, err := parse(functions)
+			if err != nil {
+				t.Fatalf("parse() error = %v: %v", err, collectDiagnostics(functions))
+			}
+			if len(decls) != 3 {
+				t.Errorf("parse() = %v declarations, want 3", len(decls))
+			}
+		})
+	}
+}
+
+func Test_withEntry(t *testing.T) {
+	source := "one():u8 = 1\ntwo():u8 = one() + 1\nadd(x, y) = x + y\nmain = add(1, 2)\n"
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantRun int64
+		wantErr string
+	}{
+		{
+			name:    "main by default",
+			entry:   "main",
+			want:    []string{"one():u8 = 1", "two():u8 = (+ (one) 1)", "add(x, y) = (+ x y)", "main = (add 1 2)"},
+			wantRun: 3,
+		},
+		{
+			name:    "a function without parameters calling others",
+			entry:   "two",
+			want:    []string{"one():u8 = 1", "two():u8 = (+ (one) 1)", "add(x, y) = (+ x y)", "main = (two)"},
+			wantRun: 2,
+		},
+		{
+			name:    "undefined function",
+			entry:   "three",
+			wantErr: "invalid entry point: function three is not defined",
+		},
+		{
+			name:    "function with parameters",
+			entry:   "add",
+			wantErr: "invalid entry point: function add takes 2 parameters, it must take none",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			decls, err := parse(tokenizeSource(t, source))
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			decls, diags, err := withEntry(decls, tc.entry, "test.lwl")
+			if tc.wantErr != "" {
+				if err == nil || !errors.Is(err, errEntry) || err.Error() != tc.wantErr {
+					t.Fatalf("withEntry() error = %v, want %v", err, tc.wantErr)
+				}
+				if len(diags) != 1 || diags[0].code != codeInvalidEntry || diags[0].msg != tc.wantErr {
+					t.Errorf("withEntry() diagnostics = %v, want one %v: %v", diags, codeInvalidEntry, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("withEntry() error = %v", err)
+			}
+			got := make([]string, 0, len(decls))
+			for _, d := range decls {
+				got = append(got, d.String())
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("withEntry() = %q, want %q", got, tc.want)
+			}
+			checkTypes(t, decls)
+			if result, err := interpret(decls); err != nil || result != tc.wantRun {
+				t.Errorf("interpret() = %v, %v, want %v", result, err, tc.wantRun)
 			}
 		})
 	}
@@ -1,6 +1,12 @@
 package main
 
-import "strconv"
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
 
 // This is a pseudo-assembler for the LWL language.
 // It will create a generic pseudo-assembly code that can be later be thrown in different architectures.
@@ -9,18 +15,54 @@ type opset string
 
 const (
 	funcstart opset = "FUNC_START"
+	globalop  opset = "GLOBAL" // a label visible to the linker
 	retop     opset = "RET"
 	movop     opset = "MOV"
+	leaop     opset = "LEA" // loads the address of a memory operand
 	addop     opset = "ADD"
 	subop     opset = "SUB"
 	mulop     opset = "MUL"
 	divop     opset = "DIV"
+	modop     opset = "MOD"
+	udivop    opset = "UDIV"
+	umodop    opset = "UMOD"
+	negop     opset = "NEG"
+	movsxop   opset = "MOVSX" // sign extends the lower bits of a register, or a sized memory operand, into a whole register
+	movzxop   opset = "MOVZX" // zero extends the lower bits of a register, or a sized memory operand, into a whole register
 	pushop    opset = "PUSH"
 	popop     opset = "POP"
 	callop    opset = "CALL"
 	syscallop opset = "SYSCALL"
+	cmpop     opset = "CMP"    // compares the second operand to the first one, setting the flags
+	boundsop  opset = "BOUNDS" // exits with outOfRangeExitCode unless the register, as unsigned, is below the length
+	labelop   opset = "LABEL"  // a label only jumped to from within the function
+	jmpop     opset = "JMP"
+	jeop      opset = "JE" // jumps if the last CMP found both operands equal
+	// the SETs write 1 to the lower 8 bits of a register if the second operand of the last CMP is, compared
+	// to the first one: equal, not equal, less, less or equal, greater, greater or equal, and then below,
+	// below or equal, above, above or equal when compared as unsigned; 0 otherwise
+	seteop  opset = "SETE"
+	setneop opset = "SETNE"
+	setlop  opset = "SETL"
+	setleop opset = "SETLE"
+	setgop  opset = "SETG"
+	setgeop opset = "SETGE"
+	setbop  opset = "SETB"
+	setbeop opset = "SETBE"
+	setaop  opset = "SETA"
+	setaeop opset = "SETAE"
 )
 
+// opsets are every pseudo-assembly instruction
+var opsets = []opset{
+	funcstart, globalop, retop, movop, leaop, addop, subop, mulop, divop, modop, udivop, umodop,
+	negop, movsxop, movzxop, pushop, popop, callop, syscallop, cmpop, boundsop, labelop, jmpop, jeop,
+	seteop, setneop, setlop, setleop, setgop, setgeop, setbop, setbeop, setaop, setaeop,
+}
+
+// outOfRangeExitCode is what a program exits with when it indexes an array out of its range
+const outOfRangeExitCode = 134
+
 const (
 	rax = "RAX"
 	rbx = "RBX"
@@ -30,18 +72,104 @@ const (
 	rdi = "RDI"
 	rbp = "RBP"
 	rsp = "RSP"
+	r8  = "R8"
+	r9  = "R9"
 )
 
+// subRegisters are the names of the lower 32, 16 and 8 bits of the registers
+var subRegisters = map[string]map[int]string{
+	rax: {32: "EAX", 16: "AX", 8: "AL"},
+	rbx: {32: "EBX", 16: "BX", 8: "BL"},
+	rcx: {32: "ECX", 16: "CX", 8: "CL"},
+	rdx: {32: "EDX", 16: "DX", 8: "DL"},
+	rsi: {32: "ESI", 16: "SI", 8: "SIL"},
+	rdi: {32: "EDI", 16: "DI", 8: "DIL"},
+	r8:  {32: "R8D", 16: "R8W", 8: "R8B"},
+	r9:  {32: "R9D", 16: "R9W", 8: "R9B"},
+}
+
+// parseSubRegister returns the register and the number of bits of a name from subRegisters
+func parseSubRegister(s string) (string, int, bool) {
+	for r, sizes := range subRegisters {
+		for bits, name := range sizes {
+			if name == s {
+				return r, bits, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+// register is the name of the lower bits of r, or r itself for all of its 64 bits
+func register(r string, bits int) string {
+	if bits == 64 {
+		return r
+	}
+	return subRegisters[r][bits]
+}
+
+// argRegisters are the registers holding the first arguments of a call, in order, as the System V ABI says
+// any other argument goes on the stack
+var argRegisters = []string{rdi, rsi, rdx, rcx, r8, r9}
+
 func isRegister(s string) bool {
 	switch s {
-	case rax, rbx, rcx, rdx, rsi, rdi, rbp, rsp:
+	case rax, rbx, rcx, rdx, rsi, rdi, rbp, rsp, r8, r9:
 		return true
 	}
 	return false
 }
 
+// memory is the operand for the 8 bytes at base register + offset, written as [BASE+offset]
+func memory(base string, offset int) string {
+	return fmt.Sprintf("[%s%+d]", base, offset)
+}
+
+func isMemory(s string) bool {
+	_, _, ok := parseMemory(s)
+	return ok
+}
+
+// memoryWidths are the prefixes of memory operands narrower than 8 bytes
+var memoryWidths = map[int]string{8: "BYTE", 16: "WORD", 32: "DWORD"}
+
+// sizedMemory is the operand for the lower bits of the memory at base register + offset, as in DWORD [BASE+offset]
+func sizedMemory(bits int, base string, offset int) string {
+	return memoryWidths[bits] + " " + memory(base, offset)
+}
+
+// parseSizedMemory returns the bits and the memory operand of a sizedMemory
+func parseSizedMemory(s string) (int, string, bool) {
+	width, mem, ok := strings.Cut(s, " ")
+	if !ok || !isMemory(mem) {
+		return 0, "", false
+	}
+	for bits, w := range memoryWidths {
+		if w == width {
+			return bits, mem, true
+		}
+	}
+	return 0, "", false
+}
+
+func parseMemory(s string) (string, int, bool) {
+	if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+		return "", 0, false
+	}
+	s = s[1 : len(s)-1]
+	i := strings.IndexAny(s, "+-")
+	if i < 0 || !isRegister(s[:i]) {
+		return "", 0, false
+	}
+	offset, err := strconv.Atoi(s[i:])
+	if err != nil {
+		return "", 0, false
+	}
+	return s[:i], offset, true
+}
+
 func isConstant(s string) bool {
-	_, err := strconv.Atoi(s)
+	_, err := strconv.ParseInt(s, 10, 64)
 	return err == nil
 }
 
@@ -50,100 +178,640 @@ type instruction struct {
 	args   []string
 }
 
-func passemble(functions []function) []instruction {
-	instructions := []instruction{}
-	for _, f := range functions {
-		// prologue
-		name := f.name
+func (i instruction) String() string {
+	if i.opcode == funcstart || i.opcode == globalop {
+		return string(i.opcode) + " " + strings.Join(i.args, ", ")
+	}
+	return strings.TrimRight("    "+string(i.opcode)+" "+strings.Join(i.args, ", "), " ")
+}
+
+// dumpIR writes every instruction in its own line
+func dumpIR(w io.Writer, instructions []instruction) error {
+	for _, i := range instructions {
+		if _, err := fmt.Fprintln(w, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// binaryOps maps the operators to the instruction applying them as: op RBX, RAX => RAX = RAX op RBX
+// DIV and MOD are signed and clobber RDX on the way, every operation wraps around on overflow
+// and dividing the smallest integer by -1 results in itself, with a remainder of 0,
+// unsigned types divide with UDIV and UMOD instead
+var binaryOps = map[tokenType]opset{
+	tadd: addop,
+	tsub: subop,
+	tmul: mulop,
+	tdiv: divop,
+	tmod: modop,
+}
+
+// signedSetOps and unsignedSetOps map the comparisons to the instruction setting whether they hold
+// after CMP RBX, RAX, which compares RAX to RBX
+var (
+	signedSetOps   = map[tokenType]opset{teqeq: seteop, tneq: setneop, tlt: setlop, tle: setleop, tgt: setgop, tge: setgeop}
+	unsignedSetOps = map[tokenType]opset{teqeq: seteop, tneq: setneop, tlt: setbop, tle: setbeop, tgt: setaop, tge: setaeop}
+)
+
+// mangle returns the label of a function, prefixed so it never clashes with
+// the assembler keywords, registers or our own _start, the functions of a module
+// keep its name so they never clash either: lwl_math.sq
+func mangle(name string) string {
+	return "lwl_" + name
+}
+
+// assembler holds the state needed to lower the body of a single function
+type assembler struct {
+	instructions []instruction
+	functions    map[string]*funcDecl
+	decl         *funcDecl
+	params       []location          // where the caller left each parameter
+	slots        map[node]int        // RBP offset of the frame slot of each struct or array value and parameter kept in it, and of each let
+	lets         map[string]*letExpr // the lets around the expression being lowered by the name they bind
+	frame        int                 // bytes of the slots, right below the saved RBX
+	resultSlot   int                 // RBP offset of the address a struct result is written to, 0 if returned in registers
+	export       bool                // export every function for the linker
+	labels       int                 // local labels made so far, numbering the next one
+}
+
+func (a *assembler) emit(opcode opset, args ...string) {
+	a.instructions = append(a.instructions, instruction{opcode: opcode, args: args})
+}
+
+// label makes a new local label, named as GAS names the labels it leaves out of the symbol table
+func (a *assembler) label() string {
+	a.labels++
+	return ".L" + strconv.Itoa(a.labels)
+}
+
+func passemble(decls []*funcDecl) ([]instruction, error) {
+	a := newAssembler(decls)
+	for _, f := range decls {
+		if err := a.function(f); err != nil {
+			return nil, err
+		}
+	}
+	return a.instructions, nil
+}
+
+// passembleLibrary lowers every function but main, each one of the main module exported under
+// its own name so it can be called from C following the System V ABI
+func passembleLibrary(decls []*funcDecl) ([]instruction, error) {
+	a := newAssembler(decls)
+	a.export = true
+	for _, f := range decls {
+		if f.main {
+			continue
+		}
+		if err := a.function(f); err != nil {
+			return nil, err
+		}
+	}
+	return a.instructions, nil
+}
+
+func newAssembler(decls []*funcDecl) *assembler {
+	a := &assembler{functions: make(map[string]*funcDecl)}
+	for _, d := range decls {
+		if !d.main {
+			a.functions[d.name] = d
+		}
+	}
+	return a
+}
+
+// location is where an argument is passed: in registers, or on the stack at offset bytes
+// from the one right above the return address
+type location struct {
+	registers []string
+	offset    int
+}
+
+// classify places the parameters of f as the System V ABI does: an integer takes an argument register
+// and a struct of up to 16 bytes one for each of its eightbytes, as long as enough of them are left,
+// anything else goes on the stack in order, taking a whole number of eightbytes. A struct result that
+// does not fit in RAX and RDX is written to the memory the caller points to in RDI, the first register.
+func classify(f *funcDecl) (params []location, hidden bool, stack int) {
+	next := 0
+	if t := f.resultType(); !t.isInt() && t.eightbytes() == 0 {
+		hidden = true
+		next++
+	}
+	for _, p := range f.params {
+		t := p.exprType()
+		n := 1
+		if !t.isInt() {
+			n = t.eightbytes()
+		}
+		if n > 0 && next+n <= len(argRegisters) {
+			params = append(params, location{registers: argRegisters[next : next+n]})
+			next += n
+			continue
+		}
+		params = append(params, location{offset: stack})
+		stack += eightbytesOf(t.size)
+	}
+	return params, hidden, stack
+}
+
+// eightbytesOf rounds size up to a whole number of eightbytes
+func eightbytesOf(size int) int {
+	return (size + 7) / 8 * 8
+}
+
+// layout gives a frame slot to every struct and array value of the function: literals, the results of
+// calls and the parameters passed in registers, which are kept in memory as any other struct or array
+func (a *assembler) layout(f *funcDecl) {
+	a.slots = make(map[node]int)
+	a.lets = make(map[string]*letExpr)
+	a.frame, a.resultSlot = 0, 0
+	allocate := func(size int) int {
+		a.frame += eightbytesOf(size)
+		return -8 - a.frame
+	}
+	var hidden bool
+	a.params, hidden, _ = classify(f)
+	if hidden {
+		a.resultSlot = allocate(8)
+	}
+	for i, p := range f.params {
+		if t := p.exprType(); !t.isInt() && len(a.params[i].registers) > 0 {
+			a.slots[p] = allocate(t.size)
+		}
+	}
+	walk(f.body, func(e expr) {
+		switch e.(type) {
+		case *structLit, *arrayLit, *callExpr:
+			if t := e.exprType(); !t.isInt() {
+				a.slots[e] = allocate(t.size)
+			}
+		case *letExpr:
+			// the value of an integer, or the address of a struct or an array, which stays in its own slot
+			a.slots[e] = allocate(8)
+		}
+	})
+}
+
+func (a *assembler) function(f *funcDecl) error {
+	a.decl = f
+	a.layout(f)
+	// prologue
+	name := mangle(f.name)
+	if f.main {
+		name = "_start"
+	}
+	// only the functions of the main module make up the library, the ones of its modules are its own
+	public := a.export && !f.main && f.module() == ""
+	if public {
+		a.emit(globalop, f.name)
+	}
+	a.emit(funcstart, name)
+	if !f.main {
+		// RBX is our scratch register but the ABI wants it preserved for the caller
+		a.emit(pushop, rbp)
+		a.emit(movop, rsp, rbp)
+		a.emit(pushop, rbx)
+	}
+	if a.frame > 0 {
+		// the slots go right below RBX, main never returns so it does not bother saving anything
 		if f.main {
-			name = "_start"
-		}
-		instructions = append(instructions,
-			instruction{opcode: funcstart, args: []string{name}},
-		)
-		if !f.main {
-			instructions = append(instructions,
-				instruction{opcode: pushop, args: []string{rbp}},
-				instruction{opcode: movop, args: []string{rbp, rsp}},
-			)
-		}
-		// TODO: figure out MOD operator
-		// TODO: figure out function calls
-		// TODO: figure out most things...
-
-		// body
-
-		// TODO: this part must be done in the AST parsing, but this is a dummy test implementation to see something end-to-end
-		// since this only supports adding constants, we know it will always be const + const + const + ...
-
-		i := 0
-		for {
-			if i >= len(f.tkns) {
-				break
+			a.emit(movop, rsp, rbp)
+			a.emit(subop, strconv.Itoa(8+a.frame), rsp)
+		} else {
+			a.emit(subop, strconv.Itoa(a.frame), rsp)
+		}
+	}
+	if a.resultSlot != 0 {
+		a.emit(movop, rdi, memory(rbp, a.resultSlot))
+	}
+	for i, p := range f.params {
+		if slot, ok := a.slots[p]; ok {
+			for j, r := range a.params[i].registers {
+				a.emit(movop, r, memory(rbp, slot+8*j))
+			}
+		}
+	}
+	if public {
+		// C callers leave the upper bits of narrow arguments undefined, we always keep them extended
+		for _, p := range f.params {
+			if !p.exprType().isInt() {
+				continue
 			}
-			t := f.tkns[i]
-			// test: only know how to handle adding N numbers right now
-			if t.t != tconstant && t.t != tadd {
+			param, _ := a.operand(p)
+			if !isRegister(param) {
+				a.emit(movop, param, rax)
+				a.extend(p.exprType(), rax)
+				a.emit(movop, rax, param)
 				continue
 			}
+			a.extend(p.exprType(), param)
+		}
+	}
+	// TODO: figure out most things...
 
-			// a + b // TODO: this should really be done at AST level
-			if t.t == tconstant {
-				instructions = append(instructions, instruction{opcode: movop, args: []string{t.v, rax}})
-				i++
-				if i >= len(f.tkns) { // TODO: should never happen, but need AST handling for that
-					break
-				}
-				if f.tkns[i].t != tadd {
-					continue
-				}
-				i++
-				if i >= len(f.tkns) { // TODO: should never happen, but need AST handling for that
-					break
-				}
-				t = f.tkns[i]
-				if t.t != tconstant {
-					continue
-				}
-				instructions = append(instructions,
-					instruction{opcode: movop, args: []string{t.v, rbx}},
-					instruction{opcode: addop, args: []string{rbx, rax}},
-				)
+	// body: the result of the expression is always left in RAX
+	if err := a.expr(f.body); err != nil {
+		return err
+	}
+
+	// epilogue
+	if f.main {
+		a.emit(movop, rax, rdi)
+		a.emit(movop, "60", rax)
+		a.emit(syscallop)
+	} else {
+		a.result(f.resultType())
+		if a.frame > 0 {
+			a.emit(addop, strconv.Itoa(a.frame), rsp)
+		}
+		a.emit(popop, rbx)
+		a.emit(popop, rbp)
+		a.emit(retop)
+	}
+	return nil
+}
+
+// result returns the struct at the address in RAX: in RAX and RDX when it fits in them,
+// otherwise copied to where the caller asked for, returning that address in RAX
+func (a *assembler) result(t *lwlType) {
+	switch {
+	case t.isInt():
+	case t.eightbytes() == 0:
+		a.emit(movop, memory(rbp, a.resultSlot), rdi)
+		a.copy(rax, rdi, t.size)
+		a.emit(movop, rdi, rax)
+	default:
+		if t.eightbytes() == 2 {
+			a.emit(movop, memory(rax, 8), rdx)
+		}
+		a.emit(movop, memory(rax, 0), rax)
+	}
+}
+
+// copy copies size bytes from the memory at the address in src to the one at the address in dst through RBX,
+// eight bytes at a time and then the narrower leftovers
+func (a *assembler) copy(src, dst string, size int) {
+	for offset := 0; offset < size; {
+		bits := 64
+		for offset+bits/8 > size {
+			bits /= 2
+		}
+		if bits == 64 {
+			a.emit(movop, memory(src, offset), rbx)
+		} else {
+			a.emit(movzxop, sizedMemory(bits, src, offset), rbx)
+		}
+		a.emit(movop, register(rbx, bits), memory(dst, offset))
+		offset += bits / 8
+	}
+}
+
+// load reads the value of type t at the memory base register + offset into the register r
+func (a *assembler) load(t *lwlType, base string, offset int, r string) {
+	switch {
+	case t.bits == 64:
+		a.emit(movop, memory(base, offset), r)
+	case t.signed:
+		a.emit(movsxop, sizedMemory(t.bits, base, offset), r)
+	default:
+		a.emit(movzxop, sizedMemory(t.bits, base, offset), r)
+	}
+}
+
+// expr lowers the expression e leaving its result in RAX, for a struct or an array its address
+func (a *assembler) expr(e expr) error {
+	if operand, ok := a.operand(e); ok {
+		a.emit(movop, operand, rax)
+		return nil
+	}
+	switch e := e.(type) {
+	case *binaryExpr:
+		if isComparison(e.op) {
+			return a.comparison(e)
+		}
+		op, ok := binaryOps[e.op]
+		if !ok {
+			break
+		}
+		unsigned := !e.exprType().signed
+		switch {
+		case op == divop && unsigned:
+			op = udivop
+		case op == modop && unsigned:
+			op = umodop
+		}
+		if err := a.operands(e.lhs, e.rhs); err != nil {
+			return err
+		}
+		// RDX might be holding one of our parameters
+		clobbersParam := (op == divop || op == modop || op == udivop || op == umodop) && slices.Contains(a.paramRegisters(), rdx)
+		if clobbersParam {
+			a.emit(pushop, rdx)
+		}
+		a.emit(op, rbx, rax)
+		if clobbersParam {
+			a.emit(popop, rdx)
+		}
+		a.extend(e.exprType(), rax)
+		return nil
+	case *unaryExpr:
+		if e.op != tsub {
+			break
+		}
+		if err := a.expr(e.operand); err != nil {
+			return err
+		}
+		a.emit(negop, rax)
+		a.extend(e.exprType(), rax)
+		return nil
+	case *castExpr:
+		if err := a.expr(e.operand); err != nil {
+			return err
+		}
+		a.extend(e.exprType(), rax)
+		return nil
+	case *condExpr:
+		return a.conditional(e)
+	case *callExpr:
+		return a.call(e)
+	case *letExpr:
+		return a.let(e)
+	case *ident:
+		if l, ok := a.lets[e.name]; ok {
+			a.emit(movop, memory(rbp, a.slots[l]), rax)
+			return nil
+		}
+		for i, p := range a.decl.params {
+			if p.name != e.name {
+				continue
+			}
+			if slot, ok := a.slots[p]; ok {
+				a.emit(leaop, memory(rbp, slot), rax)
+			} else {
+				a.emit(leaop, memory(rbp, 16+a.params[i].offset), rax)
+			}
+			return nil
+		}
+	case *fieldExpr:
+		if err := a.expr(e.operand); err != nil {
+			return err
+		}
+		a.load(e.field.typ, rax, e.field.offset, rax)
+		return nil
+	case *structLit:
+		slot := a.slots[e]
+		for _, f := range e.fields {
+			if err := a.expr(f.value); err != nil {
+				return err
+			}
+			a.emit(movop, register(rax, f.field.typ.bits), memory(rbp, slot+f.field.offset))
+		}
+		a.emit(leaop, memory(rbp, slot), rax)
+		return nil
+	case *arrayLit:
+		slot := a.slots[e]
+		elem := e.exprType().elem
+		for i, v := range e.elems {
+			if err := a.expr(v); err != nil {
+				return err
 			}
+			a.emit(movop, register(rax, elem.bits), memory(rbp, slot+i*elem.size))
+		}
+		a.emit(leaop, memory(rbp, slot), rax)
+		return nil
+	case *indexExpr:
+		return a.index(e)
+	}
+	p := e.pos()
+	return fmt.Errorf("%v:%v:%v: unsupported expression in function %v", p.file, p.line, p.col, a.decl.name)
+}
+
+// operands leaves the result of lhs in RAX and the one of rhs in RBX, a simple rhs goes straight into RBX
+// while anything else is computed first and kept on the stack while lhs is computed
+func (a *assembler) operands(lhs, rhs expr) error {
+	if operand, ok := a.operand(rhs); ok {
+		if err := a.expr(lhs); err != nil {
+			return err
+		}
+		a.emit(movop, operand, rbx)
+		return nil
+	}
+	if err := a.expr(rhs); err != nil {
+		return err
+	}
+	a.emit(pushop, rax)
+	if err := a.expr(lhs); err != nil {
+		return err
+	}
+	a.emit(popop, rbx)
+	return nil
+}
+
+// comparison leaves 1 in RAX if the comparison e holds and 0 otherwise, which is a value of any integer type
+func (a *assembler) comparison(e *binaryExpr) error {
+	if err := a.operands(e.lhs, e.rhs); err != nil {
+		return err
+	}
+	set := signedSetOps[e.op]
+	if !e.comparesSigned() {
+		set = unsignedSetOps[e.op]
+	}
+	a.emit(cmpop, rbx, rax)
+	a.emit(set, register(rax, 8))
+	a.emit(movzxop, register(rax, 8), rax)
+	return nil
+}
+
+// conditional lowers the condition and jumps over the branch it does not pick, both branches leave
+// their result in RAX already extended to the type of e since it holds every value of theirs
+func (a *assembler) conditional(e *condExpr) error {
+	els, end := a.label(), a.label()
+	if err := a.expr(e.cond); err != nil {
+		return err
+	}
+	a.emit(cmpop, "0", rax)
+	a.emit(jeop, els)
+	if err := a.expr(e.then); err != nil {
+		return err
+	}
+	a.emit(jmpop, end)
+	a.emit(labelop, els)
+	if err := a.expr(e.els); err != nil {
+		return err
+	}
+	a.emit(labelop, end)
+	return nil
+}
+
+// let keeps the value of e in its slot while its body is lowered, hiding whatever its name was before
+func (a *assembler) let(e *letExpr) error {
+	if err := a.expr(e.value); err != nil {
+		return err
+	}
+	a.emit(movop, rax, memory(rbp, a.slots[e]))
+	outer, shadows := a.lets[e.name.name]
+	a.lets[e.name.name] = e
+	err := a.expr(e.body)
+	if shadows {
+		a.lets[e.name.name] = outer
+	} else {
+		delete(a.lets, e.name.name)
+	}
+	return err
+}
+
+// index reads an element of an array, a constant index was already checked to be within it
+// so it goes straight into the offset, any other one is checked before reading the memory
+func (a *assembler) index(e *indexExpr) error {
+	t := e.operand.exprType()
+	if i, ok := constantIndex(e.index); ok {
+		if err := a.expr(e.operand); err != nil {
+			return err
+		}
+		a.load(t.elem, rax, int(i)*t.elem.size, rax)
+		return nil
+	}
+	// just like the right side of a binary expression, the index ends up in RBX
+	if err := a.operands(e.operand, e.index); err != nil {
+		return err
+	}
+	a.emit(boundsop, strconv.Itoa(t.length), rbx)
+	if t.elem.size > 1 {
+		a.emit(mulop, strconv.Itoa(t.elem.size), rbx)
+	}
+	a.emit(addop, rbx, rax)
+	a.load(t.elem, rax, 0, rax)
+	return nil
+}
+
+// extend makes the whole register r hold the value of type t in its lower bits, the only way
+// a narrow value can overflow is by computing it in a wider register
+func (a *assembler) extend(t *lwlType, r string) {
+	if t.bits == 64 {
+		return
+	}
+	if t.signed {
+		a.emit(movsxop, subRegisters[r][t.bits], r)
+	} else {
+		a.emit(movzxop, subRegisters[r][t.bits], r)
+	}
+}
 
-			// (had previously ADD in rax) + c
-			if t.t == tadd {
-				i++
-				if i >= len(f.tkns) { // TODO: should never happen, but need AST handling for that
-					break
-				}
-				t = f.tkns[i]
-				if t.t != tconstant {
-					continue
-				}
-				instructions = append(instructions,
-					instruction{opcode: movop, args: []string{t.v, rbx}},
-					instruction{opcode: addop, args: []string{rbx, rax}},
-				)
+// operand returns where the value of e can be read from without computing anything, if possible
+func (a *assembler) operand(e expr) (string, bool) {
+	switch e := e.(type) {
+	case *literal:
+		return strconv.FormatInt(e.value, 10), true
+	case *lenExpr:
+		return strconv.Itoa(e.length), true
+	case *ident:
+		if l, ok := a.lets[e.name]; ok {
+			if !l.value.exprType().isInt() {
+				return "", false
+			}
+			return memory(rbp, a.slots[l]), true
+		}
+		for i, p := range a.decl.params {
+			if p.name != e.name || !p.exprType().isInt() {
+				continue
+			}
+			if loc := a.params[i]; len(loc.registers) > 0 {
+				return loc.registers[0], true
 			}
+			// above the saved RBP and the return address lay the arguments pushed by the caller
+			return memory(rbp, 16+a.params[i].offset), true
+		}
+	}
+	return "", false
+}
 
-			i++
+// paramRegisters are the argument registers holding the integer parameters of the current function,
+// the structs were already moved to the frame
+func (a *assembler) paramRegisters() []string {
+	registers := make([]string, 0, len(argRegisters))
+	for i, p := range a.decl.params {
+		if loc := a.params[i]; p.exprType().isInt() && len(loc.registers) > 0 {
+			registers = append(registers, loc.registers[0])
 		}
+	}
+	return registers
+}
 
-		// epilogue
-		if f.main {
-			instructions = append(instructions,
-				instruction{opcode: movop, args: []string{rax, rdi}},
-				instruction{opcode: movop, args: []string{"60", rax}},
-				instruction{opcode: syscallop, args: []string{}},
-			)
-		} else {
-			instructions = append(instructions,
-				instruction{opcode: popop, args: []string{rbp}},
-				instruction{opcode: retop, args: []string{}},
-			)
+// call lowers a function call following the System V ABI, as classify places the arguments:
+//   - the ones going in registers are popped into them right before the call
+//   - the others are pushed on the stack in reverse order and popped by the caller after the call
+//   - the result comes back in RAX, a struct in RAX and RDX or in the memory given in RDI
+//
+// TODO: keep the stack 16 byte aligned on calls, only matters once we call code we did not compile
+func (a *assembler) call(c *callExpr) error {
+	f := a.functions[c.name]
+	params, hidden, stack := classify(f)
+
+	// our own parameters live in the argument registers and the callee is free to clobber them
+	saved := a.paramRegisters()
+	for _, r := range saved {
+		a.emit(pushop, r)
+	}
 
+	// compute every argument before touching the argument registers, since computing
+	// them might need our own parameters, the ones going on the stack are laid out first
+	// so the ones for the registers are on top
+	for i := len(c.args) - 1; i >= 0; i-- {
+		if len(params[i].registers) > 0 {
+			continue
+		}
+		if err := a.expr(c.args[i]); err != nil {
+			return err
+		}
+		if t := c.args[i].exprType(); !t.isInt() {
+			size := eightbytesOf(t.size)
+			a.emit(subop, strconv.Itoa(size), rsp)
+			a.copy(rax, rsp, size)
+			continue
+		}
+		a.emit(pushop, rax)
+	}
+	for i := len(c.args) - 1; i >= 0; i-- {
+		if len(params[i].registers) == 0 {
+			continue
+		}
+		if err := a.expr(c.args[i]); err != nil {
+			return err
+		}
+		if c.args[i].exprType().isInt() {
+			a.emit(pushop, rax)
+			continue
+		}
+		for j := len(params[i].registers) - 1; j >= 0; j-- {
+			a.emit(movop, memory(rax, 8*j), rbx)
+			a.emit(pushop, rbx)
+		}
+	}
+	for _, loc := range params {
+		for _, r := range loc.registers {
+			a.emit(popop, r)
 		}
 	}
-	return instructions
+	if hidden {
+		a.emit(leaop, memory(rbp, a.slots[c]), rdi)
+	}
+	a.emit(callop, mangle(c.name))
+	if stack > 0 {
+		a.emit(addop, strconv.Itoa(stack), rsp)
+	}
+	if t := f.resultType(); !t.isInt() && !hidden {
+		// keep the struct in our frame, RDX might be one of the saved registers
+		slot := a.slots[c]
+		a.emit(movop, rax, memory(rbp, slot))
+		if t.eightbytes() == 2 {
+			a.emit(movop, rdx, memory(rbp, slot+8))
+		}
+		a.emit(leaop, memory(rbp, slot), rax)
+	}
+
+	for i := len(saved) - 1; i >= 0; i-- {
+		a.emit(popop, saved[i])
+	}
+	return nil
 }
@@ -1 +1,219 @@
 package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func Test_passemble(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []instruction
+	}{
+		{
+			name:   "adding constants in main",
+			source: "main = 1+3+1\n",
+			want: []instruction{
+				{opcode: funcstart, args: []string{"_start"}},
+				{opcode: movop, args: []string{"1", rax}},
+				{opcode: movop, args: []string{"3", rbx}},
+				{opcode: addop, args: []string{rbx, rax}},
+				{opcode: movop, args: []string{"1", rbx}},
+				{opcode: addop, args: []string{rbx, rax}},
+				{opcode: movop, args: []string{rax, rdi}},
+				{opcode: movop, args: []string{"60", rax}},
+				{opcode: syscallop, args: []string{}},
+			},
+		},
+		{
+			name:   "right side is computed first and kept on the stack",
+			source: "main = 2*(3+4)\n",
+			want: []instruction{
+				{opcode: funcstart, args: []string{"_start"}},
+				{opcode: movop, args: []string{"3", rax}},
+				{opcode: movop, args: []string{"4", rbx}},
+				{opcode: addop, args: []string{rbx, rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: movop, args: []string{"2", rax}},
+				{opcode: popop, args: []string{rbx}},
+				{opcode: mulop, args: []string{rbx, rax}},
+				{opcode: movop, args: []string{rax, rdi}},
+				{opcode: movop, args: []string{"60", rax}},
+				{opcode: syscallop, args: []string{}},
+			},
+		},
+		{
+			name:   "function call",
+			source: "f(x,y)=x+y\nmain = f(1,2)\n",
+			want: []instruction{
+				{opcode: funcstart, args: []string{"lwl_f"}},
+				{opcode: pushop, args: []string{rbp}},
+				{opcode: movop, args: []string{rsp, rbp}},
+				{opcode: pushop, args: []string{rbx}},
+				{opcode: movop, args: []string{rdi, rax}},
+				{opcode: movop, args: []string{rsi, rbx}},
+				{opcode: addop, args: []string{rbx, rax}},
+				{opcode: popop, args: []string{rbx}},
+				{opcode: popop, args: []string{rbp}},
+				{opcode: retop, args: []string{}},
+				{opcode: funcstart, args: []string{"_start"}},
+				{opcode: movop, args: []string{"2", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: movop, args: []string{"1", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: popop, args: []string{rdi}},
+				{opcode: popop, args: []string{rsi}},
+				{opcode: callop, args: []string{"lwl_f"}},
+				{opcode: movop, args: []string{rax, rdi}},
+				{opcode: movop, args: []string{"60", rax}},
+				{opcode: syscallop, args: []string{}},
+			},
+		},
+		{
+			name:   "arguments beyond the sixth go on the stack",
+			source: "f(a,b,c,d,e,g,h,i)=i\nmain = f(1,2,3,4,5,6,7,8)\n",
+			want: []instruction{
+				{opcode: funcstart, args: []string{"lwl_f"}},
+				{opcode: pushop, args: []string{rbp}},
+				{opcode: movop, args: []string{rsp, rbp}},
+				{opcode: pushop, args: []string{rbx}},
+				{opcode: movop, args: []string{"[RBP+24]", rax}},
+				{opcode: popop, args: []string{rbx}},
+				{opcode: popop, args: []string{rbp}},
+				{opcode: retop, args: []string{}},
+				{opcode: funcstart, args: []string{"_start"}},
+				{opcode: movop, args: []string{"8", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: movop, args: []string{"7", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: movop, args: []string{"6", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: movop, args: []string{"5", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: movop, args: []string{"4", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: movop, args: []string{"3", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: movop, args: []string{"2", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: movop, args: []string{"1", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: popop, args: []string{rdi}},
+				{opcode: popop, args: []string{rsi}},
+				{opcode: popop, args: []string{rdx}},
+				{opcode: popop, args: []string{rcx}},
+				{opcode: popop, args: []string{r8}},
+				{opcode: popop, args: []string{r9}},
+				{opcode: callop, args: []string{"lwl_f"}},
+				{opcode: addop, args: []string{"16", rsp}},
+				{opcode: movop, args: []string{rax, rdi}},
+				{opcode: movop, args: []string{"60", rax}},
+				{opcode: syscallop, args: []string{}},
+			},
+		},
+		{
+			name:   "comparisons set a byte and conditionals jump over the branch not taken",
+			source: "main = 2 < 3 ? 4 : 5\n",
+			want: []instruction{
+				{opcode: funcstart, args: []string{"_start"}},
+				{opcode: movop, args: []string{"2", rax}},
+				{opcode: movop, args: []string{"3", rbx}},
+				{opcode: cmpop, args: []string{rbx, rax}},
+				{opcode: setlop, args: []string{"AL"}},
+				{opcode: movzxop, args: []string{"AL", rax}},
+				{opcode: cmpop, args: []string{"0", rax}},
+				{opcode: jeop, args: []string{".L1"}},
+				{opcode: movop, args: []string{"4", rax}},
+				{opcode: jmpop, args: []string{".L2"}},
+				{opcode: labelop, args: []string{".L1"}},
+				{opcode: movop, args: []string{"5", rax}},
+				{opcode: labelop, args: []string{".L2"}},
+				{opcode: movop, args: []string{rax, rdi}},
+				{opcode: movop, args: []string{"60", rax}},
+				{opcode: syscallop, args: []string{}},
+			},
+		},
+		{
+			name:   "lets are kept in a frame slot their body reads from",
+			source: "f(x) = let y = x * x in y + y\nmain = f(3)\n",
+			want: []instruction{
+				{opcode: funcstart, args: []string{"lwl_f"}},
+				{opcode: pushop, args: []string{rbp}},
+				{opcode: movop, args: []string{rsp, rbp}},
+				{opcode: pushop, args: []string{rbx}},
+				{opcode: subop, args: []string{"8", rsp}},
+				{opcode: movop, args: []string{rdi, rax}},
+				{opcode: movop, args: []string{rdi, rbx}},
+				{opcode: mulop, args: []string{rbx, rax}},
+				{opcode: movop, args: []string{rax, "[RBP-16]"}},
+				{opcode: movop, args: []string{"[RBP-16]", rax}},
+				{opcode: movop, args: []string{"[RBP-16]", rbx}},
+				{opcode: addop, args: []string{rbx, rax}},
+				{opcode: addop, args: []string{"8", rsp}},
+				{opcode: popop, args: []string{rbx}},
+				{opcode: popop, args: []string{rbp}},
+				{opcode: retop, args: []string{}},
+				{opcode: funcstart, args: []string{"_start"}},
+				{opcode: movop, args: []string{"3", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: popop, args: []string{rdi}},
+				{opcode: callop, args: []string{"lwl_f"}},
+				{opcode: movop, args: []string{rax, rdi}},
+				{opcode: movop, args: []string{"60", rax}},
+				{opcode: syscallop, args: []string{}},
+			},
+		},
+		{
+			name:   "modulo saves RDX when it holds a parameter",
+			source: "f(a,b,c)=c%b\nmain = f(1,2,3)\n",
+			want: []instruction{
+				{opcode: funcstart, args: []string{"lwl_f"}},
+				{opcode: pushop, args: []string{rbp}},
+				{opcode: movop, args: []string{rsp, rbp}},
+				{opcode: pushop, args: []string{rbx}},
+				{opcode: movop, args: []string{rdx, rax}},
+				{opcode: movop, args: []string{rsi, rbx}},
+				{opcode: pushop, args: []string{rdx}},
+				{opcode: modop, args: []string{rbx, rax}},
+				{opcode: popop, args: []string{rdx}},
+				{opcode: popop, args: []string{rbx}},
+				{opcode: popop, args: []string{rbp}},
+				{opcode: retop, args: []string{}},
+				{opcode: funcstart, args: []string{"_start"}},
+				{opcode: movop, args: []string{"3", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: movop, args: []string{"2", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: movop, args: []string{"1", rax}},
+				{opcode: pushop, args: []string{rax}},
+				{opcode: popop, args: []string{rdi}},
+				{opcode: popop, args: []string{rsi}},
+				{opcode: popop, args: []string{rdx}},
+				{opcode: callop, args: []string{"lwl_f"}},
+				{opcode: movop, args: []string{rax, rdi}},
+				{opcode: movop, args: []string{"60", rax}},
+				{opcode: syscallop, args: []string{}},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			decls, err := parse(tokenizeSource(t, tc.source))
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			checkTypes(t, decls)
+			got, err := passemble(decls)
+			if err != nil {
+				t.Fatalf("passemble() error = %v", err)
+			}
+			if !slices.EqualFunc(got, tc.want, func(a, b instruction) bool {
+				return a.opcode == b.opcode && slices.Equal(a.args, b.args)
+			}) {
+				t.Errorf("passemble() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Plugins extend the compiler without touching it: each one hooks into the stages of the pipeline,
+// getting what a stage produced and returning what the next one works with. They run in the order
+// they are given with -plugin, one after the other on every stage, and whatever they rewrite goes
+// through the rest of the pipeline as if it had been written that way, type checking included.
+
+// plugin is a compiler extension, embedding noHooks it only needs to implement the hooks it uses
+type plugin interface {
+	name() string
+	// afterTokenize rewrites the tokens of every line, reporting errors as diagnostics of the lines
+	afterTokenize(functions []function) ([]function, error)
+	// afterParse rewrites the syntax tree, before it is type checked
+	afterParse(decls []*funcDecl) ([]*funcDecl, error)
+	// afterPassemble rewrites the pseudo-assembly, before it is encoded or turned into GAS
+	afterPassemble(instructions []instruction) ([]instruction, error)
+	// beforeEmit rewrites the output of the stage given by -emit right before it is written
+	beforeEmit(stage string, output []byte) ([]byte, error)
+}
+
+// noHooks leaves the output of every stage untouched
+type noHooks struct{}
+
+func (noHooks) afterTokenize(functions []function) ([]function, error) {
+	return functions, nil
+}
+
+func (noHooks) afterParse(decls []*funcDecl) ([]*funcDecl, error) {
+	return decls, nil
+}
+
+func (noHooks) afterPassemble(instructions []instruction) ([]instruction, error) {
+	return instructions, nil
+}
+
+func (noHooks) beforeEmit(_ string, output []byte) ([]byte, error) {
+	return output, nil
+}
+
+// builtinPlugins build the plugins shipped with the compiler by name, given the option
+// written after a ':' in -plugin name:option, if any
+var builtinPlugins = map[string]func(option string) (plugin, error){
+	stringsPluginName: newStringsPlugin,
+	archIntPluginName: newArchIntPlugin,
+}
+
+// loadPlugin builds the plugin selected by -plugin name[:option], or the external one at -plugin ./path
+func loadPlugin(spec string) (plugin, error) {
+	if isExternalPlugin(spec) {
+		p, err := newExternalPlugin(spec)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %v: %w", spec, err)
+		}
+		return p, nil
+	}
+	name, option, _ := strings.Cut(spec, ":")
+	newPlugin, ok := builtinPlugins[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown plugin %q, expected one of: %v", name, slices.Sorted(maps.Keys(builtinPlugins)))
+	}
+	p, err := newPlugin(option)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %v: %w", name, err)
+	}
+	return p, nil
+}
+
+// plugins are the plugins selected, in the order their hooks run
+type plugins []plugin
+
+// runHooks passes v through the hook of every plugin in order
+func runHooks[T any](ps plugins, v T, hook func(plugin, T) (T, error)) (T, error) {
+	for _, p := range ps {
+		var err error
+		if v, err = hook(p, v); err != nil {
+			return v, fmt.Errorf("plugin %v: %w", p.name(), err)
+		}
+	}
+	return v, nil
+}
+
+func (ps plugins) afterTokenize(functions []function) ([]function, error) {
+	return runHooks(ps, functions, plugin.afterTokenize)
+}
+
+func (ps plugins) afterParse(decls []*funcDecl) ([]*funcDecl, error) {
+	return runHooks(ps, decls, plugin.afterParse)
+}
+
+func (ps plugins) afterPassemble(instructions []instruction) ([]instruction, error) {
+	return runHooks(ps, instructions, plugin.afterPassemble)
+}
+
+func (ps plugins) beforeEmit(stage string, output []byte) ([]byte, error) {
+	return runHooks(ps, output, func(p plugin, output []byte) ([]byte, error) { return p.beforeEmit(stage, output) })
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// archIntPluginName makes the integers without annotations as wide as the registers of an architecture,
+// amd64 unless given as in -plugin archint:386, instead of always i64
+const archIntPluginName = "archint"
+
+// targetArch is the architecture the compiler generates code for
+const targetArch = "amd64"
+
+// archIntTypes are the integers as wide as the registers of each architecture, by its GOARCH name
+var archIntTypes = map[string]*lwlType{
+	"386":     typeI32,
+	"amd64":   typeI64,
+	"arm":     typeI32,
+	"arm64":   typeI64,
+	"riscv64": typeI64,
+	"wasm":    typeI64,
+}
+
+type archIntPlugin struct {
+	noHooks
+	typ *lwlType
+}
+
+func newArchIntPlugin(option string) (plugin, error) {
+	if option == "" {
+		option = targetArch
+	}
+	t, ok := archIntTypes[option]
+	if !ok {
+		return nil, fmt.Errorf("unknown architecture %q, expected one of: %v", option, slices.Sorted(maps.Keys(archIntTypes)))
+	}
+	return archIntPlugin{typ: t}, nil
+}
+
+func (archIntPlugin) name() string {
+	return archIntPluginName
+}
+
+// afterParse annotates every parameter and result left without one, main still results in
+// an i64 since that is what the process exits with
+func (p archIntPlugin) afterParse(decls []*funcDecl) ([]*funcDecl, error) {
+	for _, d := range decls {
+		if d.main {
+			continue
+		}
+		for _, param := range d.params {
+			if param.typ == nil {
+				param.setType(p.typ)
+			}
+		}
+		if d.result == nil {
+			d.result = p.typ
+		}
+	}
+	return decls, nil
+}
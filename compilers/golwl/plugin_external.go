@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// pluginTimeout is how long an external plugin has to answer each request
+const pluginTimeout = 30 * time.Second
+
+// externalPlugin is an executable given by its path in -plugin ./path/to/plugin, speaking the JSON
+// protocol of plugin_protocol.go, run once for every hook it can rewrite
+type externalPlugin struct {
+	noHooks
+	path string
+}
+
+// isExternalPlugin reports if a -plugin spec is the path of an executable rather than a built-in name
+func isExternalPlugin(spec string) bool {
+	return strings.ContainsRune(spec, os.PathSeparator)
+}
+
+func newExternalPlugin(path string) (plugin, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+		return nil, fmt.Errorf("%v is not an executable", path)
+	}
+	return externalPlugin{path: path}, nil
+}
+
+func (p externalPlugin) name() string {
+	return p.path
+}
+
+func (p externalPlugin) afterParse(decls []*funcDecl) ([]*funcDecl, error) {
+	resp, err := p.run(pluginMessage{Version: pluginProtocolVersion, Hook: hookAfterParse, Decls: encodeDecls(decls)})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Decls == nil {
+		return nil, errors.New("invalid response: missing decls")
+	}
+	decoded, err := decodeDecls(resp.Decls, decls)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return decoded, nil
+}
+
+func (p externalPlugin) afterPassemble(instructions []instruction) ([]instruction, error) {
+	resp, err := p.run(pluginMessage{Version: pluginProtocolVersion, Hook: hookAfterPassemble, Instructions: encodeInstructions(instructions)})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Instructions == nil {
+		return nil, errors.New("invalid response: missing instructions")
+	}
+	decoded, err := decodeInstructions(resp.Instructions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return decoded, nil
+}
+
+// run writes the request to the plugin and reads back its response, which has to be a single
+// message of the same version and hook
+func (p externalPlugin) run(request pluginMessage) (*pluginMessage, error) {
+	in, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.path)
+	cmd.Stdin = bytes.NewReader(in)
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%v: no response after %v", request.Hook, pluginTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %w: %v", request.Hook, err, msg)
+		}
+		return nil, fmt.Errorf("%v: %w", request.Hook, err)
+	}
+
+	resp := &pluginMessage{}
+	dec := json.NewDecoder(&stdout)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(resp); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if dec.More() {
+		return nil, errors.New("invalid response: more than one message")
+	}
+	switch {
+	case resp.Error != "":
+		return nil, errors.New(resp.Error)
+	case resp.Version != request.Version:
+		return nil, fmt.Errorf("invalid response: version %v, expected %v", resp.Version, request.Version)
+	case resp.Hook != request.Hook:
+		return nil, fmt.Errorf("invalid response: hook %q, expected %q", resp.Hook, request.Hook)
+	}
+	return resp, nil
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// This is the JSON protocol of the external plugins: the compiler writes a request with the syntax tree
+// or the pseudo-assembly to the standard input of the plugin, and reads the response, shaped just like
+// the request, from its standard output. Whatever comes back is validated as thoroughly as parse would,
+// every error pointing to where it is in the response, so a broken plugin can not break the compiler.
+//
+// A syntax tree is a list of declarations, each expression being an object with its "kind" and the
+// fields of that kind, the types written by name just like in the source:
+//
+//	{"version": 1, "hook": "after_parse", "decls": [
+//	  {"name": "f", "params": [{"name": "x", "type": "u8"}], "body": {"kind": "binary", "op": "+",
+//	    "lhs": {"kind": "ident", "name": "x"}, "rhs": {"kind": "literal", "value": 1}}},
+//	  {"main": true, "body": {"kind": "call", "name": "f", "args": [{"kind": "literal", "value": 2}]}}]}
+//
+// The functions of an imported module are named after it, "math.sq", and the ones other modules can call
+// are "exported": true. The pseudo-assembly is a list of instructions: {"opcode": "MOV", "args": ["1", "RAX"]}.
+
+// pluginProtocolVersion changes whenever the messages do
+const pluginProtocolVersion = 1
+
+// the hooks external plugins are called for
+const (
+	hookAfterParse     = "after_parse"
+	hookAfterPassemble = "after_passemble"
+)
+
+// operatorTokens are the binary operators by their symbol
+var operatorTokens = map[string]tokenType{
+	"+": tadd, "-": tsub, "*": tmul, "/": tdiv, "%": tmod,
+	"==": teqeq, "!=": tneq, "<": tlt, "<=": tle, ">": tgt, ">=": tge,
+}
+
+type pluginMessage struct {
+	Version      int               `json:"version"`
+	Hook         string            `json:"hook"`
+	Decls        []*jsonDecl       `json:"decls,omitempty"`
+	Instructions []jsonInstruction `json:"instructions,omitempty"`
+	Error        string            `json:"error,omitempty"` // set by a plugin to fail with a message of its own
+}
+
+type jsonPosition struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+}
+
+type jsonDecl struct {
+	Pos      *jsonPosition `json:"pos,omitempty"`
+	Name     string        `json:"name,omitempty"`
+	Main     bool          `json:"main,omitempty"`
+	Exported bool          `json:"exported,omitempty"`
+	Params   []*jsonParam  `json:"params,omitempty"`
+	Result   string        `json:"result,omitempty"`
+	Body     *jsonExpr     `json:"body"`
+}
+
+type jsonParam struct {
+	Pos  *jsonPosition `json:"pos,omitempty"`
+	Name string        `json:"name"`
+	Type string        `json:"type,omitempty"`
+}
+
+// jsonExpr is any expression, only the fields of its kind are set
+type jsonExpr struct {
+	Kind    string           `json:"kind"`
+	Pos     *jsonPosition    `json:"pos,omitempty"`
+	Value   *int64           `json:"value,omitempty"` // literal
+	Name    string           `json:"name,omitempty"`  // ident, call, field and let
+	Op      string           `json:"op,omitempty"`    // unary and binary
+	Type    string           `json:"type,omitempty"`  // cast, struct, array and the annotation of let
+	Operand *jsonExpr        `json:"operand,omitempty"`
+	LHS     *jsonExpr        `json:"lhs,omitempty"`
+	RHS     *jsonExpr        `json:"rhs,omitempty"`
+	Cond    *jsonExpr        `json:"cond,omitempty"` // cond, along with then and else
+	Then    *jsonExpr        `json:"then,omitempty"`
+	Else    *jsonExpr        `json:"else,omitempty"`
+	Index   *jsonExpr        `json:"index,omitempty"`
+	Args    []*jsonExpr      `json:"args,omitempty"`  // call
+	Elems   []*jsonExpr      `json:"elems,omitempty"` // array
+	Fields  []*jsonFieldInit `json:"fields,omitempty"`
+	Bind    *jsonExpr        `json:"bind,omitempty"` // let, the value of its name within in
+	In      *jsonExpr        `json:"in,omitempty"`
+}
+
+type jsonFieldInit struct {
+	Pos   *jsonPosition `json:"pos,omitempty"`
+	Name  string        `json:"name"`
+	Value *jsonExpr     `json:"value"`
+}
+
+type jsonInstruction struct {
+	Opcode string   `json:"opcode"`
+	Args   []string `json:"args"`
+}
+
+func encodePosition(p position) *jsonPosition {
+	return &jsonPosition{File: p.file, Line: p.line, Col: p.col}
+}
+
+func typeName(t *lwlType) string {
+	if t == nil {
+		return ""
+	}
+	return t.name
+}
+
+func encodeDecls(decls []*funcDecl) []*jsonDecl {
+	out := make([]*jsonDecl, 0, len(decls))
+	for _, d := range decls {
+		jd := &jsonDecl{Pos: encodePosition(d.position), Name: d.name, Main: d.main, Exported: d.exported, Result: typeName(d.result), Body: encodeExpr(d.body)}
+		for _, p := range d.params {
+			jd.Params = append(jd.Params, &jsonParam{Pos: encodePosition(p.position), Name: p.name, Type: typeName(p.typ)})
+		}
+		out = append(out, jd)
+	}
+	return out
+}
+
+func encodeExpr(e expr) *jsonExpr {
+	j := &jsonExpr{Pos: encodePosition(e.pos())}
+	switch e := e.(type) {
+	case *literal:
+		j.Kind, j.Value = "literal", &e.value
+	case *ident:
+		j.Kind, j.Name = "ident", e.name
+	case *unaryExpr:
+		j.Kind, j.Op, j.Operand = "unary", operatorSymbols[e.op], encodeExpr(e.operand)
+	case *binaryExpr:
+		j.Kind, j.Op, j.LHS, j.RHS = "binary", operatorSymbols[e.op], encodeExpr(e.lhs), encodeExpr(e.rhs)
+	case *condExpr:
+		j.Kind, j.Cond, j.Then, j.Else = "cond", encodeExpr(e.cond), encodeExpr(e.then), encodeExpr(e.els)
+	case *callExpr:
+		j.Kind, j.Name = "call", e.name
+		for _, arg := range e.args {
+			j.Args = append(j.Args, encodeExpr(arg))
+		}
+	case *castExpr:
+		j.Kind, j.Type, j.Operand = "cast", e.exprType().name, encodeExpr(e.operand)
+	case *fieldExpr:
+		j.Kind, j.Operand, j.Name = "field", encodeExpr(e.operand), e.name
+	case *structLit:
+		j.Kind, j.Type = "struct", e.exprType().name
+		for _, f := range e.fields {
+			j.Fields = append(j.Fields, &jsonFieldInit{Pos: encodePosition(f.position), Name: f.name, Value: encodeExpr(f.value)})
+		}
+	case *arrayLit:
+		j.Kind, j.Type = "array", e.exprType().name
+		for _, elem := range e.elems {
+			j.Elems = append(j.Elems, encodeExpr(elem))
+		}
+	case *indexExpr:
+		j.Kind, j.Operand, j.Index = "index", encodeExpr(e.operand), encodeExpr(e.index)
+	case *lenExpr:
+		j.Kind, j.Operand = "len", encodeExpr(e.operand)
+	case *letExpr:
+		j.Kind, j.Name, j.Type, j.Bind, j.In = "let", e.name.name, typeName(e.name.typ), encodeExpr(e.value), encodeExpr(e.body)
+	}
+	return j
+}
+
+func encodeInstructions(instructions []instruction) []jsonInstruction {
+	out := make([]jsonInstruction, 0, len(instructions))
+	for _, i := range instructions {
+		out = append(out, jsonInstruction{Opcode: string(i.opcode), Args: append([]string{}, i.args...)})
+	}
+	return out
+}
+
+// declDecoder turns the declarations of a response back into a syntax tree, validating them on the way
+type declDecoder struct {
+	types     map[string]*lwlType // the types of the request by name, arrays are added as they are found
+	functions map[string]*jsonDecl
+	params    map[string]bool // of the declaration being decoded
+}
+
+// decodeDecls validates and decodes the declarations of a response, the types are those of the
+// request since a plugin can not declare new structs, though it can use any array of integers
+func decodeDecls(decls []*jsonDecl, request []*funcDecl) ([]*funcDecl, error) {
+	d := &declDecoder{types: maps.Clone(intTypes), functions: make(map[string]*jsonDecl)}
+	for _, kind := range []typeKind{kindStruct, kindArray} {
+		for _, t := range usedTypes(request, kind) {
+			d.types[t.name] = t
+		}
+	}
+
+	mains := 0
+	for i, jd := range decls {
+		switch {
+		case jd == nil:
+			return nil, fmt.Errorf("decls[%v]: missing declaration", i)
+		case jd.Main:
+			mains++
+			if jd.Name != "" || len(jd.Params) > 0 || jd.Result != "" {
+				return nil, fmt.Errorf("decls[%v]: main has no name, params nor result", i)
+			}
+		case !isFunctionName(jd.Name):
+			return nil, fmt.Errorf("decls[%v]: invalid function name %q", i, jd.Name)
+		case d.functions[jd.Name] != nil:
+			return nil, fmt.Errorf("decls[%v]: function %v defined twice", i, jd.Name)
+		default:
+			d.functions[jd.Name] = jd
+		}
+	}
+	want := 0
+	if slices.ContainsFunc(request, func(d *funcDecl) bool { return d.main }) {
+		want = 1
+	}
+	if mains != want {
+		return nil, fmt.Errorf("decls: %v main functions, the program had %v", mains, want)
+	}
+
+	out := make([]*funcDecl, 0, len(decls))
+	for i, jd := range decls {
+		decl, err := d.decl(jd)
+		if err != nil {
+			return nil, fmt.Errorf("decls[%v]%w", i, err)
+		}
+		out = append(out, decl)
+	}
+	return out, nil
+}
+
+func (d *declDecoder) decl(jd *jsonDecl) (*funcDecl, error) {
+	decl := &funcDecl{position: decodePosition(jd.Pos), name: jd.Name, main: jd.Main, exported: jd.Exported}
+	d.params = make(map[string]bool, len(jd.Params))
+	for i, jp := range jd.Params {
+		if jp == nil || !isName(jp.Name) {
+			return nil, fmt.Errorf(".params[%v]: invalid parameter", i)
+		}
+		if d.params[jp.Name] {
+			return nil, fmt.Errorf(".params[%v]: parameter %v declared twice", i, jp.Name)
+		}
+		d.params[jp.Name] = true
+		p := &ident{position: decodePosition(jp.Pos), name: jp.Name}
+		if jp.Type != "" {
+			t, err := d.lookup(jp.Type)
+			if err != nil {
+				return nil, fmt.Errorf(".params[%v].type: %w", i, err)
+			}
+			p.setType(t)
+		}
+		decl.params = append(decl.params, p)
+	}
+	if jd.Result != "" {
+		t, err := d.lookup(jd.Result)
+		if err != nil {
+			return nil, fmt.Errorf(".result: %w", err)
+		}
+		decl.result = t
+	}
+	body, err := d.expr(jd.Body)
+	if err != nil {
+		return nil, fmt.Errorf(".body%w", err)
+	}
+	decl.body = body
+	return decl, nil
+}
+
+// expr decodes an expression, the errors start with the path to the wrong field within it
+func (d *declDecoder) expr(j *jsonExpr) (expr, error) {
+	if j == nil {
+		return nil, errors.New(": missing expression")
+	}
+	p := decodePosition(j.Pos)
+	sub := func(field string, j *jsonExpr) (expr, error) {
+		e, err := d.expr(j)
+		if err != nil {
+			return nil, fmt.Errorf(".%v%w", field, err)
+		}
+		return e, nil
+	}
+	switch j.Kind {
+	case "literal":
+		if j.Value == nil {
+			return nil, errors.New(": literal without a value")
+		}
+		return &literal{position: p, value: *j.Value}, nil
+	case "ident":
+		if !d.params[j.Name] {
+			return nil, fmt.Errorf(": undefined variable %q", j.Name)
+		}
+		return &ident{position: p, name: j.Name}, nil
+	case "unary":
+		if j.Op != operatorSymbols[tsub] {
+			return nil, fmt.Errorf(": unknown unary operator %q", j.Op)
+		}
+		operand, err := sub("operand", j.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{position: p, op: tsub, operand: operand}, nil
+	case "binary":
+		op, ok := operatorTokens[j.Op]
+		if !ok {
+			return nil, fmt.Errorf(": unknown binary operator %q", j.Op)
+		}
+		lhs, err := sub("lhs", j.LHS)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := sub("rhs", j.RHS)
+		if err != nil {
+			return nil, err
+		}
+		return &binaryExpr{position: p, op: op, lhs: lhs, rhs: rhs}, nil
+	case "cond":
+		cond, err := sub("cond", j.Cond)
+		if err != nil {
+			return nil, err
+		}
+		then, err := sub("then", j.Then)
+		if err != nil {
+			return nil, err
+		}
+		els, err := sub("else", j.Else)
+		if err != nil {
+			return nil, err
+		}
+		return &condExpr{position: p, cond: cond, then: then, els: els}, nil
+	case "call":
+		f, ok := d.functions[j.Name]
+		if !ok {
+			return nil, fmt.Errorf(": undefined function %q", j.Name)
+		}
+		if len(f.Params) != len(j.Args) {
+			return nil, fmt.Errorf(": function %v expects %v arguments, got %v", j.Name, len(f.Params), len(j.Args))
+		}
+		call := &callExpr{position: p, name: j.Name}
+		for i, arg := range j.Args {
+			e, err := sub(fmt.Sprintf("args[%v]", i), arg)
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, e)
+		}
+		return call, nil
+	case "cast":
+		t, ok := intTypes[j.Type]
+		if !ok {
+			return nil, fmt.Errorf(".type: cast to %q, expected one of %v", j.Type, typeNames)
+		}
+		operand, err := sub("operand", j.Operand)
+		if err != nil {
+			return nil, err
+		}
+		cast := &castExpr{position: p, operand: operand}
+		cast.setType(t)
+		return cast, nil
+	case "field":
+		if !isName(j.Name) {
+			return nil, fmt.Errorf(": invalid field name %q", j.Name)
+		}
+		operand, err := sub("operand", j.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return &fieldExpr{position: p, operand: operand, name: j.Name}, nil
+	case "struct":
+		t, err := d.lookup(j.Type)
+		if err != nil || t.kind != kindStruct {
+			return nil, fmt.Errorf(".type: unknown struct %q", j.Type)
+		}
+		lit := &structLit{position: p}
+		lit.setType(t)
+		for i, f := range j.Fields {
+			if f == nil || !isName(f.Name) {
+				return nil, fmt.Errorf(".fields[%v]: invalid field", i)
+			}
+			value, err := sub(fmt.Sprintf("fields[%v].value", i), f.Value)
+			if err != nil {
+				return nil, err
+			}
+			lit.fields = append(lit.fields, &fieldInit{position: decodePosition(f.Pos), name: f.Name, value: value})
+		}
+		return lit, nil
+	case "array":
+		t, err := d.lookup(j.Type)
+		if err != nil || t.kind != kindArray {
+			return nil, fmt.Errorf(".type: invalid array type %q", j.Type)
+		}
+		lit := &arrayLit{position: p}
+		lit.setType(t)
+		for i, elem := range j.Elems {
+			e, err := sub(fmt.Sprintf("elems[%v]", i), elem)
+			if err != nil {
+				return nil, err
+			}
+			lit.elems = append(lit.elems, e)
+		}
+		return lit, nil
+	case "index":
+		operand, err := sub("operand", j.Operand)
+		if err != nil {
+			return nil, err
+		}
+		index, err := sub("index", j.Index)
+		if err != nil {
+			return nil, err
+		}
+		return &indexExpr{position: p, operand: operand, index: index}, nil
+	case "len":
+		operand, err := sub("operand", j.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return &lenExpr{position: p, operand: operand}, nil
+	case "let":
+		return d.let(j, p, sub)
+	}
+	return nil, fmt.Errorf(": unknown expression kind %q", j.Kind)
+}
+
+// let decodes a local binding, its name is only a variable within in
+func (d *declDecoder) let(j *jsonExpr, p position, sub func(string, *jsonExpr) (expr, error)) (expr, error) {
+	if !isName(j.Name) {
+		return nil, fmt.Errorf(": invalid let name %q", j.Name)
+	}
+	l := &letExpr{position: p, name: &ident{position: p, name: j.Name}}
+	if j.Type != "" {
+		t, err := d.lookup(j.Type)
+		if err != nil {
+			return nil, fmt.Errorf(".type: %w", err)
+		}
+		l.name.typ = t
+	}
+	value, err := sub("bind", j.Bind)
+	if err != nil {
+		return nil, err
+	}
+	shadows := d.params[j.Name]
+	d.params[j.Name] = true
+	body, err := sub("in", j.In)
+	if !shadows {
+		delete(d.params, j.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.value, l.body = value, body
+	return l, nil
+}
+
+// lookup resolves a type by name, an array of integers being the same type wherever it is written
+func (d *declDecoder) lookup(name string) (*lwlType, error) {
+	if t, ok := d.types[name]; ok {
+		return t, nil
+	}
+	length, elemName, ok := strings.Cut(strings.TrimPrefix(name, "["), "]")
+	if !strings.HasPrefix(name, "[") || !ok {
+		return nil, fmt.Errorf("unknown type %q", name)
+	}
+	n, err := strconv.Atoi(length)
+	elem, isInt := intTypes[elemName]
+	if err != nil || n < 1 || n > maxArrayLength || !isInt || strconv.Itoa(n) != length {
+		return nil, fmt.Errorf("invalid array type %q", name)
+	}
+	t := newArrayType(elem, n)
+	d.types[name] = t
+	return t, nil
+}
+
+func decodePosition(p *jsonPosition) position {
+	if p == nil {
+		return position{}
+	}
+	return position{file: p.File, line: p.Line, col: p.Col}
+}
+
+// isName reports if s can be the name of a function, parameter or field
+func isName(s string) bool {
+	if s == "" || isDigit(s[0]) {
+		return false
+	}
+	for i := range len(s) {
+		if !isIdentifier(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isFunctionName reports if s can be the name of a function, qualified by its module or not
+func isFunctionName(s string) bool {
+	module, name, qualified := strings.Cut(s, ".")
+	if !qualified {
+		return isName(s)
+	}
+	return isName(module) && isName(name)
+}
+
+// decodeInstructions validates and decodes the instructions of a response, what their arguments
+// can be is up to the backend lowering them
+func decodeInstructions(instructions []jsonInstruction) ([]instruction, error) {
+	out := make([]instruction, 0, len(instructions))
+	for i, ji := range instructions {
+		if !slices.Contains(opsets, opset(ji.Opcode)) {
+			return nil, fmt.Errorf("instructions[%v].opcode: unknown opcode %q", i, ji.Opcode)
+		}
+		for j, arg := range ji.Args {
+			if arg == "" || strings.ContainsAny(arg, "\t\r\n") {
+				return nil, fmt.Errorf("instructions[%v].args[%v]: invalid argument %q", i, j, arg)
+			}
+		}
+		out = append(out, instruction{opcode: opset(ji.Opcode), args: ji.Args})
+	}
+	return out, nil
+}
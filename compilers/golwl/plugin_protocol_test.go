@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_encodeDecls(t *testing.T) {
+	source := "struct p { x:u8, y }\n" +
+		"f(a:p, b:[2]i16):i16 = -i16(a.x) + b[1] * 2\n" +
+		"g(n):p = n >= 0 ? p{x: 1, y: n} : p{x: 0, y: -n}\n" +
+		"h(a:p) = let b:u8 = a.x in let a = b * 2 in a + b\n" +
+		"main = f(g(3), [2]i16{4, len([3]u8{1, 2, 3})})\n"
+	decls, err := parse(tokenizeSource(t, source))
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	checkTypes(t, decls)
+
+	b, err := json.Marshal(encodeDecls(decls))
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var jds []*jsonDecl
+	if err := json.Unmarshal(b, &jds); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	got, err := decodeDecls(jds, decls)
+	if err != nil {
+		t.Fatalf("decodeDecls() error = %v", err)
+	}
+	checkTypes(t, got)
+	for i := range decls {
+		if got[i].String() != decls[i].String() || got[i].position != decls[i].position {
+			t.Errorf("decodeDecls()[%v] = %v at %v, want %v at %v", i, got[i], got[i].position, decls[i], decls[i].position)
+		}
+	}
+}
+
+func Test_decodeDecls(t *testing.T) {
+	request, err := parse(tokenizeSource(t, "struct p { x }\nf(a:p) = a.x\nmain = f(p{x: 1})\n"))
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	main := `{"main": true, "body": {"kind": "literal", "value": 1}}`
+	tests := []struct {
+		name    string
+		decls   string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			decls: `[{"name": "g", "params": [{"name": "n", "type": "[4]u8"}], "body": {"kind": "len", "operand": {"kind": "ident", "name": "n"}}}, ` + main + `]`,
+			want:  []string{"g(n:[4]u8) = (len n)", "main = 1"},
+		},
+		{
+			name:  "functions of a module",
+			decls: `[{"name": "math.sq", "exported": true, "params": [{"name": "x"}], "body": {"kind": "call", "name": "math.twice", "args": [{"kind": "ident", "name": "x"}]}}, {"name": "math.twice", "params": [{"name": "x"}], "body": {"kind": "ident", "name": "x"}}, ` + main + `]`,
+			want:  []string{"export math.sq(x) = (math.twice x)", "math.twice(x) = x", "main = 1"},
+		},
+		{
+			name:    "missing declaration",
+			decls:   `[null, ` + main + `]`,
+			wantErr: "decls[0]: missing declaration",
+		},
+		{
+			name:    "no main",
+			decls:   `[{"name": "f", "body": {"kind": "literal", "value": 1}}]`,
+			wantErr: "decls: 0 main functions, the program had 1",
+		},
+		{
+			name:    "main with a name",
+			decls:   `[{"main": true, "name": "main", "body": {"kind": "literal", "value": 1}}]`,
+			wantErr: "decls[0]: main has no name, params nor result",
+		},
+		{
+			name:    "invalid function name",
+			decls:   `[{"name": "1f", "body": {"kind": "literal", "value": 1}}, ` + main + `]`,
+			wantErr: `decls[0]: invalid function name "1f"`,
+		},
+		{
+			name:    "invalid module name",
+			decls:   `[{"name": "a.b.f", "body": {"kind": "literal", "value": 1}}, ` + main + `]`,
+			wantErr: `decls[0]: invalid function name "a.b.f"`,
+		},
+		{
+			name:    "function defined twice",
+			decls:   `[{"name": "f", "body": {"kind": "literal", "value": 1}}, {"name": "f", "body": {"kind": "literal", "value": 1}}, ` + main + `]`,
+			wantErr: "decls[1]: function f defined twice",
+		},
+		{
+			name:    "parameter declared twice",
+			decls:   `[{"name": "f", "params": [{"name": "x"}, {"name": "x"}], "body": {"kind": "ident", "name": "x"}}, ` + main + `]`,
+			wantErr: "decls[0].params[1]: parameter x declared twice",
+		},
+		{
+			name:    "unknown type",
+			decls:   `[{"name": "f", "params": [{"name": "x", "type": "q"}], "body": {"kind": "ident", "name": "x"}}, ` + main + `]`,
+			wantErr: `decls[0].params[0].type: unknown type "q"`,
+		},
+		{
+			name:    "invalid array type",
+			decls:   `[{"name": "f", "result": "[0]u8", "body": {"kind": "literal", "value": 1}}, ` + main + `]`,
+			wantErr: `decls[0].result: invalid array type "[0]u8"`,
+		},
+		{
+			name:    "missing body",
+			decls:   `[{"main": true}]`,
+			wantErr: "decls[0].body: missing expression",
+		},
+		{
+			name:    "literal without a value",
+			decls:   `[{"main": true, "body": {"kind": "literal"}}]`,
+			wantErr: "decls[0].body: literal without a value",
+		},
+		{
+			name:    "unknown kind",
+			decls:   `[{"main": true, "body": {"kind": "lambda"}}]`,
+			wantErr: `decls[0].body: unknown expression kind "lambda"`,
+		},
+		{
+			name:    "let seen outside of its body",
+			decls:   `[{"main": true, "body": {"kind": "binary", "op": "+", "lhs": {"kind": "let", "name": "y", "bind": {"kind": "literal", "value": 1}, "in": {"kind": "ident", "name": "y"}}, "rhs": {"kind": "ident", "name": "y"}}}]`,
+			wantErr: `decls[0].body.rhs: undefined variable "y"`,
+		},
+		{
+			name:    "let without a name",
+			decls:   `[{"main": true, "body": {"kind": "let", "bind": {"kind": "literal", "value": 1}, "in": {"kind": "literal", "value": 2}}}]`,
+			wantErr: `decls[0].body: invalid let name ""`,
+		},
+		{
+			name:    "unknown operator",
+			decls:   `[{"main": true, "body": {"kind": "binary", "op": "^", "lhs": {"kind": "literal", "value": 1}, "rhs": {"kind": "literal", "value": 1}}}]`,
+			wantErr: `decls[0].body: unknown binary operator "^"`,
+		},
+		{
+			name:    "undefined variable deep within",
+			decls:   `[{"main": true, "body": {"kind": "binary", "op": "+", "lhs": {"kind": "literal", "value": 1}, "rhs": {"kind": "unary", "op": "-", "operand": {"kind": "ident", "name": "y"}}}}]`,
+			wantErr: `decls[0].body.rhs.operand: undefined variable "y"`,
+		},
+		{
+			name:    "undefined function",
+			decls:   `[{"main": true, "body": {"kind": "call", "name": "nope"}}]`,
+			wantErr: `decls[0].body: undefined function "nope"`,
+		},
+		{
+			name:    "wrong number of arguments",
+			decls:   `[{"name": "f", "params": [{"name": "x"}], "body": {"kind": "ident", "name": "x"}}, {"main": true, "body": {"kind": "call", "name": "f"}}]`,
+			wantErr: "decls[1].body: function f expects 1 arguments, got 0",
+		},
+		{
+			name:    "cast to a struct",
+			decls:   `[{"main": true, "body": {"kind": "cast", "type": "p", "operand": {"kind": "literal", "value": 1}}}]`,
+			wantErr: `decls[0].body.type: cast to "p", expected one of`,
+		},
+		{
+			name:    "struct literal of an array",
+			decls:   `[{"main": true, "body": {"kind": "struct", "type": "[1]u8"}}]`,
+			wantErr: `decls[0].body.type: unknown struct "[1]u8"`,
+		},
+		{
+			name:    "invalid field",
+			decls:   `[{"main": true, "body": {"kind": "field", "name": "", "operand": {"kind": "literal", "value": 1}}}]`,
+			wantErr: `decls[0].body: invalid field name ""`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var jds []*jsonDecl
+			if err := json.Unmarshal([]byte(tc.decls), &jds); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			decls, err := decodeDecls(jds, request)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("decodeDecls() error = %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeDecls() error = %v", err)
+			}
+			got := make([]string, 0, len(decls))
+			for _, d := range decls {
+				got = append(got, d.String())
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("decodeDecls() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func Test_decodeInstructions(t *testing.T) {
+	tests := []struct {
+		name         string
+		instructions []jsonInstruction
+		want         []string
+		wantErr      string
+	}{
+		{
+			name:         "valid",
+			instructions: []jsonInstruction{{Opcode: "MOV", Args: []string{"DWORD [RAX+4]", "RBX"}}, {Opcode: "RET"}},
+			want:         []string{"MOV DWORD [RAX+4], RBX", "RET"},
+		},
+		{
+			name:         "unknown opcode",
+			instructions: []jsonInstruction{{Opcode: "RET"}, {Opcode: "JMPX"}},
+			wantErr:      `instructions[1].opcode: unknown opcode "JMPX"`,
+		},
+		{
+			name:         "empty argument",
+			instructions: []jsonInstruction{{Opcode: "PUSH", Args: []string{""}}},
+			wantErr:      `instructions[0].args[0]: invalid argument ""`,
+		},
+		{
+			name:         "argument spanning lines",
+			instructions: []jsonInstruction{{Opcode: "PUSH", Args: []string{"RAX\nSYSCALL"}}},
+			wantErr:      `instructions[0].args[0]: invalid argument "RAX\nSYSCALL"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			instructions, err := decodeInstructions(tc.instructions)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("decodeInstructions() error = %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeInstructions() error = %v", err)
+			}
+			got := make([]string, 0, len(instructions))
+			for _, i := range instructions {
+				got = append(got, strings.TrimSpace(i.String()))
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("decodeInstructions() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+// stringsPluginName lowers every string into the array of its bytes: "hi\n" is [3]u8{104, 105, 10}
+const stringsPluginName = "strings"
+
+// stringEscapes are the characters a backslash can escape in a string
+var stringEscapes = map[byte]byte{'n': '\n', 't': '\t', '0': 0, '\\': '\\', '"': '"'}
+
+type stringsPlugin struct {
+	noHooks
+}
+
+func newStringsPlugin(option string) (plugin, error) {
+	if option != "" {
+		return nil, errors.New("takes no options")
+	}
+	return stringsPlugin{}, nil
+}
+
+func (stringsPlugin) name() string {
+	return stringsPluginName
+}
+
+// afterTokenize replaces every string token with the tokens of an array literal, all of them
+// pointing to the string so the diagnostics about the array point to it, the paths of imports are
+// left as they are
+func (stringsPlugin) afterTokenize(functions []function) ([]function, error) {
+	for i := range functions {
+		f := &functions[i]
+		if f.importDecl {
+			continue
+		}
+		tkns := make([]token, 0, len(f.tkns))
+		for _, t := range f.tkns {
+			if t.t != tstring {
+				tkns = append(tkns, t)
+				continue
+			}
+			bytes, ok := unescape(f, t)
+			if !ok {
+				continue
+			}
+			at := func(tt tokenType, v string) token {
+				return token{t: tt, v: v, line: t.line, col: t.col}
+			}
+			tkns = append(tkns, at(tlbracket, "["), at(tconstant, strconv.Itoa(len(bytes))), at(trbracket, "]"), at(tvariable, "u8"), at(tlbrace, "{"))
+			for j, b := range bytes {
+				if j > 0 {
+					tkns = append(tkns, at(tcomma, ","))
+				}
+				tkns = append(tkns, at(tconstant, strconv.Itoa(int(b))))
+			}
+			tkns = append(tkns, at(trbrace, "}"))
+		}
+		f.tkns = tkns
+	}
+	return functions, nil
+}
+
+// unescape returns the bytes of the string token t, reporting the invalid ones in f
+func unescape(f *function, t token) ([]byte, bool) {
+	raw := t.v[1 : len(t.v)-1]
+	bytes := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			bytes = append(bytes, raw[i])
+			continue
+		}
+		i++
+		b, ok := stringEscapes[raw[i]]
+		if !ok {
+			f.errorAt(t, codeStringLiteral, "unknown escape \\"+string(raw[i])+" in string "+t.v, `strings can escape \n, \t, \0, \\ and \"`)
+			return nil, false
+		}
+		bytes = append(bytes, b)
+	}
+	if len(bytes) == 0 {
+		f.errorAt(t, codeStringLiteral, "empty string", "strings are lowered into arrays, which have at least one element")
+		return nil, false
+	}
+	return bytes, true
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_loadPlugin(t *testing.T) {
+	tests := []struct {
+		spec     string
+		wantName string
+		wantErr  string
+	}{
+		{spec: "strings", wantName: stringsPluginName},
+		{spec: "archint", wantName: archIntPluginName},
+		{spec: "archint:386", wantName: archIntPluginName},
+		{spec: "strings:utf16", wantErr: "plugin strings: takes no options"},
+		{spec: "archint:z80", wantErr: `plugin archint: unknown architecture "z80"`},
+		{spec: "optimize", wantErr: `unknown plugin "optimize", expected one of: [archint strings]`},
+		{spec: "./testdata/missing", wantErr: "plugin ./testdata/missing: stat ./testdata/missing: no such file or directory"},
+		{spec: "testdata/", wantErr: "plugin testdata/: testdata/ is not an executable"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.spec, func(t *testing.T) {
+			p, err := loadPlugin(tc.spec)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("loadPlugin() error = %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadPlugin() error = %v", err)
+			}
+			if p.name() != tc.wantName {
+				t.Errorf("loadPlugin() = %v, want %v", p.name(), tc.wantName)
+			}
+		})
+	}
+}
+
+// tracingPlugin adds its name to whatever goes through its hooks, failing them if asked to
+type tracingPlugin struct {
+	noHooks
+	tag  string
+	fail bool
+}
+
+func (p tracingPlugin) name() string {
+	return p.tag
+}
+
+func (p tracingPlugin) afterPassemble(instructions []instruction) ([]instruction, error) {
+	if p.fail {
+		return nil, errors.New("failed")
+	}
+	return append(instructions, instruction{opcode: callop, args: []string{p.tag}}), nil
+}
+
+func (p tracingPlugin) beforeEmit(stage string, output []byte) ([]byte, error) {
+	return append(output, []byte(" "+stage+":"+p.tag)...), nil
+}
+
+func Test_plugins(t *testing.T) {
+	ps := plugins{tracingPlugin{tag: "a"}, tracingPlugin{tag: "b"}}
+
+	instructions, err := ps.afterPassemble([]instruction{{opcode: retop}})
+	if err != nil {
+		t.Fatalf("afterPassemble() error = %v", err)
+	}
+	want := []string{"RET", "CALL a", "CALL b"}
+	got := make([]string, 0, len(instructions))
+	for _, i := range instructions {
+		got = append(got, strings.TrimSpace(i.String()))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("afterPassemble() = %q, want %q", got, want)
+	}
+
+	output, err := ps.beforeEmit(emitIR, []byte("ir"))
+	if err != nil {
+		t.Fatalf("beforeEmit() error = %v", err)
+	}
+	if string(output) != "ir ir:a ir:b" {
+		t.Errorf("beforeEmit() = %q, want %q", output, "ir ir:a ir:b")
+	}
+
+	// hooks a plugin does not implement leave everything as it was
+	decls := []*funcDecl{{main: true, body: &literal{value: 1}}}
+	if got, err := ps.afterParse(decls); err != nil || !slices.Equal(got, decls) {
+		t.Errorf("afterParse() = %v, %v, want %v", got, err, decls)
+	}
+
+	_, err = plugins{tracingPlugin{tag: "a"}, tracingPlugin{tag: "broken", fail: true}}.afterPassemble(nil)
+	if err == nil || err.Error() != "plugin broken: failed" {
+		t.Errorf("afterPassemble() error = %v, want plugin broken: failed", err)
+	}
+}
+
+func Test_stringsPlugin(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		want     []string
+		wantDiag string
+	}{
+		{
+			name:   "strings become arrays of bytes",
+			source: "f(s:[3]u8) = s[0]\nmain = f(\"abc\") + len(\"a\\tb\\n\\0\\\\\\\"\")\n",
+			want:   []string{"f(s:[3]u8) = ([] s 0)", "main = (+ (f [3]u8{97, 98, 99}) (len [7]u8{97, 9, 98, 10, 0, 92, 34}))"},
+		},
+		{
+			name:   "every byte of the UTF-8 encoding",
+			source: "main = \"é\"[1]\n",
+			want:   []string{"main = ([] [2]u8{195, 169} 1)"},
+		},
+		{
+			name:     "unknown escape",
+			source:   "main = len(\"a\\qb\")\n",
+			wantDiag: `unknown escape \q in string "a\qb"`,
+		},
+		{
+			name:     "empty string",
+			source:   "main = len(\"\")\n",
+			wantDiag: "empty string",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			functions, err := stringsPlugin{}.afterTokenize(tokenizeSource(t, tc.source))
+			if err != nil {
+				t.Fatalf("afterTokenize() error = %v", err)
+			}
+			decls, err := parse(functions)
+			b := bytes.Buffer{}
+			printDiagnostics(&b, collectDiagnostics(functions))
+			if tc.wantDiag != "" {
+				if !errors.Is(err, errParse) || !strings.Contains(b.String(), tc.wantDiag) {
+					t.Errorf("parse() error = %v, diagnostics = %v, want to contain %v", err, b.String(), tc.wantDiag)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse() error = %v: %v", err, b.String())
+			}
+			got := make([]string, 0, len(decls))
+			for _, d := range decls {
+				got = append(got, d.String())
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("parse() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("without the plugin", func(t *testing.T) {
+		functions := tokenizeSource(t, "main = len(\"abc\")\n")
+		_, err := parse(functions)
+		b := bytes.Buffer{}
+		printDiagnostics(&b, collectDiagnostics(functions))
+		if !errors.Is(err, errParse) || !strings.Contains(b.String(), `string "abc" is not lowered into an array`) {
+			t.Errorf("parse() error = %v, diagnostics = %v", err, b.String())
+		}
+	})
+
+	t.Run("end to end", func(t *testing.T) {
+		source := "sum(s:[3]u8) = s[0] + s[1] + s[2]\nmain = sum(\"abc\") + \"\\n\"[0]\n"
+		if got := compileAndRun(t, source, stringsPlugin{}); got != (97+98+99+10)%256 {
+			t.Errorf("exit code = %v, want %v", got, (97+98+99+10)%256)
+		}
+	})
+}
+
+func Test_archIntPlugin(t *testing.T) {
+	// 50000 * 50000 overflows 32 bits, wrapping around to -1794967296
+	source := "f(x) = x * x / 16777216\nmain = f(50000)\n"
+	tests := []struct {
+		arch     string
+		wantDecl string
+		want     int
+	}{
+		{arch: "", wantDecl: "f(x:i64):i64 = (/ (* x x) 16777216)", want: 2500000000 / 16777216},
+		{arch: "386", wantDecl: "f(x:i32):i32 = (/ (* x x) 16777216)", want: 256 + -1794967296/16777216},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.arch, func(t *testing.T) {
+			p, err := newArchIntPlugin(tc.arch)
+			if err != nil {
+				t.Fatalf("newArchIntPlugin() error = %v", err)
+			}
+			decls, err := parse(tokenizeSource(t, source))
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			if decls, err = p.afterParse(decls); err != nil {
+				t.Fatalf("afterParse() error = %v", err)
+			}
+			if got := decls[0].String(); got != tc.wantDecl {
+				t.Errorf("afterParse() = %v, want %v", got, tc.wantDecl)
+			}
+			if got := compileAndRun(t, source, p); got != tc.want {
+				t.Errorf("exit code = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("annotations are kept", func(t *testing.T) {
+		decls, err := parse(tokenizeSource(t, "f(x:u8, y):u16 = x + y\nmain = f(1, 2)\n"))
+		if err != nil {
+			t.Fatalf("parse() error = %v", err)
+		}
+		decls, _ = archIntPlugin{typ: typeI32}.afterParse(decls)
+		if got, want := decls[0].String(), "f(x:u8, y:i32):u16 = (+ x y)"; got != want {
+			t.Errorf("afterParse() = %v, want %v", got, want)
+		}
+	})
+}
+
+// buildTestPlugin builds testdata/plugin, an external plugin doing whatever LWL_TEST_PLUGIN says
+func buildTestPlugin(t *testing.T) externalPlugin {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("building the test plugin needs the go toolchain")
+	}
+	path := filepath.Join(t.TempDir(), "plugin")
+	if out, err := exec.Command("go", "build", "-o", path, "./testdata/plugin").CombinedOutput(); err != nil {
+		t.Fatalf("failed to build the test plugin: %v: %s", err, out)
+	}
+	return externalPlugin{path: path}
+}
+
+func Test_externalPlugin(t *testing.T) {
+	p := buildTestPlugin(t)
+	source := "f(x) = x + 1\nmain = f(1)\n"
+	tests := []struct {
+		mode             string
+		want             []string
+		wantErr          string
+		wantPassembleErr string
+	}{
+		{mode: "echo", want: []string{"f(x) = (+ x 1)", "main = (f 1)"}},
+		{mode: "rewrite", want: []string{"f(x) = (+ x 2)", "main = (f 2)"}},
+		{mode: "garbage", wantErr: "invalid response: invalid character 'o' in literal null (expecting 'u')"},
+		{mode: "unknown kind", wantErr: `invalid response: decls[1].body: unknown expression kind "lambda"`},
+		{mode: "undefined call", wantErr: `invalid response: decls[1].body: undefined function "nope"`},
+		{mode: "bad opcode", want: []string{"f(x) = (+ x 1)", "main = (f 1)"}, wantPassembleErr: `invalid response: instructions[0].opcode: unknown opcode "JMPX"`},
+		{mode: "crash", wantErr: "after_parse: exit status 1: boom"},
+		{mode: "error", wantErr: "cannot rewrite this"},
+		{mode: "missing", wantErr: "invalid response: missing decls"},
+		{mode: "twice", wantErr: "invalid response: more than one message"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.mode, func(t *testing.T) {
+			t.Setenv("LWL_TEST_PLUGIN", tc.mode)
+			decls, err := parse(tokenizeSource(t, source))
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			decls, err = plugins{p}.afterParse(decls)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != "plugin "+p.path+": "+tc.wantErr {
+					t.Fatalf("afterParse() error = %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("afterParse() error = %v", err)
+			}
+			got := make([]string, 0, len(decls))
+			for _, d := range decls {
+				got = append(got, d.String())
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("afterParse() = %q, want %q", got, tc.want)
+			}
+
+			checkTypes(t, decls)
+			instructions, err := passemble(decls)
+			if err != nil {
+				t.Fatalf("passemble() error = %v", err)
+			}
+			rewritten, err := plugins{p}.afterPassemble(instructions)
+			if tc.wantPassembleErr != "" {
+				if err == nil || err.Error() != "plugin "+p.path+": "+tc.wantPassembleErr {
+					t.Fatalf("afterPassemble() error = %v, want %v", err, tc.wantPassembleErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("afterPassemble() error = %v", err)
+			}
+			if !slices.EqualFunc(rewritten, instructions, func(a, b instruction) bool { return a.String() == b.String() }) {
+				t.Errorf("afterPassemble() = %v, want %v", rewritten, instructions)
+			}
+		})
+	}
+
+	t.Run("end to end", func(t *testing.T) {
+		t.Setenv("LWL_TEST_PLUGIN", "rewrite")
+		if got := compileAndRun(t, source, p); got != 4 {
+			t.Errorf("exit code = %v, want %v", got, 4)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+// Command plugin is a stand-in external plugin for the tests, doing whatever LWL_TEST_PLUGIN says
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+func main() {
+	var msg map[string]any
+	if err := json.NewDecoder(os.Stdin).Decode(&msg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	decls, _ := msg["decls"].([]any)
+	instructions, _ := msg["instructions"].([]any)
+
+	switch os.Getenv("LWL_TEST_PLUGIN") {
+	case "rewrite":
+		for _, d := range decls {
+			increment(d.(map[string]any)["body"])
+		}
+	case "garbage":
+		fmt.Println("not json")
+		return
+	case "unknown kind":
+		mainBody(decls)["kind"] = "lambda"
+	case "undefined call":
+		body := mainBody(decls)
+		clear(body)
+		body["kind"], body["name"] = "call", "nope"
+	case "bad opcode":
+		if len(instructions) > 0 {
+			instructions[0].(map[string]any)["opcode"] = "JMPX"
+		}
+	case "crash":
+		fmt.Fprintln(os.Stderr, "boom")
+		os.Exit(1)
+	case "error":
+		msg = map[string]any{"error": "cannot rewrite this"}
+	case "missing":
+		delete(msg, "decls")
+		delete(msg, "instructions")
+	case "twice":
+		json.NewEncoder(os.Stdout).Encode(msg)
+	}
+	json.NewEncoder(os.Stdout).Encode(msg)
+}
+
+// increment adds one to every literal within the expression e
+func increment(e any) {
+	switch e := e.(type) {
+	case map[string]any:
+		if e["kind"] == "literal" {
+			e["value"] = e["value"].(float64) + 1
+		}
+		for _, v := range e {
+			increment(v)
+		}
+	case []any:
+		for _, v := range e {
+			increment(v)
+		}
+	}
+}
+
+func mainBody(decls []any) map[string]any {
+	for _, d := range decls {
+		if d := d.(map[string]any); d["main"] == true {
+			return d["body"].(map[string]any)
+		}
+	}
+	return map[string]any{}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"unicode"
 )
 
 type tokenType int
@@ -22,11 +24,72 @@ const (
 	tdiv
 	tmod
 	teq
+	tcolon
+	tlbrace
+	trbrace
+	tdot
+	tlbracket
+	trbracket
+	tstring
+	teqeq
+	tneq
+	tlt
+	tle
+	tgt
+	tge
+	tquestion
+	tstruct
+	tmain
+	timport
+	texport
+	tlet
+	tin
 )
 
+var tokenNames = map[tokenType]string{
+	tundefined: "undefined",
+	tvariable:  "variable",
+	tconstant:  "constant",
+	tlparenth:  "lparenth",
+	trparenth:  "rparenth",
+	tcomma:     "comma",
+	tadd:       "add",
+	tsub:       "sub",
+	tmul:       "mul",
+	tdiv:       "div",
+	tmod:       "mod",
+	teq:        "eq",
+	tcolon:     "colon",
+	tlbrace:    "lbrace",
+	trbrace:    "rbrace",
+	tdot:       "dot",
+	tlbracket:  "lbracket",
+	trbracket:  "rbracket",
+	tstring:    "string",
+	teqeq:      "eqeq",
+	tneq:       "neq",
+	tlt:        "lt",
+	tle:        "le",
+	tgt:        "gt",
+	tge:        "ge",
+	tquestion:  "question",
+	tstruct:    "struct",
+	tmain:      "main",
+	timport:    "import",
+	texport:    "export",
+	tlet:       "let",
+	tin:        "in",
+}
+
+func (t tokenType) String() string {
+	return tokenNames[t]
+}
+
 type token struct {
-	t tokenType
-	v string
+	t    tokenType
+	v    string
+	line int
+	col  int
 }
 
 func (t token) String() string {
@@ -34,7 +97,39 @@ func (t token) String() string {
 }
 
 func (t token) isOp() bool {
-	return t.t == tadd || t.t == tsub || t.t == tmul || t.t == tdiv || t.t == tmod
+	return t.t == tadd || t.t == tsub || t.t == tmul || t.t == tdiv || t.t == tmod || isComparison(t.t)
+}
+
+// isComparison reports if tt is one of the comparison operators, which result in 1 when true and 0 otherwise
+func isComparison(tt tokenType) bool {
+	return tt == teqeq || tt == tneq || tt == tlt || tt == tle || tt == tgt || tt == tge
+}
+
+// twoRuneTokens are the tokens written with two characters, the first one might be a token on its own
+var twoRuneTokens = map[string]tokenType{
+	"==": teqeq,
+	"!=": tneq,
+	"<=": tle,
+	">=": tge,
+}
+
+// keywords are the names reserved by the language, each one its own token instead of a variable
+var keywords = map[string]tokenType{
+	"struct": tstruct,
+	"main":   tmain,
+	"import": timport,
+	"export": texport,
+	"let":    tlet,
+	"in":     tin,
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isIdentifier reports if c can be part of a variable or function name: [a-zA-Z_][a-zA-Z0-9_]*
+func isIdentifier(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || isDigit(c)
 }
 
 func tokenFromRune(r rune) (token, error) {
@@ -43,6 +138,8 @@ func tokenFromRune(r rune) (token, error) {
 	switch r {
 	case '=':
 		t.t = teq
+	case ':':
+		t.t = tcolon
 	case '+':
 		t.t = tadd
 	case '-':
@@ -59,29 +156,50 @@ func tokenFromRune(r rune) (token, error) {
 		t.t = trparenth
 	case ',':
 		t.t = tcomma
+	case '{':
+		t.t = tlbrace
+	case '}':
+		t.t = trbrace
+	case '.':
+		t.t = tdot
+	case '[':
+		t.t = tlbracket
+	case ']':
+		t.t = trbracket
+	case '"':
+		t.t = tstring
+	case '<':
+		t.t = tlt
+	case '>':
+		t.t = tgt
+	case '?':
+		t.t = tquestion
 	default:
-		if r >= 'a' && r <= 'z' {
+		if r < 0x80 && isIdentifier(byte(r)) && !isDigit(byte(r)) {
 			t.t = tvariable
-		} else if r >= '0' && r <= '9' {
+		} else if r < 0x80 && isDigit(byte(r)) {
 			t.t = tconstant
 		}
 	}
 	if t.t == tundefined {
-		// TODO: better explain our ways of writing
 		return t, errors.New("invalid token: " + t.v)
 	}
 	return t, nil
 }
 
 type function struct {
-	name string
-	file string
-	line int
-	tkns []token
-	main bool
-	errs []error
+	name       string
+	file       string
+	module     string // empty for the files of the main module, the ones given to the compiler
+	line       int    // where it starts, its tokens may go on in the lines after
+	tkns       []token
+	main       bool
+	structDecl bool // it declares a struct type instead of a function
+	importDecl bool // it imports the module named, once its file is found
+	errs       []diagnostic
 }
 
+// tokenize splits the files into the tokens of each declaration, and then the files of the modules they import
 func tokenize(files []string) ([]function, error) {
 	functions := make([]function, 0)
 	for _, file := range files {
@@ -89,63 +207,198 @@ func tokenize(files []string) ([]function, error) {
 		if err != nil {
 			return nil, fmt.Errorf("read %v: %v", file, err)
 		}
-		// TODO: handle different line endings in different OS
-		lines := strings.Split(string(contents), "\n")
+		functions = append(functions, tokenizeFile(file, string(contents), "")...)
+	}
+	return loadImports(functions, files), nil
+}
+
+// tokenizeFile splits the contents of a file of the module into the tokens of each declaration, which goes on
+// in the next line while a parenthesis, bracket or brace is left open or its line ends with an operator
+func tokenizeFile(file string, contents string, module string) []function {
+	s := &fileScanner{file: file, module: module, functions: make([]function, 0)}
+	// TODO: handle different line endings in different OS
+	lines := strings.Split(contents, "\n")
+
+	for i, line := range lines {
+		// keep track of the trimmed indentation so columns point to the original source
+		trimmed := strings.TrimLeftFunc(line, unicode.IsSpace)
+		offset := len(line) - len(trimmed)
+		s.scan(strings.TrimRightFunc(trimmed, unicode.IsSpace), i+1, offset)
+		if s.comment == nil && s.depth <= 0 && !s.continues() {
+			s.finish()
+		}
+	}
+	if s.comment != nil {
+		s.errorAt(*s.comment, codeInvalidToken, "unterminated block comment", "block comments end with '*/'")
+	}
+	s.finish()
+	return s.functions
+}
 
-		for i, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
-			// - every line is a function declaration, if there is no "=" it is the main function declaration
-			// - we are chads and only support single character variable names
-			f := function{
-				file: file,
-				line: i + 1,
-				main: !strings.Contains(line, "="),
+// fileScanner reads the lines of a file one at a time, carrying what a line leaves open on to the next one:
+// the declaration being read, the parenthesis, brackets and braces still open in it, and a block comment
+type fileScanner struct {
+	file      string
+	module    string
+	f         *function // the declaration being read, nil until its first token
+	depth     int       // parenthesis, brackets and braces opened and not closed yet
+	comment   *token    // the start of the block comment still open, nil if there is none
+	functions []function
+}
+
+// scan reads the tokens of the line number n, whose first offset columns were trimmed, into the declaration
+func (s *fileScanner) scan(line string, n int, offset int) {
+	for j := 0; j < len(line); j++ {
+		// skip the rest of a block comment, a line comment and spaces
+		if s.comment != nil {
+			end := strings.Index(line[j:], "*/")
+			if end < 0 {
+				return
 			}
-			p := line[0]
-			pt, err := tokenFromRune(rune(p))
-			if err != nil {
-				f.errs = append(f.errs, err)
+			s.comment = nil
+			j += end + 1
+			continue
+		}
+		if line[j] == '#' || strings.HasPrefix(line[j:], "//") {
+			return
+		}
+		if strings.HasPrefix(line[j:], "/*") {
+			s.comment = &token{v: "/*", line: n, col: offset + j + 1}
+			j++
+			continue
+		}
+		if line[j] == ' ' || line[j] == '\t' {
+			continue
+		}
+
+		if j+1 < len(line) {
+			if tt, ok := twoRuneTokens[line[j:j+2]]; ok {
+				s.add(token{t: tt, v: line[j : j+2], line: n, col: offset + j + 1})
+				j++
 				continue
 			}
-			if !f.main && pt.t == tvariable {
-				f.name = pt.v
+		}
+		t, err := tokenFromRune(rune(line[j]))
+		t.line, t.col = n, offset+j+1
+		if err != nil {
+			s.errorAt(t, codeInvalidToken, err.Error(), "only integers, strings, names, operators, '(', ')', '{', '}', '[', ']', ',', '.', ':', '?' and '=' are allowed")
+			continue
+		}
+		if t.t == tstring {
+			// a string goes up to the next quote not escaped by a backslash, escapes are up to whoever lowers it
+			end := j + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				t.v = line[j:]
+				s.errorAt(t, codeInvalidToken, "unterminated string "+t.v, "strings end with '\"' in the same line")
+				return
 			}
-			f.tkns = append(f.tkns, pt)
+			t.v = line[j : end+1]
+			s.add(t)
+			j = end
+			continue
+		}
 
-			j := 0
-			for {
-				j++
-				if j >= len(line) {
-					break
-				}
+		// any constant might have multiple digits and any variable multiple characters, so we need to read them all
+		start := j
+		for j+1 < len(line) && (t.t == tconstant && isDigit(line[j+1]) || t.t == tvariable && isIdentifier(line[j+1])) {
+			j++
+		}
+		t.v = line[start : j+1]
+		if kw, ok := keywords[t.v]; ok {
+			t.t = kw
+		}
+		s.add(t)
+	}
+}
 
-				// skip spaces
-				if line[j] == ' ' {
-					continue
-				}
+// declaration is the declaration being read, starting a new one if there is none
+func (s *fileScanner) declaration() *function {
+	if s.f == nil {
+		s.f = &function{file: s.file, module: s.module}
+	}
+	return s.f
+}
 
-				// any constant might have multiple digits, so we need to parse them all
-				if line[j] >= '0' && line[j] <= '9' && pt.t == tconstant {
-					t := f.tkns[len(f.tkns)-1]
-					t.v += string(line[j])
-					f.tkns[len(f.tkns)-1] = t
-					continue
-				}
+func (s *fileScanner) add(t token) {
+	f := s.declaration()
+	f.tkns = append(f.tkns, t)
+	switch t.t {
+	case tlparenth, tlbracket, tlbrace:
+		s.depth++
+	case trparenth, trbracket, trbrace:
+		s.depth--
+	}
+}
 
-				t, err := tokenFromRune(rune(line[j]))
-				if err != nil {
-					f.errs = append(f.errs, err)
-					continue
-				}
-				f.tkns = append(f.tkns, t)
-				pt.t = t.t
-			}
-			functions = append(functions, f)
+func (s *fileScanner) errorAt(t token, code diagnosticCode, msg string, notes ...string) {
+	s.declaration().errorAt(t, code, msg, notes...)
+}
+
+// continues reports if the last token read leaves the declaration to be continued in the next line:
+// an operator, or any other token that can not end one
+func (s *fileScanner) continues() bool {
+	if s.f == nil || len(s.f.tkns) == 0 {
+		return false
+	}
+	last := s.f.tkns[len(s.f.tkns)-1]
+	switch last.t {
+	case teq, tcomma, tcolon, tdot, tquestion, tin:
+		return true
+	}
+	return last.isOp()
+}
+
+// finish ends the declaration being read, every one starts in the line of its first token, or of its first
+// error when there is no token
+func (s *fileScanner) finish() {
+	f := s.f
+	s.f, s.depth = nil, 0
+	switch {
+	case f == nil:
+		return
+	case len(f.tkns) > 0:
+		f.line = f.tkns[0].line
+	default:
+		f.line = f.errs[0].line
+	}
+	// struct declares a struct type named by the second token, main the entry point, import a module,
+	// export a function other modules can call named by the second token, and anything else a function
+	// named by the first token
+	switch {
+	case len(f.tkns) == 0:
+	case f.tkns[0].t == tstruct:
+		f.structDecl = true
+		if len(f.tkns) > 1 && f.tkns[1].t == tvariable {
+			f.name = f.tkns[1].v
+		}
+	case f.tkns[0].t == tmain:
+		f.main = true
+	case f.tkns[0].t == timport:
+		f.importDecl = true
+	case f.tkns[0].t == texport:
+		if len(f.tkns) > 1 && f.tkns[1].t == tvariable {
+			f.name = f.tkns[1].v
 		}
+	case f.tkns[0].t == tvariable:
+		f.name = f.tkns[0].v
 	}
+	s.functions = append(s.functions, *f)
+}
 
-	return functions, nil
+// dumpTokens writes every token in its own line as: file:line:col type value
+func dumpTokens(w io.Writer, functions []function) error {
+	for _, f := range functions {
+		for _, t := range f.tkns {
+			if _, err := fmt.Fprintf(w, "%v:%v:%v\t%v\t%v\n", f.file, t.line, t.col, t.t, t.v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
@@ -18,7 +18,7 @@ func Test_parseFiles(t *testing.T) {
 		{
 			name: "simple function declaration and call",
 			files: map[string]string{
-				"test.lwl": "f(x,y)=x+y\nf(1,2)\n",
+				"test.lwl": "f(x,y)=x+y\nmain = f(1,2)\n",
 			},
 			wantFuncs: []function{
 				{
@@ -41,6 +41,8 @@ func Test_parseFiles(t *testing.T) {
 				{
 					line: 2,
 					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
 						{t: tvariable, v: "f"},
 						{t: tlparenth, v: "("},
 						{t: tconstant, v: "1"},
@@ -62,13 +64,15 @@ func Test_parseFiles(t *testing.T) {
 		{
 			name: "simple addition in main",
 			files: map[string]string{
-				"addition.lwl": "1+3+1\n",
+				"addition.lwl": "main = 1+3+1\n",
 			},
 			wantFuncs: []function{
 				{
 					line: 1,
 					file: "addition.lwl",
 					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
 						{t: tconstant, v: "1"},
 						{t: tadd, v: "+"},
 						{t: tconstant, v: "3"},
@@ -79,6 +83,388 @@ func Test_parseFiles(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "multi character identifiers",
+			files: map[string]string{
+				"names.lwl": "add_Two(x1, Y_)=x1+Y_\n_start(a)=add_Two(a,10)\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 1,
+					tkns: []token{
+						{t: tvariable, v: "add_Two"},
+						{t: tlparenth, v: "("},
+						{t: tvariable, v: "x1"},
+						{t: tcomma, v: ","},
+						{t: tvariable, v: "Y_"},
+						{t: trparenth, v: ")"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "x1"},
+						{t: tadd, v: "+"},
+						{t: tvariable, v: "Y_"},
+					},
+					name: "add_Two",
+				},
+				{
+					line: 2,
+					tkns: []token{
+						{t: tvariable, v: "_start"},
+						{t: tlparenth, v: "("},
+						{t: tvariable, v: "a"},
+						{t: trparenth, v: ")"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "add_Two"},
+						{t: tlparenth, v: "("},
+						{t: tvariable, v: "a"},
+						{t: tcomma, v: ","},
+						{t: tconstant, v: "10"},
+						{t: trparenth, v: ")"},
+					},
+					name: "_start",
+				},
+			},
+		},
+		{
+			name: "spaces split identifiers and constants",
+			files: map[string]string{
+				"spaces.lwl": "main = ab cd 12 34 5x\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 1,
+					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "ab"},
+						{t: tvariable, v: "cd"},
+						{t: tconstant, v: "12"},
+						{t: tconstant, v: "34"},
+						{t: tconstant, v: "5"},
+						{t: tvariable, v: "x"},
+					},
+					main: true,
+				},
+			},
+		},
+		{
+			name: "struct declaration and field access",
+			files: map[string]string{
+				"struct.lwl": "struct p { x:u8 }\nmain = p{x: 1}.x\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 1,
+					tkns: []token{
+						{t: tstruct, v: "struct"},
+						{t: tvariable, v: "p"},
+						{t: tlbrace, v: "{"},
+						{t: tvariable, v: "x"},
+						{t: tcolon, v: ":"},
+						{t: tvariable, v: "u8"},
+						{t: trbrace, v: "}"},
+					},
+					name:       "p",
+					structDecl: true,
+				},
+				{
+					line: 2,
+					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "p"},
+						{t: tlbrace, v: "{"},
+						{t: tvariable, v: "x"},
+						{t: tcolon, v: ":"},
+						{t: tconstant, v: "1"},
+						{t: trbrace, v: "}"},
+						{t: tdot, v: "."},
+						{t: tvariable, v: "x"},
+					},
+					main: true,
+				},
+			},
+		},
+		{
+			name: "strings with escapes and '=' are single tokens",
+			files: map[string]string{
+				"strings.lwl": "main = len(\"a = \\\"b\\\"\") + 1\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 1,
+					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "len"},
+						{t: tlparenth, v: "("},
+						{t: tstring, v: `"a = \"b\""`},
+						{t: trparenth, v: ")"},
+						{t: tadd, v: "+"},
+						{t: tconstant, v: "1"},
+					},
+					main: true,
+				},
+			},
+		},
+		{
+			name: "comparisons and conditionals",
+			files: map[string]string{
+				"conditions.lwl": "f(x) = x<=1 ? x==0 : x >= 2 != (x<3) > 1\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 1,
+					tkns: []token{
+						{t: tvariable, v: "f"},
+						{t: tlparenth, v: "("},
+						{t: tvariable, v: "x"},
+						{t: trparenth, v: ")"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "x"},
+						{t: tle, v: "<="},
+						{t: tconstant, v: "1"},
+						{t: tquestion, v: "?"},
+						{t: tvariable, v: "x"},
+						{t: teqeq, v: "=="},
+						{t: tconstant, v: "0"},
+						{t: tcolon, v: ":"},
+						{t: tvariable, v: "x"},
+						{t: tge, v: ">="},
+						{t: tconstant, v: "2"},
+						{t: tneq, v: "!="},
+						{t: tlparenth, v: "("},
+						{t: tvariable, v: "x"},
+						{t: tlt, v: "<"},
+						{t: tconstant, v: "3"},
+						{t: trparenth, v: ")"},
+						{t: tgt, v: ">"},
+						{t: tconstant, v: "1"},
+					},
+					name: "f",
+				},
+			},
+		},
+		{
+			name: "a comparison is no '='",
+			files: map[string]string{
+				"main.lwl": "main = 1 == 1\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 1,
+					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
+						{t: tconstant, v: "1"},
+						{t: teqeq, v: "=="},
+						{t: tconstant, v: "1"},
+					},
+					main: true,
+				},
+			},
+		},
+		{
+			name: "keywords are whole names only",
+			files: map[string]string{
+				"main.lwl": "mainly(x) = x\nmain = mainly(1)\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 1,
+					tkns: []token{
+						{t: tvariable, v: "mainly"},
+						{t: tlparenth, v: "("},
+						{t: tvariable, v: "x"},
+						{t: trparenth, v: ")"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "x"},
+					},
+					name: "mainly",
+				},
+				{
+					line: 2,
+					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "mainly"},
+						{t: tlparenth, v: "("},
+						{t: tconstant, v: "1"},
+						{t: trparenth, v: ")"},
+					},
+					main: true,
+				},
+			},
+		},
+		{
+			name: "a line without '=' is no main",
+			files: map[string]string{
+				"main.lwl": "f(1)\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 1,
+					tkns: []token{
+						{t: tvariable, v: "f"},
+						{t: tlparenth, v: "("},
+						{t: tconstant, v: "1"},
+						{t: trparenth, v: ")"},
+					},
+					name: "f",
+				},
+			},
+		},
+		{
+			name: "unterminated string",
+			files: map[string]string{
+				"strings.lwl": "f(x) = x + \"ab\\\"\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 1,
+					tkns: []token{
+						{t: tvariable, v: "f"},
+						{t: tlparenth, v: "("},
+						{t: tvariable, v: "x"},
+						{t: trparenth, v: ")"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "x"},
+						{t: tadd, v: "+"},
+					},
+					name: "f",
+				},
+			},
+		},
+		{
+			name: "line comments",
+			files: map[string]string{
+				"comments.lwl": "# squares\nf(x) = x * x // x times x\n// main = 2\nmain = f(\"#//\")\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 2,
+					tkns: []token{
+						{t: tvariable, v: "f"},
+						{t: tlparenth, v: "("},
+						{t: tvariable, v: "x"},
+						{t: trparenth, v: ")"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "x"},
+						{t: tmul, v: "*"},
+						{t: tvariable, v: "x"},
+					},
+					name: "f",
+				},
+				{
+					line: 4,
+					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "f"},
+						{t: tlparenth, v: "("},
+						{t: tstring, v: "\"#//\""},
+						{t: trparenth, v: ")"},
+					},
+					main: true,
+				},
+			},
+		},
+		{
+			name: "block comments",
+			files: map[string]string{
+				"comments.lwl": "/* squares\n   f(x) = x */ f(x) = x /* times */ * x\nmain = 1 / 2 /*/ 3 */\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 2,
+					tkns: []token{
+						{t: tvariable, v: "f"},
+						{t: tlparenth, v: "("},
+						{t: tvariable, v: "x"},
+						{t: trparenth, v: ")"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "x"},
+						{t: tmul, v: "*"},
+						{t: tvariable, v: "x"},
+					},
+					name: "f",
+				},
+				{
+					line: 3,
+					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
+						{t: tconstant, v: "1"},
+						{t: tdiv, v: "/"},
+						{t: tconstant, v: "2"},
+					},
+					main: true,
+				},
+			},
+		},
+		{
+			name: "open parenthesis and trailing operators continue the line",
+			files: map[string]string{
+				"continued.lwl": "f(x,\n  y) =\n\n  # the sum\n  x +\n  y\nmain = f(1, 2)\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 1,
+					tkns: []token{
+						{t: tvariable, v: "f"},
+						{t: tlparenth, v: "("},
+						{t: tvariable, v: "x"},
+						{t: tcomma, v: ","},
+						{t: tvariable, v: "y"},
+						{t: trparenth, v: ")"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "x"},
+						{t: tadd, v: "+"},
+						{t: tvariable, v: "y"},
+					},
+					name: "f",
+				},
+				{
+					line: 7,
+					tkns: []token{
+						{t: tmain, v: "main"},
+						{t: teq, v: "="},
+						{t: tvariable, v: "f"},
+						{t: tlparenth, v: "("},
+						{t: tconstant, v: "1"},
+						{t: tcomma, v: ","},
+						{t: tconstant, v: "2"},
+						{t: trparenth, v: ")"},
+					},
+					main: true,
+				},
+			},
+		},
+		{
+			name: "braces continue a struct declaration",
+			files: map[string]string{
+				"structs.lwl": "struct p {\n  x:u8,\n  y:u8\n}\n",
+			},
+			wantFuncs: []function{
+				{
+					line: 1,
+					tkns: []token{
+						{t: tstruct, v: "struct"},
+						{t: tvariable, v: "p"},
+						{t: tlbrace, v: "{"},
+						{t: tvariable, v: "x"},
+						{t: tcolon, v: ":"},
+						{t: tvariable, v: "u8"},
+						{t: tcomma, v: ","},
+						{t: tvariable, v: "y"},
+						{t: tcolon, v: ":"},
+						{t: tvariable, v: "u8"},
+						{t: trbrace, v: "}"},
+					},
+					name:       "p",
+					structDecl: true,
+				},
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -111,6 +497,9 @@ func Test_parseFiles(t *testing.T) {
 				if tc.wantFuncs[i].name != got[i].name {
 					t.Errorf("function %d name = %v, want %v", i, got[i].name, tc.wantFuncs[i].name)
 				}
+				if tc.wantFuncs[i].main != got[i].main || tc.wantFuncs[i].structDecl != got[i].structDecl {
+					t.Errorf("function %d main, structDecl = %v, %v, want %v, %v", i, got[i].main, got[i].structDecl, tc.wantFuncs[i].main, tc.wantFuncs[i].structDecl)
+				}
 				if tc.wantFuncs[i].line != got[i].line {
 					t.Errorf("function %d line = %v, want %v", i, got[i].line, tc.wantFuncs[i].line)
 				}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// The types of the language: integers, and structs and arrays of integers. Whatever its type, every
+// integer lives in a whole 64 bit register or stack slot, sign or zero extended from its width, so
+// widening a value is free. Structs and arrays live in memory laid out just like C does.
+
+type typeKind int
+
+const (
+	kindInt typeKind = iota
+	kindStruct
+	kindArray
+)
+
+type lwlType struct {
+	position // where a struct was declared
+	kind     typeKind
+	name     string
+	bits     int  // integers only
+	signed   bool // integers only
+	fields   []*field
+	elem     *lwlType // arrays only
+	length   int      // arrays only
+	size     int      // in bytes
+	align    int
+}
+
+// field is a named integer inside a struct, at offset bytes from its start
+type field struct {
+	name   string
+	typ    *lwlType
+	offset int
+}
+
+var (
+	typeI8  = newIntType("i8", 8, true)
+	typeI16 = newIntType("i16", 16, true)
+	typeI32 = newIntType("i32", 32, true)
+	typeI64 = newIntType("i64", 64, true)
+	typeU8  = newIntType("u8", 8, false)
+	typeU16 = newIntType("u16", 16, false)
+	typeU32 = newIntType("u32", 32, false)
+	typeU64 = newIntType("u64", 64, false)
+)
+
+func newIntType(name string, bits int, signed bool) *lwlType {
+	return &lwlType{kind: kindInt, name: name, bits: bits, signed: signed, size: bits / 8, align: bits / 8}
+}
+
+// newStructType lays out the fields one after the other, each one aligned to its own size
+func newStructType(p position, name string, fields []*field) *lwlType {
+	t := &lwlType{position: p, kind: kindStruct, name: name, fields: fields, align: 1}
+	for _, f := range fields {
+		t.size = (t.size + f.typ.align - 1) / f.typ.align * f.typ.align
+		f.offset = t.size
+		t.size += f.typ.size
+		t.align = max(t.align, f.typ.align)
+	}
+	t.size = (t.size + t.align - 1) / t.align * t.align
+	return t
+}
+
+// maxArrayLength keeps arrays, which live in the stack, to a reasonable size
+const maxArrayLength = 65536
+
+// newArrayType is an array of length integers of type elem, one right after the other
+func newArrayType(elem *lwlType, length int) *lwlType {
+	return &lwlType{
+		kind:   kindArray,
+		name:   fmt.Sprintf("[%v]%v", length, elem),
+		elem:   elem,
+		length: length,
+		size:   elem.size * length,
+		align:  elem.align,
+	}
+}
+
+func (t *lwlType) isInt() bool {
+	return t.kind == kindInt
+}
+
+// describe names the type for the diagnostics, telling structs apart from the rest
+func (t *lwlType) describe() string {
+	if t.kind == kindStruct {
+		return "struct " + t.name
+	}
+	return t.name
+}
+
+// field returns the field called name, if any
+func (t *lwlType) field(name string) (*field, bool) {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// eightbytes is the number of registers a struct or array is passed or returned in following the System V ABI
+// classification, as if arrays were wrapped in a struct: every field or element is an integer so each eightbyte
+// is of the INTEGER class, and anything bigger than two of them is of the MEMORY class and goes through memory
+// instead, returning 0
+func (t *lwlType) eightbytes() int {
+	if t.size > 16 {
+		return 0
+	}
+	return (t.size + 7) / 8
+}
+
+// intTypes are the types by the name they are written with
+var intTypes = map[string]*lwlType{
+	"i8": typeI8, "i16": typeI16, "i32": typeI32, "i64": typeI64,
+	"u8": typeU8, "u16": typeU16, "u32": typeU32, "u64": typeU64,
+}
+
+// typeNames lists the types for the diagnostics
+const typeNames = "i8, i16, i32, i64, u8, u16, u32 and u64"
+
+func (t *lwlType) String() string {
+	return t.name
+}
+
+// declaration renders a struct as it is declared: struct name {field:type, ...}
+func (t *lwlType) declaration() string {
+	fields := make([]string, 0, len(t.fields))
+	for _, f := range t.fields {
+		fields = append(fields, f.name+annotation(f.typ))
+	}
+	return "struct " + t.name + " {" + strings.Join(fields, ", ") + "}"
+}
+
+// wrap truncates v to the width of the type and extends it back to 64 bits, just like the hardware
+func (t *lwlType) wrap(v int64) int64 {
+	shift := 64 - t.bits
+	if t.signed {
+		return v << shift >> shift
+	}
+	return int64(uint64(v) << shift >> shift)
+}
+
+// fits reports if the constant v is a value of the type
+func (t *lwlType) fits(v int64) bool {
+	return v == t.wrap(v) && (t.signed || v >= 0)
+}
+
+// bounds describes the values of the type
+func (t *lwlType) bounds() string {
+	switch {
+	case t.signed:
+		return fmt.Sprintf("%v holds values from %v to %v", t, int64(math.MinInt64)>>(64-t.bits), int64(math.MaxInt64)>>(64-t.bits))
+	case t.bits == 64:
+		return fmt.Sprintf("%v holds values from 0 to %v", t, uint64(math.MaxUint64))
+	}
+	return fmt.Sprintf("%v holds values from 0 to %v", t, uint64(1)<<t.bits-1)
+}
+
+// widensTo reports if every value of t is a value of u, so t can be used wherever u is expected
+func (t *lwlType) widensTo(u *lwlType) bool {
+	if !t.isInt() || !u.isInt() {
+		return t == u
+	}
+	if t.signed == u.signed {
+		return t.bits <= u.bits
+	}
+	return !t.signed && t.bits < u.bits
+}
+
+// commonType is the type both sides of a binary operation are widened to, if any
+func commonType(a, b *lwlType) (*lwlType, bool) {
+	switch {
+	case a.widensTo(b):
+		return b, true
+	case b.widensTo(a):
+		return a, true
+	}
+	return nil, false
+}